@@ -0,0 +1,113 @@
+package apistore
+
+import (
+	"sync"
+	"time"
+)
+
+const idleCheckInterval = time.Minute
+
+// KeyExpirer revokes keys from a store once they have gone unused for
+// their APIKeyConfig.KeyTTL. Revoking goes through Store.RevokeKey, so the
+// store's Resetter clears the key's rate-limit state and its subscribers
+// are told as usual. Keys with a zero KeyTTL never expire.
+type KeyExpirer struct {
+	store     Store
+	onExpired func(key string)
+	mutex     sync.Mutex
+	lastUsed  map[string]time.Time
+	stop      chan struct{}
+	stopped   sync.Once
+}
+
+// NewKeyExpirer starts checking store's keys in the background. onExpired,
+// if not nil, is called after each key is revoked. Feed it usage by passing
+// Touch to the rate limiter, e.g. services.WithKeyUsage(expirer.Touch).
+func NewKeyExpirer(store Store, onExpired func(key string)) *KeyExpirer {
+	e := &KeyExpirer{
+		store:     store,
+		onExpired: onExpired,
+		lastUsed:  make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}
+
+	go e.run()
+	return e
+}
+
+// Touch records that key was just used.
+func (e *KeyExpirer) Touch(key string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.lastUsed[key] = time.Now()
+}
+
+func (e *KeyExpirer) Stop() {
+	e.stopped.Do(func() { close(e.stop) })
+}
+
+// run checks every half of the shortest TTL in the store, so no key
+// outlives its TTL by more than half again.
+func (e *KeyExpirer) run() {
+	timer := time.NewTimer(e.expire())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-timer.C:
+			timer.Reset(e.expire())
+		}
+	}
+}
+
+// expire revokes every idle key and returns how long to wait before the
+// next check. A key that has never been used counts from when the expirer
+// first saw it.
+func (e *KeyExpirer) expire() time.Duration {
+	keys := e.store.GetApiKeys()
+	now := time.Now()
+	interval := idleCheckInterval
+
+	var expired []string
+	e.mutex.Lock()
+	for key, cfg := range keys {
+		if cfg.KeyTTL <= 0 {
+			continue
+		}
+		interval = min(interval, max(cfg.KeyTTL/2, time.Millisecond))
+
+		lastUsed, seen := e.lastUsed[key]
+		if !seen {
+			e.lastUsed[key] = now
+			continue
+		}
+		if now.Sub(lastUsed) > cfg.KeyTTL {
+			expired = append(expired, key)
+		}
+	}
+	for key := range e.lastUsed {
+		if _, exists := keys[key]; !exists {
+			delete(e.lastUsed, key)
+		}
+	}
+	e.mutex.Unlock()
+
+	for _, key := range expired {
+		if err := e.store.RevokeKey(key); err != nil {
+			continue
+		}
+
+		e.mutex.Lock()
+		delete(e.lastUsed, key)
+		e.mutex.Unlock()
+
+		if e.onExpired != nil {
+			e.onExpired(key)
+		}
+	}
+
+	return interval
+}
@@ -0,0 +1,89 @@
+package apistore
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingResetter struct {
+	mutex sync.Mutex
+	keys  []string
+}
+
+func (r *recordingResetter) Reset(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+func (r *recordingResetter) reset() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.keys...)
+}
+
+func newExpiringStore(ttl time.Duration) (*InMemoryStore, *recordingResetter) {
+	store := NewInMemoryStore(map[string]APIKeyConfig{
+		"expiring":  {KeyTTL: ttl},
+		"permanent": {},
+	})
+	resetter := &recordingResetter{}
+	store.SetResetter(resetter)
+
+	return store, resetter
+}
+
+func TestKeyExpirer(t *testing.T) {
+	store, resetter := newExpiringStore(40 * time.Millisecond)
+	expired := make(chan string, 1)
+	expirer := NewKeyExpirer(store, func(key string) { expired <- key })
+	defer expirer.Stop()
+
+	select {
+	case key := <-expired:
+		if key != "expiring" {
+			t.Errorf("OnKeyExpired(%q), want expiring", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle key not expired")
+	}
+
+	keys := store.GetApiKeys()
+	if _, exists := keys["expiring"]; exists {
+		t.Error("expired key still in the store")
+	}
+	if _, exists := keys["permanent"]; !exists {
+		t.Error("key without a TTL was revoked")
+	}
+	if got := resetter.reset(); len(got) != 1 || got[0] != "expiring" {
+		t.Errorf("Reset called for %v, want only the expired key", got)
+	}
+}
+
+func TestKeyExpirerTouchPreventsExpiry(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	store, _ := newExpiringStore(ttl)
+	expired := make(chan string, 1)
+	expirer := NewKeyExpirer(store, func(key string) { expired <- key })
+	defer expirer.Stop()
+
+	// Each use lands well before the deadline set by the previous one.
+	for deadline := time.Now().Add(3 * ttl); time.Now().Before(deadline); {
+		expirer.Touch("expiring")
+		select {
+		case key := <-expired:
+			t.Fatalf("%s expired while in use", key)
+		case <-time.After(ttl / 4):
+		}
+	}
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("key not expired once it stopped being used")
+	}
+}
@@ -1,10 +1,302 @@
 package apistore
 
-func GetApiKeys() map[string]bool {
-	KEYS := map[string]bool{
-		"apikey123": true,
-		"apikey124": true,
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEmptyKey    = errors.New("api key must not be empty")
+	ErrKeyNotFound = errors.New("api key not found")
+)
+
+type APIKeyConfig struct {
+	MaxLimit      int `json:"maxLimit,omitempty"`
+	WindowSeconds int `json:"windowSeconds,omitempty"`
+	// Plan is the key's billing tier, such as "free" or "pro". Metrics are
+	// labelled with it instead of the key.
+	Plan string `json:"plan,omitempty"`
+	// KeyTTL revokes the key once it has gone unused this long, when a
+	// KeyExpirer is watching the store. Zero keeps it forever.
+	KeyTTL time.Duration `json:"keyTTL,omitempty"`
+	// Labels group keys for policies that select them, such as
+	// Kubernetes RateLimitPolicy resources.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Resetter clears any rate-limit state held for a key. RateLimiter
+// satisfies it; a store calls it when a key is revoked.
+type Resetter interface {
+	Reset(key string) error
+}
+
+type Store interface {
+	GetApiKeys() map[string]APIKeyConfig
+	RegisterKey(key string, config APIKeyConfig) error
+	RevokeKey(key string) error
+	SetResetter(resetter Resetter)
+	// Subscribe calls onChange after every successful RegisterKey or
+	// RevokeKey, outside the store's lock, until unsubscribe is called.
+	Subscribe(onChange func()) (unsubscribe func())
+}
+
+var (
+	defaultMutex sync.RWMutex
+	defaultStore Store = NewInMemoryStore(map[string]APIKeyConfig{
+		"apikey123": {},
+		"apikey124": {},
+	})
+)
+
+// Default returns the store backing GetApiKeys.
+func Default() Store {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+
+	return defaultStore
+}
+
+func SetDefault(store Store) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	defaultStore = store
+}
+
+func GetApiKeys() map[string]APIKeyConfig {
+	return Default().GetApiKeys()
+}
+
+type InMemoryStore struct {
+	mutex     sync.RWMutex
+	keys      map[string]APIKeyConfig
+	resetter  Resetter
+	listeners listeners
+}
+
+func NewInMemoryStore(keys map[string]APIKeyConfig) *InMemoryStore {
+	store := &InMemoryStore{keys: maps.Clone(keys)}
+	if store.keys == nil {
+		store.keys = make(map[string]APIKeyConfig)
+	}
+
+	return store
+}
+
+func (s *InMemoryStore) GetApiKeys() map[string]APIKeyConfig {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return maps.Clone(s.keys)
+}
+
+func (s *InMemoryStore) RegisterKey(key string, config APIKeyConfig) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+
+	s.mutex.Lock()
+	s.keys[key] = config
+	s.mutex.Unlock()
+
+	s.listeners.notify()
+	return nil
+}
+
+func (s *InMemoryStore) RevokeKey(key string) error {
+	s.mutex.Lock()
+	if _, exists := s.keys[key]; !exists {
+		s.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	delete(s.keys, key)
+	resetter := s.resetter
+	s.mutex.Unlock()
+
+	resetRevoked(resetter, key)
+	s.listeners.notify()
+	return nil
+}
+
+func (s *InMemoryStore) SetResetter(resetter Resetter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.resetter = resetter
+}
+
+func (s *InMemoryStore) Subscribe(onChange func()) func() {
+	return s.listeners.add(onChange)
+}
+
+// FileStore keeps keys in memory and writes the full set to a JSON file
+// after every change. Writes go to a temporary file that is renamed over
+// the original, so readers never see a partial file.
+type FileStore struct {
+	mutex     sync.RWMutex
+	path      string
+	keys      map[string]APIKeyConfig
+	resetter  Resetter
+	listeners listeners
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{path: path, keys: make(map[string]APIKeyConfig)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read key store: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &store.keys); err != nil {
+		return nil, fmt.Errorf("parse key store %s: %w", path, err)
+	}
+
+	return store, nil
+}
+
+func (s *FileStore) GetApiKeys() map[string]APIKeyConfig {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return maps.Clone(s.keys)
+}
+
+func (s *FileStore) RegisterKey(key string, config APIKeyConfig) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+
+	s.mutex.Lock()
+	keys := maps.Clone(s.keys)
+	keys[key] = config
+	if err := s.persist(keys); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	s.keys = keys
+	s.mutex.Unlock()
+
+	s.listeners.notify()
+	return nil
+}
+
+func (s *FileStore) RevokeKey(key string) error {
+	s.mutex.Lock()
+	if _, exists := s.keys[key]; !exists {
+		s.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	keys := maps.Clone(s.keys)
+	delete(keys, key)
+	if err := s.persist(keys); err != nil {
+		s.mutex.Unlock()
+		return err
 	}
+	s.keys = keys
+	resetter := s.resetter
+	s.mutex.Unlock()
 
-	return KEYS
-}
\ No newline at end of file
+	resetRevoked(resetter, key)
+	s.listeners.notify()
+	return nil
+}
+
+func (s *FileStore) SetResetter(resetter Resetter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.resetter = resetter
+}
+
+func (s *FileStore) Subscribe(onChange func()) func() {
+	return s.listeners.add(onChange)
+}
+
+func (s *FileStore) persist(keys map[string]APIKeyConfig) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode key store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write key store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write key store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write key store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write key store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("write key store: %w", err)
+	}
+
+	return nil
+}
+
+// resetRevoked clears the limiter state of a revoked key. It runs outside
+// the store lock; a key the limiter never saw has nothing to reset, so the
+// error is dropped.
+func resetRevoked(resetter Resetter, key string) {
+	if resetter != nil {
+		resetter.Reset(key)
+	}
+}
+
+// listeners holds a store's Subscribe callbacks. It has its own lock so a
+// callback can read the store, and even subscribe, while being notified.
+type listeners struct {
+	mutex     sync.Mutex
+	next      uint64
+	callbacks map[uint64]func()
+}
+
+func (l *listeners) add(onChange func()) func() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.callbacks == nil {
+		l.callbacks = make(map[uint64]func())
+	}
+	id := l.next
+	l.next++
+	l.callbacks[id] = onChange
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		delete(l.callbacks, id)
+	}
+}
+
+func (l *listeners) notify() {
+	l.mutex.Lock()
+	callbacks := slices.Collect(maps.Values(l.callbacks))
+	l.mutex.Unlock()
+
+	for _, onChange := range callbacks {
+		onChange()
+	}
+}
@@ -0,0 +1,172 @@
+package apistore
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// storeFactories builds each Store implementation empty. The second
+// result, if not nil, reopens the store from wherever it persists to.
+var storeFactories = []struct {
+	name string
+	new  func(t *testing.T) (Store, func() Store)
+}{
+	{
+		name: "in memory",
+		new: func(t *testing.T) (Store, func() Store) {
+			return NewInMemoryStore(nil), nil
+		},
+	},
+	{
+		name: "file",
+		new: func(t *testing.T) (Store, func() Store) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			store, err := NewFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopen := func() Store {
+				store, err := NewFileStore(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			}
+			return store, reopen
+		},
+	},
+}
+
+func TestStoreConcurrentRegisterAndRevoke(t *testing.T) {
+	const keys = 64
+
+	for _, tt := range storeFactories {
+		t.Run(tt.name, func(t *testing.T) {
+			store, reopen := tt.new(t)
+			resetter := &recordingResetter{}
+			store.SetResetter(resetter)
+			var changes atomic.Int64
+			store.Subscribe(func() { changes.Add(1) })
+
+			// Register every key, then revoke the even ones while the odd
+			// ones are registered again with a plan.
+			var wg sync.WaitGroup
+			for i := range keys {
+				wg.Go(func() {
+					if err := store.RegisterKey(fmt.Sprintf("key-%d", i), APIKeyConfig{}); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+			wg.Wait()
+			for i := range keys {
+				wg.Go(func() {
+					key := fmt.Sprintf("key-%d", i)
+					var err error
+					if i%2 == 0 {
+						err = store.RevokeKey(key)
+					} else {
+						err = store.RegisterKey(key, APIKeyConfig{Plan: "pro"})
+					}
+					if err != nil {
+						t.Error(err)
+					}
+				})
+			}
+			wg.Wait()
+
+			want := make(map[string]APIKeyConfig)
+			var revoked []string
+			for i := range keys {
+				key := fmt.Sprintf("key-%d", i)
+				if i%2 == 0 {
+					revoked = append(revoked, key)
+				} else {
+					want[key] = APIKeyConfig{Plan: "pro"}
+				}
+			}
+
+			if got := store.GetApiKeys(); !maps.EqualFunc(got, want, sameConfig) {
+				t.Errorf("GetApiKeys() has %d keys, want the %d odd ones with plan pro", len(got), len(want))
+			}
+			if reopen != nil {
+				if got := reopen().GetApiKeys(); !maps.EqualFunc(got, want, sameConfig) {
+					t.Errorf("file holds %d keys, want the %d odd ones with plan pro", len(got), len(want))
+				}
+			}
+			if got := resetter.reset(); !slices.Equal(sorted(got), sorted(revoked)) {
+				t.Errorf("Resetter called for %d keys, want the %d revoked", len(got), len(revoked))
+			}
+			if got := changes.Load(); got != 2*keys {
+				t.Errorf("subscriber told of %d changes, want %d", got, 2*keys)
+			}
+		})
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	for _, tt := range storeFactories {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := tt.new(t)
+			resetter := &recordingResetter{}
+			store.SetResetter(resetter)
+			var changes atomic.Int64
+			store.Subscribe(func() { changes.Add(1) })
+
+			if err := store.RegisterKey("", APIKeyConfig{}); !errors.Is(err, ErrEmptyKey) {
+				t.Errorf("RegisterKey(\"\"): err = %v, want ErrEmptyKey", err)
+			}
+			if err := store.RevokeKey("missing"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("RevokeKey(missing): err = %v, want ErrKeyNotFound", err)
+			}
+			if got := resetter.reset(); len(got) != 0 {
+				t.Errorf("failed revoke reset %q", got)
+			}
+			if got := changes.Load(); got != 0 {
+				t.Errorf("failed calls told the subscriber of %d changes", got)
+			}
+		})
+	}
+}
+
+func TestStoreUnsubscribe(t *testing.T) {
+	for _, tt := range storeFactories {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := tt.new(t)
+			var first, second atomic.Int64
+			unsubscribe := store.Subscribe(func() { first.Add(1) })
+			store.Subscribe(func() {
+				second.Add(1)
+				// Subscribers may read the store while being notified.
+				store.GetApiKeys()
+			})
+
+			store.RegisterKey("a", APIKeyConfig{})
+			unsubscribe()
+			store.RegisterKey("b", APIKeyConfig{})
+
+			if got := first.Load(); got != 1 {
+				t.Errorf("unsubscribed callback called %d times, want 1", got)
+			}
+			if got := second.Load(); got != 2 {
+				t.Errorf("remaining callback called %d times, want 2", got)
+			}
+		})
+	}
+}
+
+func sameConfig(a, b APIKeyConfig) bool {
+	return a.MaxLimit == b.MaxLimit && a.WindowSeconds == b.WindowSeconds && a.Plan == b.Plan && a.KeyTTL == b.KeyTTL
+}
+
+func sorted(keys []string) []string {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	return keys
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"rate-limiter/services"
+	"strings"
 )
 
 func main() {
-	rateLimiter := services.NewRateLimiter(5, 60) // 5 requests per 60 seconds
+	configPath := flag.String("config", "", "path to a YAML config file")
+	flag.Parse()
+
+	cfg, err := services.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	helloHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Hello World")
@@ -18,9 +26,38 @@ func main() {
 		fmt.Fprintf(w, "Welcome to the World")
 	})
 
-	http.Handle("/hello", services.RateLimiterMiddleware(helloHandler, rateLimiter))
-	http.Handle("/world", services.RateLimiterMiddleware(worldHandler, rateLimiter))
+	router := services.NewRouter(cfg.LimiterConfig())
+	mux := http.NewServeMux()
+	mux.Handle("/", router)
+
+	routes := []struct {
+		pattern string
+		handler http.Handler
+	}{
+		{"/hello", helloHandler},
+		{"/world", worldHandler},
+	}
+	for _, route := range routes {
+		limiter, err := newRouteLimiter(cfg, route.pattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		router.HandleWithLimiter(route.pattern, route.handler, limiter)
+
+		// Validate only accepts AdminToken with the in-memory token bucket.
+		if rl, ok := limiter.(*services.RateLimiter); ok && cfg.AdminToken != "" {
+			mux.Handle(route.pattern+"/admin/", http.StripPrefix(route.pattern, services.NewAdminServer(rl, cfg.AdminToken)))
+		}
+	}
+
+	fmt.Println("Server started on " + cfg.ListenAddr)
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, mux))
+}
 
-	fmt.Println("Server started on :8083")
-	log.Fatal(http.ListenAndServe(":8083", nil))
-}
\ No newline at end of file
+// newRouteLimiter builds the configured limiter for one route, so a flood
+// on one route cannot use up another's quota. Redis counters get a prefix
+// of their own for the same reason.
+func newRouteLimiter(cfg services.Config, pattern string) (services.Limiter, error) {
+	cfg.RedisKeyPrefix += strings.TrimPrefix(pattern, "/") + ":"
+	return services.NewRateLimiterFromConfig(cfg)
+}
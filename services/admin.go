@@ -0,0 +1,164 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	apistore "rate-limiter/api-store"
+)
+
+const (
+	adminLimitsPath   = "/admin/limits/"
+	adminKeysPath     = "/admin/keys/"
+	adminStatusPath   = "/admin/status"
+	adminSnapshotPath = "/admin/snapshot"
+)
+
+type AdminServer struct {
+	limiter *RateLimiter
+	token   string
+	mux     *http.ServeMux
+}
+
+func NewAdminServer(limiter *RateLimiter, token string) *AdminServer {
+	admin := &AdminServer{
+		limiter: limiter,
+		token:   token,
+		mux:     http.NewServeMux(),
+	}
+
+	admin.mux.HandleFunc(adminLimitsPath, admin.handleLimits)
+	admin.mux.HandleFunc(adminKeysPath, admin.handleKeys)
+	admin.mux.Handle(adminStatusPath, StatusHandler(limiter))
+	admin.mux.Handle(adminSnapshotPath, SnapshotHandler(limiter))
+
+	// Revoking a key through the store should also drop its bucket.
+	apistore.Default().SetResetter(limiter)
+	return admin
+}
+
+func (a *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *AdminServer) authorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || a.token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *AdminServer) handleLimits(w http.ResponseWriter, r *http.Request) {
+	apiKey := strings.TrimPrefix(r.URL.Path, adminLimitsPath)
+	if apiKey == "" || strings.Contains(apiKey, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		a.setLimit(w, r, apiKey)
+	case http.MethodDelete:
+		a.reset(w, r, apiKey)
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *AdminServer) setLimit(w http.ResponseWriter, r *http.Request, apiKey string) {
+	var cfg LimitConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if cfg.MaxLimit <= 0 || cfg.WindowSeconds <= 0 {
+		http.Error(w, "maxLimit and windowSeconds must be positive", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.limiter.SetLimitConfig(apiKey, cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminServer) reset(w http.ResponseWriter, r *http.Request, apiKey string) {
+	if err := a.limiter.Reset(apiKey); err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			http.Error(w, "Unknown API key", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminServer) handleKeys(w http.ResponseWriter, r *http.Request) {
+	apiKey := strings.TrimPrefix(r.URL.Path, adminKeysPath)
+	if apiKey == "" || strings.Contains(apiKey, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		a.registerKey(w, r, apiKey)
+	case http.MethodDelete:
+		a.revokeKey(w, r, apiKey)
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *AdminServer) registerKey(w http.ResponseWriter, r *http.Request, apiKey string) {
+	var cfg apistore.APIKeyConfig
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if cfg.MaxLimit < 0 || cfg.WindowSeconds < 0 {
+		http.Error(w, "maxLimit and windowSeconds must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := apistore.Default().RegisterKey(apiKey, cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminServer) revokeKey(w http.ResponseWriter, r *http.Request, apiKey string) {
+	if err := apistore.Default().RevokeKey(apiKey); err != nil {
+		if errors.Is(err, apistore.ErrKeyNotFound) {
+			http.Error(w, "Unknown API key", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const admissionPollInterval = time.Second
+
+// AdmissionController sheds load across all keys while the heap is under
+// pressure. Between lowWatermark and highWatermark bytes of heap it denies
+// a growing share of the requests the inner limiter allows, from none at low
+// to all at high. The inner limiter is charged for shed requests too, so a
+// shed client's quota drains as if it had been served and it cannot retry
+// at full rate the moment pressure eases.
+type AdmissionController struct {
+	inner        Limiter
+	low          uint64
+	high         uint64
+	mutex        sync.RWMutex
+	readMemStats func(*runtime.MemStats)
+	probability  float64
+	stop         chan struct{}
+	stopped      sync.Once
+}
+
+// NewAdmissionController samples runtime.ReadMemStats every second until
+// Stop.
+func NewAdmissionController(inner Limiter, lowWatermark, highWatermark uint64) *AdmissionController {
+	ac := &AdmissionController{
+		inner:        inner,
+		low:          lowWatermark,
+		high:         highWatermark,
+		readMemStats: runtime.ReadMemStats,
+		stop:         make(chan struct{}),
+	}
+	ac.sample()
+
+	go ac.run()
+	return ac
+}
+
+// SetMemStatsSource replaces runtime.ReadMemStats, e.g. with a fake for
+// tests, and samples it immediately.
+func (ac *AdmissionController) SetMemStatsSource(read func(*runtime.MemStats)) {
+	ac.mutex.Lock()
+	ac.readMemStats = read
+	ac.mutex.Unlock()
+
+	ac.sample()
+}
+
+func (ac *AdmissionController) Stop() {
+	ac.stopped.Do(func() { close(ac.stop) })
+}
+
+// SheddingProbability is the share of allowed requests currently denied.
+func (ac *AdmissionController) SheddingProbability() float64 {
+	ac.mutex.RLock()
+	defer ac.mutex.RUnlock()
+
+	return ac.probability
+}
+
+func (ac *AdmissionController) Allow(key string) (Result, error) {
+	return ac.AllowN(key, 1)
+}
+
+func (ac *AdmissionController) AllowN(key string, n int) (Result, error) {
+	return ac.AllowNContext(context.Background(), key, n)
+}
+
+func (ac *AdmissionController) AllowNContext(ctx context.Context, key string, n int) (Result, error) {
+	result, err := allowN(ctx, ac.inner, key, n)
+	if err != nil || !result.Allowed {
+		return result, err
+	}
+
+	if p := ac.SheddingProbability(); p > 0 && rand.Float64() < p {
+		result.Allowed = false
+		result.RetryAfter = admissionPollInterval
+	}
+	return result, nil
+}
+
+func (ac *AdmissionController) Peek(key string) Result {
+	return ac.inner.Peek(key)
+}
+
+func (ac *AdmissionController) run() {
+	ticker := time.NewTicker(admissionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ac.stop:
+			return
+		case <-ticker.C:
+			ac.sample()
+		}
+	}
+}
+
+func (ac *AdmissionController) sample() {
+	ac.mutex.RLock()
+	read := ac.readMemStats
+	ac.mutex.RUnlock()
+
+	var stats runtime.MemStats
+	read(&stats)
+	p := sheddingProbability(stats.HeapAlloc, ac.low, ac.high)
+
+	ac.mutex.Lock()
+	ac.probability = p
+	ac.mutex.Unlock()
+}
+
+func sheddingProbability(heapAlloc, low, high uint64) float64 {
+	switch {
+	case heapAlloc <= low:
+		return 0
+	case heapAlloc >= high:
+		return 1
+	default:
+		return float64(heapAlloc-low) / float64(high-low)
+	}
+}
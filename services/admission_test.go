@@ -0,0 +1,98 @@
+package services
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAdmissionController(t *testing.T) {
+	const low, high = 100 << 20, 200 << 20
+
+	tests := []struct {
+		name        string
+		heapAlloc   uint64
+		probability float64
+		allowed     int
+	}{
+		{name: "below low watermark", heapAlloc: 50 << 20, probability: 0, allowed: 10},
+		{name: "at low watermark", heapAlloc: low, probability: 0, allowed: 10},
+		{name: "halfway", heapAlloc: 150 << 20, probability: 0.5},
+		{name: "at high watermark", heapAlloc: high, probability: 1, allowed: 0},
+		{name: "above high watermark", heapAlloc: 400 << 20, probability: 1, allowed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+			ac := NewAdmissionController(rl, low, high)
+			t.Cleanup(ac.Stop)
+
+			var heapAlloc atomic.Uint64
+			heapAlloc.Store(tt.heapAlloc)
+			ac.SetMemStatsSource(func(stats *runtime.MemStats) {
+				stats.HeapAlloc = heapAlloc.Load()
+			})
+
+			if got := ac.SheddingProbability(); got != tt.probability {
+				t.Fatalf("SheddingProbability() = %v, want %v", got, tt.probability)
+			}
+
+			allowed := 0
+			for range 10 {
+				result, err := ac.Allow("apikey123")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Allowed {
+					allowed++
+				} else if result.RetryAfter <= 0 {
+					t.Errorf("denial %+v has no RetryAfter", result)
+				}
+			}
+			if tt.probability == 0 || tt.probability == 1 {
+				if allowed != tt.allowed {
+					t.Errorf("%d of 10 requests allowed, want %d", allowed, tt.allowed)
+				}
+			}
+
+			// Shed requests are charged to the inner limiter as well.
+			if peek := ac.Peek("apikey123"); peek.Remaining != 0 {
+				t.Errorf("inner limiter has %d tokens left, want 0", peek.Remaining)
+			}
+		})
+	}
+}
+
+func TestAdmissionControllerRecovers(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+	ac := NewAdmissionController(rl, 100, 200)
+	t.Cleanup(ac.Stop)
+
+	var heapAlloc atomic.Uint64
+	heapAlloc.Store(300)
+	read := func(stats *runtime.MemStats) { stats.HeapAlloc = heapAlloc.Load() }
+	ac.SetMemStatsSource(read)
+	if result, _ := ac.Allow("apikey123"); result.Allowed {
+		t.Fatal("request allowed above the high watermark")
+	}
+
+	heapAlloc.Store(50)
+	ac.sample()
+	if result, _ := ac.Allow("apikey123"); !result.Allowed {
+		t.Errorf("request denied after heap fell below the low watermark: %+v", result)
+	}
+}
+
+func TestAdmissionControllerPassesThroughDenials(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	ac := NewAdmissionController(rl, 100, 200)
+	t.Cleanup(ac.Stop)
+	ac.SetMemStatsSource(func(stats *runtime.MemStats) { stats.HeapAlloc = 0 })
+
+	ac.Allow("apikey123")
+	result, err := ac.Allow("apikey123")
+	if err != nil || result.Allowed {
+		t.Errorf("second request = %+v, %v, want the inner limiter's denial", result, err)
+	}
+}
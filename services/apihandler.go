@@ -1,34 +1,525 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
 	apistore "rate-limiter/api-store"
+	"runtime/debug"
+	"strconv"
+	"time"
 )
 
-func RateLimiterMiddleware(next http.Handler, limiter *RateLimiter) http.Handler {
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+type CostFunc func(*http.Request) int
+
+// KeyExtractorOption picks a request's cost once its key is known, for
+// policies that depend on who is calling. A result below 1 defers to
+// Options.CostFunc.
+type KeyExtractorOption func(r *http.Request, key string) int
+
+// ErrorBodyFunc writes the response for a rate-limited request. The
+// rate-limit headers are already set; it must write the status code itself.
+type ErrorBodyFunc func(w http.ResponseWriter, r *http.Request, result Result)
+
+// contextLimiter is implemented by limiters that can attach work to the
+// request context, such as the tracing wrapper built with the otel tag.
+type contextLimiter interface {
+	AllowNContext(ctx context.Context, key string, n int) (Result, error)
+}
+
+// returner is implemented by limiters that can hand a consumed token back,
+// such as RateLimiter.
+type returner interface {
+	Return(key string)
+}
+
+// capacityLimiter is implemented by limiters whose largest single request can
+// be smaller than their limit, such as RateLimiter with a Burst.
+type capacityLimiter interface {
+	Capacity(key string) int
+}
+
+// traceRequest continues an incoming distributed trace. It is set by otel.go
+// when the package is built with the otel tag.
+var traceRequest func(r *http.Request) *http.Request
+
+type Options struct {
+	OnError  ErrorHandler
+	CostFunc CostFunc
+	KeyCost  KeyExtractorOption
+	Logger   *slog.Logger
+	// Concurrency caps each key's requests in flight. A request over the
+	// cap is answered by ErrorBody, like a rate-limited one.
+	Concurrency *ConcurrencyLimiter
+	ErrorBody   ErrorBodyFunc
+	// RouteID names the policy in the X-RateLimit-Policy header. The
+	// header is only sent when it is set.
+	RouteID string
+	// Events receives an Event for every denied request, and for allowed
+	// ones too when PublishAllowed is set.
+	Events         Bus
+	PublishAllowed bool
+	// UseTrailers sends X-RateLimit-Remaining and X-RateLimit-Reset as
+	// trailers after the inner handler's body. Only HTTP/2 requests are
+	// affected; HTTP/1.x responses keep them as ordinary headers.
+	UseTrailers bool
+	// Recover turns a panic in the inner handler into a logged 500 that
+	// still carries the rate-limit headers. With RefundOnPanic the request's
+	// cost is handed back to limiters that support Return.
+	Recover       bool
+	RefundOnPanic bool
+	// DeniedStatusCode and DeniedMessage shape the default rate-limited
+	// response, for proxies that expect e.g. 503 when throttling. They
+	// default to 429 and "Rate limit exceeded"; a custom ErrorBody writes
+	// its own response and ignores them.
+	DeniedStatusCode int
+	DeniedMessage    string
+	// UnauthorizedStatusCode and UnauthorizedMessage do the same for
+	// requests whose key is missing or invalid, unless OnError is set.
+	UnauthorizedStatusCode int
+	UnauthorizedMessage    string
+	// OmitHeaders leaves the X-RateLimit-* headers off every response.
+	// Denied responses still carry Retry-After.
+	OmitHeaders bool
+	// OnSuccessOnly hands the request's cost back when the inner handler
+	// responds 5xx or panics, so clients aren't charged for server
+	// failures. It needs a limiter that supports Return. The rate-limit
+	// headers are already sent by then and still show the charge.
+	OnSuccessOnly bool
+}
+
+const rateLimitTrailers = "X-RateLimit-Remaining, X-RateLimit-Reset"
+
+func RateLimiterMiddleware(next http.Handler, limiter Limiter) http.Handler {
+	return NewRateLimiterMiddleware(next, limiter, APIKeyExtractor)
+}
+
+func NewRateLimiterMiddleware(next http.Handler, limiter Limiter, extractor KeyExtractor) http.Handler {
+	return NewRateLimiterMiddlewareWithOptions(next, limiter, extractor, Options{})
+}
+
+// NewRateLimiterMiddlewareWithOptions panics if a status code in opts is
+// set outside the 4xx and 5xx ranges.
+func NewRateLimiterMiddlewareWithOptions(next http.Handler, limiter Limiter, extractor KeyExtractor, opts Options) http.Handler {
+	opts = opts.withDefaults()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey := r.Header.Get("X-API-KEY")
-		if apiKey == "" {
-			http.Error(w, "Missing API key", http.StatusUnauthorized)
+		if traceRequest != nil {
+			r = traceRequest(r)
+		}
+
+		key, err := extractor(r)
+		if err != nil {
+			opts.Logger.Warn("rate limiter rejected request", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+			opts.OnError(w, r, err)
+			return
+		}
+
+		if opts.Concurrency != nil {
+			release, ok := opts.Concurrency.Acquire(key)
+			if !ok {
+				opts.ErrorBody(w, r, Result{Limit: opts.Concurrency.maxInflight})
+				return
+			}
+			defer release()
+		}
+
+		cost := requestCost(r, limiter, key, opts)
+		result, err := allowN(r.Context(), limiter, key, cost)
+		var limitErr *RateLimitError
+		if errors.As(err, &limitErr) {
+			result = limitErr.Result()
+		}
+		if !opts.OmitHeaders {
+			setRateLimitHeaders(w, result)
+			setPolicyHeader(w, opts.RouteID, result)
+		}
+
+		denied := err != nil || !result.Allowed
+		if opts.Events != nil && (denied || opts.PublishAllowed) {
+			opts.Events.Publish(Event{
+				Key:       key,
+				Result:    result,
+				RequestID: r.Header.Get("X-Request-ID"),
+				Timestamp: time.Now(),
+			})
+		}
+
+		if errors.Is(err, ErrCostExceedsCapacity) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if denied {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(result)))
+			opts.ErrorBody(w, r, result)
+			return
+		}
+
+		rw := &responseWriter{
+			ResponseWriter: w,
+			result:         result,
+			routeID:        opts.RouteID,
+			omitHeaders:    opts.OmitHeaders,
+			trailers:       opts.UseTrailers && !opts.OmitHeaders && r.ProtoMajor >= 2,
+		}
+		if rw.trailers {
+			declareTrailers(w.Header())
+		}
+		if opts.Recover {
+			defer recoverHandler(rw, r, limiter, key, cost, opts)
+		}
+		served := false
+		if opts.OnSuccessOnly {
+			// Runs before recoverHandler, so a recovered 500 reports the
+			// refunded quota.
+			defer func() {
+				if !served && refund(limiter, key, cost) {
+					rw.result = limiter.Peek(key)
+				}
+			}()
+		}
+
+		next.ServeHTTP(rw, r)
+		served = true
+
+		if opts.OnSuccessOnly && rw.status >= http.StatusInternalServerError && refund(limiter, key, cost) {
+			result = limiter.Peek(key)
+		}
+
+		if rw.trailers {
+			if !rw.wroteHeader {
+				rw.WriteHeader(http.StatusOK)
+			}
+			header := w.Header()
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+		}
+	})
+}
+
+// withDefaults fills in every unset option. It panics if a status code is
+// set outside the 4xx and 5xx ranges.
+func (opts Options) withDefaults() Options {
+	validateStatusCode("DeniedStatusCode", opts.DeniedStatusCode)
+	validateStatusCode("UnauthorizedStatusCode", opts.UnauthorizedStatusCode)
+
+	if opts.OnError == nil {
+		opts.OnError = DefaultErrorHandler
+		if opts.UnauthorizedStatusCode != 0 || opts.UnauthorizedMessage != "" {
+			opts.OnError = unauthorizedErrorHandler(opts.UnauthorizedStatusCode, opts.UnauthorizedMessage)
+		}
+	}
+	if opts.CostFunc == nil {
+		opts.CostFunc = DefaultCostFunc
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.ErrorBody == nil {
+		opts.ErrorBody = TextErrorBody
+		if opts.DeniedStatusCode != 0 || opts.DeniedMessage != "" {
+			opts.ErrorBody = textErrorBody(opts.DeniedStatusCode, opts.DeniedMessage)
+		}
+	}
+
+	return opts
+}
+
+func recoverHandler(rw *responseWriter, r *http.Request, limiter Limiter, key string, cost int, opts Options) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+
+	opts.Logger.Error("rate limited handler panicked", "path", r.URL.Path, "panic", v, "stack", string(debug.Stack()))
+
+	// With OnSuccessOnly the cost has already been handed back.
+	if opts.RefundOnPanic && !opts.OnSuccessOnly && refund(limiter, key, cost) {
+		rw.result = limiter.Peek(key)
+	}
+
+	if !rw.wroteHeader {
+		http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// refund hands cost tokens back to key, and reports whether limiter
+// supports Return.
+func refund(limiter Limiter, key string, cost int) bool {
+	rl, ok := limiter.(returner)
+	if !ok {
+		return false
+	}
+
+	for range cost {
+		rl.Return(key)
+	}
+	return true
+}
+
+// declareTrailers moves the per-request counters out of the header block
+// and announces them as trailers instead.
+func declareTrailers(header http.Header) {
+	header.Del("X-RateLimit-Remaining")
+	header.Del("X-RateLimit-Reset")
+	header.Set("Trailer", rateLimitTrailers)
+}
+
+// responseWriter holds back the status line until the inner handler is done
+// with its headers, then re-applies the rate-limit headers so a handler that
+// resets or overwrites them still sends them to the client.
+type responseWriter struct {
+	http.ResponseWriter
+	result      Result
+	routeID     string
+	omitHeaders bool
+	trailers    bool
+	wroteHeader bool
+	status      int
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 {
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	rw.wroteHeader = true
+	rw.status = status
+	if !rw.omitHeaders {
+		setRateLimitHeaders(rw.ResponseWriter, rw.result)
+		setPolicyHeader(rw.ResponseWriter, rw.routeID, rw.result)
+	}
+	if rw.trailers {
+		declareTrailers(rw.Header())
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func allowN(ctx context.Context, limiter Limiter, key string, n int) (Result, error) {
+	if cl, ok := limiter.(contextLimiter); ok {
+		return cl.AllowNContext(ctx, key, n)
+	}
 
-		if !isValidApiKey(apiKey) {
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+	return limiter.AllowN(key, n)
+}
+
+func QuotaHandler(limiter Limiter, extractor KeyExtractor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if !limiter.Allow(apiKey) {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		key, err := extractor(r)
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		result := limiter.Peek(key)
+		setRateLimitHeaders(w, result)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+func DefaultCostFunc(r *http.Request) int {
+	return 1
+}
+
+const requestCostHeader = "X-Rate-Limit-Request-Cost"
+
+// TrustedCostHeader lets the callers in trustedKeys say how many tokens a
+// request should take with the X-Rate-Limit-Request-Cost header, e.g. for a
+// batch of operations. Everyone else's header is ignored.
+func TrustedCostHeader(trustedKeys map[string]bool) KeyExtractorOption {
+	return func(r *http.Request, key string) int {
+		if !trustedKeys[key] {
+			return 0
+		}
+
+		cost, err := strconv.Atoi(r.Header.Get(requestCostHeader))
+		if err != nil {
+			return 0
+		}
+
+		return max(cost, 1)
+	}
+}
+
+// requestCost prefers opts.KeyCost over opts.CostFunc. A key-chosen cost is
+// capped at what the key's bucket can hold, so a trusted caller asking for
+// more than its whole quota spends all of it rather than being refused
+// outright. A CostFunc
+// result below 1 counts as 1, so a buggy cost cannot hand out tokens.
+func requestCost(r *http.Request, limiter Limiter, key string, opts Options) int {
+	if opts.KeyCost != nil {
+		if cost := opts.KeyCost(r, key); cost > 0 {
+			if cost > 1 {
+				cost = max(min(cost, keyCapacity(limiter, key)), 1)
+			}
+			return cost
+		}
+	}
+
+	return max(opts.CostFunc(r), 1)
+}
+
+func keyCapacity(limiter Limiter, key string) int {
+	if cl, ok := limiter.(capacityLimiter); ok {
+		return cl.Capacity(key)
+	}
+
+	return limiter.Peek(key).Limit
+}
+
+func TextErrorBody(w http.ResponseWriter, r *http.Request, result Result) {
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+}
+
+func textErrorBody(status int, message string) ErrorBodyFunc {
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	if message == "" {
+		message = "Rate limit exceeded"
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, result Result) {
+		http.Error(w, message, status)
+	}
+}
+
+func JSONErrorBody(w http.ResponseWriter, r *http.Request, result Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retryAfter"`
+	}{
+		Error:      "rate limit exceeded",
+		RetryAfter: retryAfterSeconds(result),
 	})
 }
 
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if message, ok := unauthorizedText(err); ok {
+		http.Error(w, message, http.StatusUnauthorized)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// unauthorizedErrorHandler is DefaultErrorHandler with a different status
+// or message for missing and invalid keys. Zero values keep the defaults.
+func unauthorizedErrorHandler(status int, message string) ErrorHandler {
+	if status == 0 {
+		status = http.StatusUnauthorized
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		text, ok := unauthorizedText(err)
+		if !ok {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+		if message != "" {
+			text = message
+		}
+
+		http.Error(w, text, status)
+	}
+}
+
+func unauthorizedText(err error) (string, bool) {
+	switch {
+	case errors.Is(err, ErrMissingAPIKey):
+		return "Missing API key", true
+	case errors.Is(err, ErrInvalidAPIKey):
+		return "Invalid API key", true
+	case errors.Is(err, ErrMissingBearer), errors.Is(err, ErrInvalidToken), errors.Is(err, ErrMissingClaim):
+		return "Unauthorized", true
+	default:
+		return "", false
+	}
+}
+
+func validateStatusCode(field string, status int) {
+	if status != 0 && (status < 400 || status > 599) {
+		panic(fmt.Sprintf("services: %s must be a 4xx or 5xx status, got %d", field, status))
+	}
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, result Result) {
+	header := w.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+}
+
+// setPolicyHeader describes the quota behind the X-RateLimit-* values in
+// the form of draft-ietf-httpapi-ratelimit-headers, e.g. "hello;w=60;q=10".
+func setPolicyHeader(w http.ResponseWriter, routeID string, result Result) {
+	if routeID == "" {
+		return
+	}
+
+	policy := routeID
+	if result.Window > 0 {
+		policy += ";w=" + strconv.Itoa(int(math.Ceil(result.Window.Seconds())))
+	}
+	policy += ";q=" + strconv.Itoa(result.Limit)
+	w.Header().Set("X-RateLimit-Policy", policy)
+}
+
+func retryAfterSeconds(result Result) int {
+	wait := result.RetryAfter
+	if wait <= 0 {
+		wait = time.Until(result.ResetAt)
+	}
+
+	return max(int(math.Ceil(wait.Seconds())), 1)
+}
+
 func isValidApiKey(apiKey string) bool {
 	apiKeys := apistore.GetApiKeys()
 	_, exists := apiKeys[apiKey]
 	return exists
-}
\ No newline at end of file
+}
@@ -0,0 +1,692 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+})
+
+// serve sends one request with apiKey through handler; an empty apiKey
+// sends none.
+func serve(handler http.Handler, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	if apiKey != "" {
+		req.Header.Set("X-API-KEY", apiKey)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiKey    string
+		prior     int
+		wantCode  int
+		wantBody  string
+		wantQuota bool
+	}{
+		{name: "missing key", wantCode: http.StatusUnauthorized, wantBody: "Missing API key\n"},
+		{name: "invalid key", apiKey: "not-a-key", wantCode: http.StatusUnauthorized, wantBody: "Invalid API key\n"},
+		{name: "within limit", apiKey: "apikey123", prior: 1, wantCode: http.StatusOK, wantBody: "ok", wantQuota: true},
+		{name: "limit exceeded", apiKey: "apikey123", prior: 2, wantCode: http.StatusTooManyRequests, wantBody: "Rate limit exceeded\n", wantQuota: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+			handler := RateLimiterMiddleware(okHandler, rl)
+
+			for range tt.prior {
+				serve(handler, tt.apiKey)
+			}
+			rec := serve(handler, tt.apiKey)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if body := rec.Body.String(); body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := rec.Header().Get("X-RateLimit-Limit") != ""; got != tt.wantQuota {
+				t.Errorf("X-RateLimit-Limit sent = %v, want %v", got, tt.wantQuota)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareHeaders(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	handler := RateLimiterMiddleware(okHandler, rl)
+	now := clock.Now()
+
+	tests := []struct {
+		wantCode       int
+		wantRemaining  string
+		wantReset      time.Time
+		wantRetryAfter string
+	}{
+		{wantCode: http.StatusOK, wantRemaining: "1", wantReset: now.Add(30 * time.Second)},
+		{wantCode: http.StatusOK, wantRemaining: "0", wantReset: now.Add(time.Minute)},
+		{wantCode: http.StatusTooManyRequests, wantRemaining: "0", wantReset: now.Add(time.Minute), wantRetryAfter: "30"},
+	}
+
+	for i, tt := range tests {
+		rec := serve(handler, "apikey123")
+		header := rec.Header()
+
+		if rec.Code != tt.wantCode {
+			t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, tt.wantCode)
+		}
+		if got := header.Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 2", i+1, got)
+		}
+		if got := header.Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %s", i+1, got, tt.wantRemaining)
+		}
+		if got, want := header.Get("X-RateLimit-Reset"), strconv.FormatInt(tt.wantReset.Unix(), 10); got != want {
+			t.Errorf("request %d: X-RateLimit-Reset = %q, want %s", i+1, got, want)
+		}
+		if got := header.Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("request %d: Retry-After = %q, want %q", i+1, got, tt.wantRetryAfter)
+		}
+	}
+}
+
+func TestRateLimiterMiddlewareRefill(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	handler := RateLimiterMiddleware(okHandler, rl)
+
+	serve(handler, "apikey123")
+	if rec := serve(handler, "apikey123"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want 429", rec.Code)
+	}
+
+	clock.Advance(time.Minute)
+	if rec := serve(handler, "apikey123"); rec.Code != http.StatusOK {
+		t.Errorf("after refill: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestQuotaHandler(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	rl.AllowN("apikey123", 2)
+	handler := QuotaHandler(rl, APIKeyExtractor)
+
+	tests := []struct {
+		name     string
+		method   string
+		apiKey   string
+		wantCode int
+	}{
+		{name: "quota", method: http.MethodGet, apiKey: "apikey123", wantCode: http.StatusOK},
+		{name: "missing key", method: http.MethodGet, wantCode: http.StatusUnauthorized},
+		{name: "post", method: http.MethodPost, apiKey: "apikey123", wantCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/quota", nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-KEY", tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var body Result
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if !body.Allowed || body.Limit != 5 || body.Remaining != 3 {
+				t.Errorf("body = %+v, want 3 of 5 left", body)
+			}
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != "3" {
+				t.Errorf("X-RateLimit-Remaining = %q, want 3", got)
+			}
+		})
+	}
+
+	if peek := rl.Peek("apikey123"); peek.Remaining != 3 {
+		t.Errorf("quota requests took tokens: %d left", peek.Remaining)
+	}
+}
+
+func TestRateLimiterMiddlewareHeadersOnInnerResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "implicit 200",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("body")) },
+			wantCode: http.StatusOK,
+			wantBody: "body",
+		},
+		{
+			name:     "no body",
+			handler:  func(w http.ResponseWriter, r *http.Request) {},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "explicit 201",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("created"))
+			},
+			wantCode: http.StatusCreated,
+			wantBody: "created",
+		},
+		{
+			name: "error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "boom", http.StatusInternalServerError)
+			},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "boom\n",
+		},
+		{
+			name: "handler clears the headers",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				for name := range w.Header() {
+					w.Header().Del(name)
+				}
+				w.Header().Set("X-RateLimit-Limit", "999")
+				w.WriteHeader(http.StatusNotFound)
+			},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			rec := serve(RateLimiterMiddleware(tt.handler, rl), "apikey123")
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if body := rec.Body.String(); body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := rec.Header().Get("X-RateLimit-Limit"); got != "5" {
+				t.Errorf("X-RateLimit-Limit = %q, want 5", got)
+			}
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != "4" {
+				t.Errorf("X-RateLimit-Remaining = %q, want 4", got)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareFlush(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	handler := RateLimiterMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		w.Write([]byte("streamed"))
+	}), rl)
+
+	rec := serve(handler, "apikey123")
+	if !rec.Flushed {
+		t.Error("Flush not passed through")
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "5" {
+		t.Errorf("status %d, headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRateLimiterMiddlewarePolicyHeader(t *testing.T) {
+	hello, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+	world, _ := newTestRateLimiter(t, 100, 3600, LimiterOptions{})
+	mux := http.NewServeMux()
+	mux.Handle("/hello", NewRateLimiterMiddlewareWithOptions(okHandler, hello, APIKeyExtractor, Options{RouteID: "hello"}))
+	mux.Handle("/world", NewRateLimiterMiddlewareWithOptions(okHandler, world, APIKeyExtractor, Options{RouteID: "world"}))
+	mux.Handle("/plain", RateLimiterMiddleware(okHandler, hello))
+
+	tests := []struct {
+		path       string
+		wantPolicy string
+		wantLimit  string
+	}{
+		{path: "/hello", wantPolicy: "hello;w=60;q=10", wantLimit: "10"},
+		{path: "/world", wantPolicy: "world;w=3600;q=100", wantLimit: "100"},
+		{path: "/plain", wantLimit: "10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-API-KEY", "apikey123")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("X-RateLimit-Policy"); got != tt.wantPolicy {
+				t.Errorf("X-RateLimit-Policy = %q, want %q", got, tt.wantPolicy)
+			}
+			if got := rec.Header().Get("X-RateLimit-Limit"); got != tt.wantLimit {
+				t.Errorf("X-RateLimit-Limit = %q, want %q", got, tt.wantLimit)
+			}
+		})
+	}
+
+	hello.AllowN("apikey123", 8)
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("X-API-KEY", "apikey123")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-RateLimit-Policy") != "hello;w=60;q=10" {
+		t.Errorf("denied: status %d, policy %q", rec.Code, rec.Header().Get("X-RateLimit-Policy"))
+	}
+}
+
+func TestRateLimiterMiddlewareTrailers(t *testing.T) {
+	tests := []struct {
+		name         string
+		http2        bool
+		wantTrailers bool
+	}{
+		{name: "HTTP/2", http2: true, wantTrailers: true},
+		{name: "HTTP/1.1", http2: false, wantTrailers: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			server := httptest.NewUnstartedServer(NewRateLimiterMiddlewareWithOptions(okHandler, rl, APIKeyExtractor, Options{UseTrailers: true}))
+			server.EnableHTTP2 = tt.http2
+			server.StartTLS()
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-API-KEY", "apikey123")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if string(body) != "ok" {
+				t.Errorf("body = %q, want ok", body)
+			}
+			if got := resp.ProtoMajor == 2; got != tt.http2 {
+				t.Fatalf("served over %s", resp.Proto)
+			}
+			if got := resp.Header.Get("X-RateLimit-Limit"); got != "5" {
+				t.Errorf("X-RateLimit-Limit header = %q, want 5", got)
+			}
+
+			counters := resp.Header
+			if tt.wantTrailers {
+				counters = resp.Trailer
+				if got := resp.Header.Get("X-RateLimit-Remaining"); got != "" {
+					t.Errorf("X-RateLimit-Remaining also sent as a header: %q", got)
+				}
+			} else if len(resp.Trailer) != 0 {
+				t.Errorf("HTTP/1.1 response has trailers %v", resp.Trailer)
+			}
+			if got := counters.Get("X-RateLimit-Remaining"); got != "4" {
+				t.Errorf("X-RateLimit-Remaining = %q, want 4", got)
+			}
+			if got := counters.Get("X-RateLimit-Reset"); got == "" {
+				t.Error("X-RateLimit-Reset missing")
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareRecover(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	tests := []struct {
+		name          string
+		refund        bool
+		wantRemaining string
+	}{
+		{name: "charged", wantRemaining: "4"},
+		{name: "refunded", refund: true, wantRemaining: "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddlewareWithOptions(panicking, rl, APIKeyExtractor, Options{
+				Logger:        slog.New(slog.DiscardHandler),
+				Recover:       true,
+				RefundOnPanic: tt.refund,
+			})
+
+			rec := serve(handler, "apikey123")
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500", rec.Code)
+			}
+			if got := rec.Header().Get("X-RateLimit-Limit"); got != "5" {
+				t.Errorf("X-RateLimit-Limit = %q, want 5", got)
+			}
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("X-RateLimit-Remaining = %q, want %s", got, tt.wantRemaining)
+			}
+			if got := strconv.Itoa(rl.Peek("apikey123").Remaining); got != tt.wantRemaining {
+				t.Errorf("bucket holds %s tokens, want %s", got, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareRecoverAbort(t *testing.T) {
+	aborting := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	handler := NewRateLimiterMiddlewareWithOptions(aborting, rl, APIKeyExtractor, Options{Recover: true})
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", v)
+		}
+	}()
+	serve(handler, "apikey123")
+}
+
+func TestRateLimiterMiddlewareOnSuccessOnly(t *testing.T) {
+	tests := []struct {
+		name          string
+		onSuccessOnly bool
+		codes         []int
+	}{
+		{
+			name:  "every response charged",
+			codes: []int{200, 500, 200, 500, 200, 429, 429, 429, 429, 429},
+		},
+		{
+			name:          "only 200s charged",
+			onSuccessOnly: true,
+			codes:         []int{200, 500, 200, 500, 200, 500, 200, 500, 200, 429},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			alternating := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls%2 == 0 {
+					http.Error(w, "upstream failed", http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte("ok"))
+			})
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddlewareWithOptions(alternating, rl, APIKeyExtractor, Options{OnSuccessOnly: tt.onSuccessOnly})
+
+			for i, want := range tt.codes {
+				if rec := serve(handler, "apikey123"); rec.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, want)
+				}
+			}
+			if remaining := rl.Peek("apikey123").Remaining; remaining != 0 {
+				t.Errorf("bucket holds %d tokens, want 0", remaining)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareOnSuccessOnlyPanic(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	tests := []struct {
+		name    string
+		recover bool
+	}{
+		{name: "recovered", recover: true},
+		{name: "not recovered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddlewareWithOptions(panicking, rl, APIKeyExtractor, Options{
+				Logger:        slog.New(slog.DiscardHandler),
+				Recover:       tt.recover,
+				OnSuccessOnly: true,
+			})
+
+			func() {
+				defer func() {
+					if v := recover(); (v != nil) == tt.recover {
+						t.Errorf("recovered %v from the middleware", v)
+					}
+				}()
+				if rec := serve(handler, "apikey123"); rec.Code != http.StatusInternalServerError {
+					t.Errorf("status = %d, want 500", rec.Code)
+				} else if got := rec.Header().Get("X-RateLimit-Remaining"); got != "5" {
+					t.Errorf("X-RateLimit-Remaining = %q, want the refunded 5", got)
+				}
+			}()
+
+			if remaining := rl.Peek("apikey123").Remaining; remaining != 5 {
+				t.Errorf("bucket holds %d tokens, want the panic refunded", remaining)
+			}
+		})
+	}
+}
+
+func TestTrustedCostHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		apiKey        string
+		cost          string
+		burst         int
+		wantRemaining string
+	}{
+		{name: "trusted valid cost", apiKey: "apikey123", cost: "5", wantRemaining: "5"},
+		{name: "trusted cost over the limit", apiKey: "apikey123", cost: "50", wantRemaining: "0"},
+		{name: "trusted cost over the burst", apiKey: "apikey123", cost: "8", burst: 4, wantRemaining: "0"},
+		{name: "trusted zero cost", apiKey: "apikey123", cost: "0", wantRemaining: "9"},
+		{name: "trusted negative cost", apiKey: "apikey123", cost: "-5", wantRemaining: "9"},
+		{name: "trusted malformed cost", apiKey: "apikey123", cost: "five", wantRemaining: "9"},
+		{name: "trusted without header", apiKey: "apikey123", wantRemaining: "9"},
+		{name: "untrusted key sending the header", apiKey: "apikey124", cost: "5", wantRemaining: "9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{Burst: tt.burst})
+			handler := NewRateLimiterMiddlewareWithOptions(okHandler, rl, APIKeyExtractor, Options{
+				KeyCost: TrustedCostHeader(map[string]bool{"apikey123": true}),
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req.Header.Set("X-API-KEY", tt.apiKey)
+			if tt.cost != "" {
+				req.Header.Set("X-Rate-Limit-Request-Cost", tt.cost)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got := rec.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("X-RateLimit-Remaining = %q, want %s", got, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareStatusOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		apiKey   string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "denied 503",
+			opts:     Options{DeniedStatusCode: http.StatusServiceUnavailable},
+			apiKey:   "apikey123",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: "Rate limit exceeded\n",
+		},
+		{
+			name:     "denied message",
+			opts:     Options{DeniedMessage: "Slow down"},
+			apiKey:   "apikey123",
+			wantCode: http.StatusTooManyRequests,
+			wantBody: "Slow down\n",
+		},
+		{
+			name:     "unauthorized 403",
+			opts:     Options{UnauthorizedStatusCode: http.StatusForbidden, UnauthorizedMessage: "Forbidden"},
+			apiKey:   "not-a-key",
+			wantCode: http.StatusForbidden,
+			wantBody: "Forbidden\n",
+		},
+		{
+			name:     "unauthorized default message",
+			opts:     Options{UnauthorizedStatusCode: http.StatusForbidden},
+			wantCode: http.StatusForbidden,
+			wantBody: "Missing API key\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddlewareWithOptions(okHandler, rl, APIKeyExtractor, tt.opts)
+			serve(handler, "apikey123")
+
+			rec := serve(handler, tt.apiKey)
+			if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareConcurrencyDenial(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-unblock
+	})
+	handler := NewRateLimiterMiddlewareWithOptions(blocking, rl, APIKeyExtractor, Options{
+		Concurrency:      NewConcurrencyLimiter(1),
+		DeniedStatusCode: http.StatusServiceUnavailable,
+	})
+
+	go serve(handler, "apikey123")
+	<-entered
+	defer close(unblock)
+
+	if rec := serve(handler, "apikey123"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("request over the concurrency cap: status = %d, want the configured 503", rec.Code)
+	}
+}
+
+func TestRateLimiterMiddlewareInvalidStatusCode(t *testing.T) {
+	for _, opts := range []Options{{DeniedStatusCode: http.StatusOK}, {UnauthorizedStatusCode: 302}, {DeniedStatusCode: 600}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v accepted", opts)
+				}
+			}()
+			NewRateLimiterMiddlewareWithOptions(okHandler, nil, APIKeyExtractor, opts)
+		}()
+	}
+}
+
+func TestRateLimiterMiddlewareCostExceedsCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		cost     int
+		wantCode int
+	}{
+		{name: "at capacity", cost: 5, wantCode: http.StatusOK},
+		{name: "over capacity", cost: 6, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddlewareWithOptions(okHandler, rl, APIKeyExtractor, Options{
+				CostFunc: func(*http.Request) int { return tt.cost },
+			})
+
+			rec := serve(handler, "apikey123")
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "maximum 5") {
+				t.Errorf("body = %q, want it to name the maximum cost", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareRefillJitter(t *testing.T) {
+	tests := []struct {
+		name         string
+		jitter       float64
+		wantDistinct bool
+	}{
+		{name: "without jitter", jitter: 0},
+		{name: "with jitter", jitter: 1, wantDistinct: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{RefillJitter: tt.jitter})
+			handler := NewRateLimiterMiddleware(okHandler, rl, APIKeyExtractor)
+			serve(handler, "apikey123")
+
+			seen := map[string]bool{}
+			for range 100 {
+				rec := serve(handler, "apikey123")
+				retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+				if err != nil || retryAfter < 60 || retryAfter > 120 {
+					t.Fatalf("Retry-After = %q, want 60 to 120 seconds", rec.Header().Get("Retry-After"))
+				}
+				seen[rec.Header().Get("Retry-After")] = true
+			}
+
+			if distinct := len(seen) > 1; distinct != tt.wantDistinct {
+				t.Errorf("100 denials got Retry-After values %v", seen)
+			}
+			// The jitter is per response; the bucket still refills on time.
+			if peek := rl.Peek("apikey123"); peek.ResetAt.Sub(time.Unix(1_700_000_000, 0)) != time.Minute {
+				t.Errorf("bucket resets at %v, want one minute from the start", peek.ResetAt)
+			}
+		})
+	}
+}
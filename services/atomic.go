@@ -0,0 +1,153 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A bucket's whole state is one uint64 so refill-and-take is a single CAS:
+// the low atomicCountBits hold the token count and the rest hold the last
+// refill time in milliseconds since the limiter was created.
+const (
+	atomicCountBits = 20
+	atomicCountMask = 1<<atomicCountBits - 1
+	maxAtomicTokens = atomicCountMask
+)
+
+// AtomicRateLimiter is a token bucket that takes no locks on the request
+// path. Buckets are never evicted, so it suits a small, fixed set of hot
+// keys rather than one bucket per client.
+type AtomicRateLimiter struct {
+	buckets  sync.Map
+	maxLimit int
+	windowMs int64
+	epoch    time.Time
+}
+
+func NewAtomicRateLimiter(maxLimit, timeLimit int) (*AtomicRateLimiter, error) {
+	if maxLimit <= 0 || maxLimit > maxAtomicTokens {
+		return nil, fmt.Errorf("max limit must be between 1 and %d, got %d", maxAtomicTokens, maxLimit)
+	}
+	if timeLimit <= 0 {
+		return nil, fmt.Errorf("time limit must be positive, got %d", timeLimit)
+	}
+
+	return &AtomicRateLimiter{
+		maxLimit: maxLimit,
+		windowMs: int64(timeLimit) * 1000,
+		epoch:    time.Now(),
+	}, nil
+}
+
+func (al *AtomicRateLimiter) Allow(apiKey string) (Result, error) {
+	return al.AllowN(apiKey, 1)
+}
+
+func (al *AtomicRateLimiter) AllowN(apiKey string, n int) (Result, error) {
+	if n < 1 {
+		return Result{Limit: al.maxLimit, Window: al.window(), ResetAt: time.Now()}, errInvalidCost(n)
+	}
+	if n > al.maxLimit {
+		return Result{Limit: al.maxLimit, Window: al.window(), ResetAt: time.Now()}, errCostExceedsLimit(n, al.maxLimit)
+	}
+
+	bucket := al.bucket(apiKey)
+	for {
+		old := bucket.Load()
+		now := al.now()
+		lastMs, tokens := unpack(old)
+		lastMs, tokens = al.refill(lastMs, tokens, now)
+
+		allowed := tokens >= n
+		if allowed {
+			tokens -= n
+		}
+
+		if bucket.CompareAndSwap(old, pack(lastMs, tokens)) {
+			return al.result(allowed, n, lastMs, tokens, now), nil
+		}
+	}
+}
+
+func (al *AtomicRateLimiter) Peek(apiKey string) Result {
+	now := al.now()
+	lastMs, tokens := now, al.maxLimit
+	if bucket, exists := al.buckets.Load(apiKey); exists {
+		lastMs, tokens = unpack(bucket.(*atomic.Uint64).Load())
+		lastMs, tokens = al.refill(lastMs, tokens, now)
+	}
+
+	return al.result(tokens > 0, 1, lastMs, tokens, now)
+}
+
+func (al *AtomicRateLimiter) bucket(apiKey string) *atomic.Uint64 {
+	if bucket, exists := al.buckets.Load(apiKey); exists {
+		return bucket.(*atomic.Uint64)
+	}
+
+	fresh := new(atomic.Uint64)
+	fresh.Store(pack(al.now(), al.maxLimit))
+	bucket, _ := al.buckets.LoadOrStore(apiKey, fresh)
+	return bucket.(*atomic.Uint64)
+}
+
+// refill adds the tokens earned since lastMs. lastMs only advances by the
+// time those whole tokens took, so the fraction towards the next one is kept.
+func (al *AtomicRateLimiter) refill(lastMs int64, tokens int, now int64) (int64, int) {
+	if tokens >= al.maxLimit {
+		return now, al.maxLimit
+	}
+
+	added := (now - lastMs) * int64(al.maxLimit) / al.windowMs
+	if added <= 0 {
+		return lastMs, tokens
+	}
+
+	tokens += int(added)
+	if tokens >= al.maxLimit {
+		return now, al.maxLimit
+	}
+
+	return lastMs + added*al.windowMs/int64(al.maxLimit), tokens
+}
+
+func (al *AtomicRateLimiter) result(allowed bool, n int, lastMs int64, tokens int, now int64) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     al.maxLimit,
+		Window:    al.window(),
+		Remaining: tokens,
+		ResetAt:   al.at(lastMs + al.tokensMs(al.maxLimit-tokens)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration(max(lastMs+al.tokensMs(n-tokens)-now, 0)) * time.Millisecond
+	}
+
+	return result
+}
+
+func (al *AtomicRateLimiter) tokensMs(tokens int) int64 {
+	return (int64(tokens)*al.windowMs + int64(al.maxLimit) - 1) / int64(al.maxLimit)
+}
+
+func (al *AtomicRateLimiter) window() time.Duration {
+	return time.Duration(al.windowMs) * time.Millisecond
+}
+
+func (al *AtomicRateLimiter) now() int64 {
+	return time.Since(al.epoch).Milliseconds()
+}
+
+func (al *AtomicRateLimiter) at(ms int64) time.Time {
+	return al.epoch.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func pack(ms int64, tokens int) uint64 {
+	return uint64(ms)<<atomicCountBits | uint64(tokens)
+}
+
+func unpack(state uint64) (int64, int) {
+	return int64(state >> atomicCountBits), int(state & atomicCountMask)
+}
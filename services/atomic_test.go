@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewAtomicRateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLimit  int
+		timeLimit int
+		wantErr   bool
+	}{
+		{name: "valid", maxLimit: 10, timeLimit: 60},
+		{name: "largest limit", maxLimit: maxAtomicTokens, timeLimit: 60},
+		{name: "zero limit", maxLimit: 0, timeLimit: 60, wantErr: true},
+		{name: "limit too large", maxLimit: maxAtomicTokens + 1, timeLimit: 60, wantErr: true},
+		{name: "zero window", maxLimit: 10, timeLimit: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAtomicRateLimiter(tt.maxLimit, tt.timeLimit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAtomicRateLimiter(t *testing.T) {
+	al, err := NewAtomicRateLimiter(3, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := al.AllowN("key", 2); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("AllowN(2) = %+v, want allowed with 1 remaining", result)
+	}
+	result, _ := al.AllowN("key", 2)
+	if result.Allowed || result.Remaining != 1 || result.RetryAfter <= 0 {
+		t.Errorf("AllowN(2) over the limit = %+v, want a denial with RetryAfter", result)
+	}
+	if peek := al.Peek("key"); peek.Remaining != 1 {
+		t.Errorf("Peek = %+v, want 1 remaining", peek)
+	}
+	if peek := al.Peek("other"); !peek.Allowed || peek.Remaining != 3 {
+		t.Errorf("Peek of an unseen key = %+v", peek)
+	}
+
+	if _, err := al.AllowN("key", 0); !errors.Is(err, ErrInvalidCost) {
+		t.Errorf("AllowN(0): err = %v, want ErrInvalidCost", err)
+	}
+	if _, err := al.AllowN("key", 4); !errors.Is(err, ErrCostExceedsCapacity) {
+		t.Errorf("AllowN(4): err = %v, want ErrCostExceedsCapacity", err)
+	}
+}
+
+func TestAtomicRateLimiterRefill(t *testing.T) {
+	al, _ := NewAtomicRateLimiter(10, 1)
+
+	tests := []struct {
+		name       string
+		lastMs     int64
+		tokens     int
+		now        int64
+		wantLastMs int64
+		wantTokens int
+	}{
+		{name: "full bucket", lastMs: 0, tokens: 10, now: 500, wantLastMs: 500, wantTokens: 10},
+		{name: "less than a token", lastMs: 0, tokens: 0, now: 99, wantLastMs: 0, wantTokens: 0},
+		// The 50ms towards the next token are kept.
+		{name: "keeps the fraction", lastMs: 0, tokens: 0, now: 250, wantLastMs: 200, wantTokens: 2},
+		{name: "caps at the limit", lastMs: 0, tokens: 5, now: 5_000, wantLastMs: 5_000, wantTokens: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lastMs, tokens := al.refill(tt.lastMs, tt.tokens, tt.now)
+			if lastMs != tt.wantLastMs || tokens != tt.wantTokens {
+				t.Errorf("refill = (%d, %d), want (%d, %d)", lastMs, tokens, tt.wantLastMs, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestAtomicPack(t *testing.T) {
+	for _, state := range []struct {
+		ms     int64
+		tokens int
+	}{{0, 0}, {1, maxAtomicTokens}, {1 << 40, 7}} {
+		if ms, tokens := unpack(pack(state.ms, state.tokens)); ms != state.ms || tokens != state.tokens {
+			t.Errorf("unpack(pack(%d, %d)) = (%d, %d)", state.ms, state.tokens, ms, tokens)
+		}
+	}
+}
+
+// TestAtomicRateLimiterConcurrentAllow is meant to be run with -race: the
+// CAS loop must never admit more than the limit however the calls interleave.
+func TestAtomicRateLimiterConcurrentAllow(t *testing.T) {
+	al, _ := NewAtomicRateLimiter(50, 3600)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Go(func() {
+			for range 20 {
+				if result, _ := al.Allow("key"); result.Allowed {
+					allowed.Add(1)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 50 {
+		t.Errorf("%d requests allowed, want 50", got)
+	}
+}
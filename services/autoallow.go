@@ -0,0 +1,84 @@
+package services
+
+import "time"
+
+// autoAllowRemoveAt is the share of a window's quota that takes a key back
+// off the automatic allow list.
+const autoAllowRemoveAt = 0.5
+
+// windowUsage tracks how much of each window's quota a bucket used, for
+// AutoAllowThreshold.
+type windowUsage struct {
+	start      time.Time
+	used       int
+	lowWindows int
+}
+
+func WithAutoAllow(threshold float64, windows int) Option {
+	return func(opts *LimiterOptions) {
+		opts.AutoAllowThreshold = threshold
+		opts.AutoAllowWindows = windows
+	}
+}
+
+// trackAutoAllow records n tokens of demand against the bucket's current
+// window and reports whether the bucket is on the automatic allow list. Demand
+// counts whether or not it was admitted, and keeps being counted while the
+// bucket bypasses the check, so a spike is still seen. The caller must hold
+// the lock.
+func (rl *RateLimiter) trackAutoAllow(bucket string, metadata *RequestMetadata, limit LimitConfig, n int, now time.Time) bool {
+	if rl.autoAllowWindows <= 0 {
+		return false
+	}
+
+	usage := &metadata.window
+	window := limit.window()
+	if usage.start.IsZero() {
+		usage.start = now
+	}
+	if elapsed := now.Sub(usage.start); elapsed >= window {
+		// Windows that passed without a request used none of their quota.
+		closed := int(elapsed / window)
+		if float64(usage.used) < rl.autoAllowThreshold*float64(limit.MaxLimit) {
+			usage.lowWindows += closed
+		} else {
+			usage.lowWindows = closed - 1
+		}
+		usage.start = usage.start.Add(time.Duration(closed) * window)
+		usage.used = 0
+	}
+	usage.used += n
+
+	_, allowed := rl.autoAllowed[bucket]
+	switch {
+	case !allowed && usage.lowWindows >= rl.autoAllowWindows:
+		rl.autoAllowed[bucket] = struct{}{}
+		if rl.onAutoAllow != nil {
+			go rl.onAutoAllow(bucket)
+		}
+		return true
+	case allowed && float64(usage.used) > autoAllowRemoveAt*float64(limit.MaxLimit):
+		delete(rl.autoAllowed, bucket)
+		usage.lowWindows = 0
+		if rl.onAutoRemove != nil {
+			go rl.onAutoRemove(bucket)
+		}
+		return false
+	}
+
+	return allowed
+}
+
+// dropAutoAllowed takes an evicted bucket off the automatic allow list, so
+// it starts counting again rather than staying exempt. The caller must hold
+// the lock.
+func (rl *RateLimiter) dropAutoAllowed(bucket string) {
+	if _, allowed := rl.autoAllowed[bucket]; !allowed {
+		return
+	}
+
+	delete(rl.autoAllowed, bucket)
+	if rl.onAutoRemove != nil {
+		go rl.onAutoRemove(bucket)
+	}
+}
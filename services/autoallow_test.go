@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func receiveKey(t *testing.T, keys <-chan string) string {
+	t.Helper()
+
+	select {
+	case key := <-keys:
+		return key
+	case <-time.After(time.Second):
+		t.Fatal("hook not called")
+		return ""
+	}
+}
+
+func TestRateLimiterAutoAllow(t *testing.T) {
+	allowed, removed := make(chan string, 1), make(chan string, 1)
+	rl, clock := newTestRateLimiter(t, 30, 60, LimiterOptions{
+		AutoAllowThreshold: 0.1,
+		AutoAllowWindows:   10,
+		OnAutoAllow:        func(key string) { allowed <- key },
+		OnAutoRemove:       func(key string) { removed <- key },
+	})
+	// Keep the eviction loop from dropping the bucket as the clock jumps.
+	rl.SetTTL(time.Hour)
+
+	// Two requests every window, under 10% of the quota of 30.
+	rl.Allow("apikey123")
+	for i := range 19 {
+		clock.Advance(30 * time.Second)
+		rl.Allow("apikey123")
+		select {
+		case key := <-allowed:
+			t.Fatalf("%s allowed after %d low windows", key, (i+1)/2)
+		default:
+		}
+	}
+	clock.Advance(30 * time.Second)
+	rl.Allow("apikey123")
+	if key := receiveKey(t, allowed); key != "apikey123" {
+		t.Errorf("OnAutoAllow(%q), want apikey123", key)
+	}
+
+	// Allow-listed, the key is admitted without spending tokens.
+	for i := range 14 {
+		if result, _ := rl.Allow("apikey123"); !result.Allowed {
+			t.Fatalf("request %d on the allow list denied: %+v", i+1, result)
+		}
+	}
+	if remaining := rl.Peek("apikey123").Remaining; remaining != 30 {
+		t.Errorf("allow-listed key spent tokens: %d left, want 30", remaining)
+	}
+
+	// The allow list does not lift the cap on a single request's cost.
+	if _, err := rl.AllowN("apikey123", 31); !errors.Is(err, ErrCostExceedsCapacity) {
+		t.Errorf("allow-listed AllowN(31): err = %v, want ErrCostExceedsCapacity", err)
+	}
+
+	// The 16th request of the window takes it over half of its quota.
+	rl.Allow("apikey123")
+	if key := receiveKey(t, removed); key != "apikey123" {
+		t.Errorf("OnAutoRemove(%q), want apikey123", key)
+	}
+	rl.AllowN("apikey123", 29)
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Errorf("request after removal from the allow list not limited: %+v", result)
+	}
+}
+
+func TestRateLimiterAutoAllowBusyWindowResets(t *testing.T) {
+	allowed := make(chan string, 1)
+	rl, clock := newTestRateLimiter(t, 30, 60, LimiterOptions{
+		AutoAllowThreshold: 0.1,
+		AutoAllowWindows:   2,
+		OnAutoAllow:        func(key string) { allowed <- key },
+	})
+	rl.SetTTL(time.Hour)
+
+	rl.Allow("apikey123")
+	clock.Advance(time.Minute)
+	rl.AllowN("apikey123", 5)
+	clock.Advance(time.Minute)
+	rl.Allow("apikey123")
+
+	// One low window then a busy one: the run starts over.
+	select {
+	case key := <-allowed:
+		t.Fatalf("%s allowed after a busy window", key)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	rl.Allow("apikey123")
+	clock.Advance(time.Minute)
+	rl.Allow("apikey123")
+	if key := receiveKey(t, allowed); key != "apikey123" {
+		t.Errorf("OnAutoAllow(%q), want apikey123", key)
+	}
+}
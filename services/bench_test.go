@@ -0,0 +1,224 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// benchmarkAllow runs Allow on fresh limiters from newLimiter for each key
+// space size and parallelism. The limit is low enough that most calls are
+// denied once every key has spent its burst, as under real overload.
+func benchmarkAllow(b *testing.B, newLimiter func() Limiter) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(previous) })
+
+	for _, keyCount := range []int{1, 100, 10_000} {
+		keys := make([]string, keyCount)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key-%d", i)
+		}
+
+		for _, parallelism := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("keys=%d/P=%d", keyCount, parallelism), func(b *testing.B) {
+				limiter := newLimiter()
+				if stopper, ok := limiter.(interface{ Stop() }); ok {
+					defer stopper.Stop()
+				}
+
+				b.SetParallelism(parallelism)
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						limiter.Allow(keys[i%keyCount])
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkTokenBucketAllow(b *testing.B) {
+	benchmarkAllow(b, func() Limiter { return NewRateLimiter(100, 1) })
+}
+
+// BenchmarkAtomicAllow is the lock-free counterpart of
+// BenchmarkTokenBucketAllow, with the same limit.
+func BenchmarkAtomicAllow(b *testing.B) {
+	benchmarkAllow(b, func() Limiter {
+		al, err := NewAtomicRateLimiter(100, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return al
+	})
+}
+
+func BenchmarkFixedWindowAllow(b *testing.B) {
+	benchmarkAllow(b, func() Limiter { return NewFixedWindowLimiter(100, time.Second) })
+}
+
+func BenchmarkSlidingWindowAllow(b *testing.B) {
+	benchmarkAllow(b, func() Limiter { return NewSlidingWindowLimiter(100, time.Second) })
+}
+
+func BenchmarkGCRAAllow(b *testing.B) {
+	benchmarkAllow(b, func() Limiter { return NewGCRALimiter(100, time.Second, 100) })
+}
+
+func BenchmarkShardedAllow(b *testing.B) {
+	benchmarkAllow(b, func() Limiter { return NewShardedRateLimiter(0, 100, 1) })
+}
+
+// BenchmarkShardedShards compares a single shard, which behaves like one
+// RateLimiter behind one mutex, with the default 256 at GOMAXPROCS=8.
+func BenchmarkShardedShards(b *testing.B) {
+	previous := runtime.GOMAXPROCS(8)
+	b.Cleanup(func() { runtime.GOMAXPROCS(previous) })
+
+	for _, shards := range []int{1, defaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkAllow(b, func() Limiter { return NewShardedRateLimiter(shards, 100, 1) })
+		})
+	}
+}
+
+// BenchmarkMemoryPerKey reports the heap each limiter keeps per key, with
+// every key at its limit of 100 requests per second.
+func BenchmarkMemoryPerKey(b *testing.B) {
+	const keyCount = 1_000
+	keys := make([]string, keyCount)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	limiters := []struct {
+		name       string
+		newLimiter func() Limiter
+	}{
+		{"GCRA", func() Limiter { return NewGCRALimiter(100, time.Second, 100) }},
+		{"SlidingWindow", func() Limiter { return NewSlidingWindowLimiter(100, time.Second) }},
+	}
+	for _, tt := range limiters {
+		b.Run(tt.name, func(b *testing.B) {
+			var perKey float64
+			for b.Loop() {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				limiter := tt.newLimiter()
+				for _, key := range keys {
+					limiter.AllowN(key, 100)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				perKey = float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)) / keyCount
+				runtime.KeepAlive(limiter)
+			}
+			b.ReportMetric(perKey, "B/key")
+		})
+	}
+}
+
+// BenchmarkSerializer times encoding and decoding a 10,000-key snapshot in
+// each format and reports the file size.
+func BenchmarkSerializer(b *testing.B) {
+	buckets := testBuckets(10_000)
+
+	for _, s := range serializers {
+		data, err := s.serializer.Marshal(buckets)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(s.name+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				s.serializer.Marshal(buckets)
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+		b.Run(s.name+"/unmarshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				s.serializer.Unmarshal(data)
+			}
+		})
+	}
+}
+
+// BenchmarkAllowBatch compares deciding N keys with N calls to Allow, each
+// taking the lock, against one AllowBatch call.
+func BenchmarkAllowBatch(b *testing.B) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(previous) })
+
+	for _, n := range []int{10, 100, 1_000} {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key-%d", i)
+		}
+
+		b.Run(fmt.Sprintf("N=%d/sequential", n), func(b *testing.B) {
+			rl := NewRateLimiter(100, 1)
+			defer rl.Stop()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				for _, key := range keys {
+					rl.Allow(key)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("N=%d/batch", n), func(b *testing.B) {
+			rl := NewRateLimiter(100, 1)
+			defer rl.Stop()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				rl.AllowBatch(keys)
+			}
+		})
+	}
+}
+
+// BenchmarkSnapshot copies 10,000 buckets. Allow waits only for the
+// locked part, which must stay under a millisecond so that monitoring
+// scrapes do not hold up requests; building the returned map takes
+// several times longer but runs without the lock.
+func BenchmarkSnapshot(b *testing.B) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(previous) })
+
+	rl := NewRateLimiter(100, 60)
+	defer rl.Stop()
+	for i := range 10_000 {
+		rl.Allow(fmt.Sprintf("key-%d", i))
+	}
+
+	b.Run("locked", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			rl.snapshotBuckets()
+		}
+		if perOp := b.Elapsed() / time.Duration(b.N); perOp > time.Millisecond {
+			b.Errorf("lock held %s per snapshot of 10,000 keys, want under 1ms", perOp)
+		}
+	})
+	b.Run("total", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			rl.Snapshot()
+		}
+	})
+}
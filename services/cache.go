@@ -0,0 +1,133 @@
+package services
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// cachedResult lets Allow repeat an allowed decision without taking the
+// lock. budget holds the tokens moved out of the bucket when the decision
+// was cached, and each hit spends one, so hits are charged like any other
+// request. Whatever is unspent goes back to the bucket when the entry is
+// dropped. Hits update the bucket's usage counters directly, and the tokens
+// they spent count as demand for AutoAllowThreshold once the entry is
+// dropped, so a key busy only through the cache still looks busy.
+type cachedResult struct {
+	result Result
+	at     time.Time
+	budget atomic.Int64
+	// granted is the budget the entry started with.
+	granted int64
+	usage   *keyCounters
+}
+
+// cacheHit answers from apiKey's cached decision while it is fresh and has
+// tokens left.
+func (rl *RateLimiter) cacheHit(apiKey string) (Result, bool) {
+	cached, found := rl.cache.Load(apiKey)
+	if !found {
+		return Result{}, false
+	}
+
+	entry := cached.(*cachedResult)
+	now := rl.clock.Now()
+	if now.Sub(entry.at) >= rl.cacheWindow {
+		return Result{}, false
+	}
+
+	for {
+		budget := entry.budget.Load()
+		if budget <= 0 {
+			return Result{}, false
+		}
+		if entry.budget.CompareAndSwap(budget, budget-1) {
+			entry.usage.record(true, now)
+			result := entry.result
+			result.Remaining = int(budget - 1)
+			return result, true
+		}
+	}
+}
+
+// allowAndCache decides one token for apiKey and, if it was allowed, caches
+// the decision with the rest of the bucket as its budget.
+func (rl *RateLimiter) allowAndCache(apiKey string) (Result, time.Duration, error) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.clock.Now()
+	result, window, err := rl.allowNLocked(apiKey, 1, now)
+	if err != nil || !result.Allowed {
+		return result, window, err
+	}
+
+	metadata, exists := rl.requests[apiKey]
+	if !exists || !rl.cacheable(apiKey, metadata) {
+		return result, window, nil
+	}
+
+	entry := &cachedResult{result: result, at: now, granted: int64(metadata.tokenCount), usage: metadata.usage}
+	entry.budget.Store(entry.granted)
+	metadata.tokenCount = 0
+	rl.cache.Store(apiKey, entry)
+	return result, window, nil
+}
+
+// cacheable leaves out buckets that other keys draw from, since their
+// requests could not see the budget, and buckets whose decisions do not
+// come from their tokens. The caller must hold the lock.
+func (rl *RateLimiter) cacheable(apiKey string, metadata *RequestMetadata) bool {
+	if rl.bucketKey(apiKey) != apiKey || metadata.tokenCount == 0 || metadata.granted > 0 {
+		return false
+	}
+	if _, allowed := rl.allowlist[apiKey]; allowed {
+		return false
+	}
+	if _, allowed := rl.autoAllowed[apiKey]; allowed {
+		return false
+	}
+
+	return true
+}
+
+// dropCached forgets apiKey's cached decision and returns its unspent budget
+// to the bucket. The caller must hold the lock.
+func (rl *RateLimiter) dropCached(apiKey string) {
+	cached, found := rl.cache.LoadAndDelete(apiKey)
+	if !found {
+		return
+	}
+
+	entry := cached.(*cachedResult)
+	unused := entry.budget.Swap(0)
+	metadata, exists := rl.requests[apiKey]
+	if !exists {
+		return
+	}
+	if rl.autoAllowWindows > 0 {
+		metadata.window.used += int(entry.granted - unused)
+	}
+	if unused > 0 {
+		metadata.tokenCount = min(metadata.tokenCount+int(unused), max(metadata.burst, metadata.tokenCount))
+	}
+}
+
+// dropAllCached is dropCached for every key, for ResetAll. The caller must
+// hold the lock.
+func (rl *RateLimiter) dropAllCached() {
+	rl.cache.Range(func(apiKey, _ any) bool {
+		rl.dropCached(apiKey.(string))
+		return true
+	})
+}
+
+// cachedBudget is how many tokens apiKey's cached decision still holds, so
+// Peek can count them as the bucket's.
+func (rl *RateLimiter) cachedBudget(apiKey string) int {
+	cached, found := rl.cache.Load(apiKey)
+	if !found {
+		return 0
+	}
+
+	return int(cached.(*cachedResult).budget.Load())
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"net/http"
+	"slices"
+)
+
+type ChainLimiter struct {
+	limiters []Limiter
+}
+
+func NewChainLimiter(limiters ...Limiter) *ChainLimiter {
+	return &ChainLimiter{limiters: limiters}
+}
+
+func (cl *ChainLimiter) Allow(key string) (Result, error) {
+	return cl.AllowN(key, 1)
+}
+
+// AllowN asks each limiter in order and stops at the first denial, so later
+// limiters are never charged for a request that was already rejected. Tokens
+// taken by the earlier limiters are kept. The returned Result is the denying
+// one, or otherwise the one with the fewest tokens left.
+func (cl *ChainLimiter) AllowN(key string, n int) (Result, error) {
+	var strictest Result
+	for i, limiter := range cl.limiters {
+		result, err := limiter.AllowN(key, n)
+		if err != nil || !result.Allowed {
+			return result, err
+		}
+
+		if i == 0 || moreRestrictive(result, strictest) {
+			strictest = result
+		}
+	}
+
+	if len(cl.limiters) == 0 {
+		strictest.Allowed = true
+	}
+	return strictest, nil
+}
+
+func (cl *ChainLimiter) Peek(key string) Result {
+	var strictest Result
+	for i, limiter := range cl.limiters {
+		result := limiter.Peek(key)
+		if !result.Allowed {
+			return result
+		}
+
+		if i == 0 || moreRestrictive(result, strictest) {
+			strictest = result
+		}
+	}
+
+	strictest.Allowed = true
+	return strictest
+}
+
+func moreRestrictive(a, b Result) bool {
+	if a.Remaining != b.Remaining {
+		return a.Remaining < b.Remaining
+	}
+
+	return a.ResetAt.Before(b.ResetAt)
+}
+
+// MiddlewareChain assembles the rate limiter and the middleware around it
+// in a fixed order. A request passes through the Before middleware in the
+// order given, then the limiter, then the After middleware, then the
+// handler:
+//
+//	Before[0] → … → Before[n] → rate limiter → After[0] → … → After[n] → handler
+//
+// Before is for middleware that must see every request, including rejected
+// ones, or that the key extractor depends on: request IDs, logging,
+// recovery, CORS preflight and authentication. After is for work only
+// admitted requests should pay for, such as body decoding.
+type MiddlewareChain struct {
+	// Options configures the rate limiter middleware.
+	Options Options
+	before  []func(http.Handler) http.Handler
+	after   []func(http.Handler) http.Handler
+}
+
+func (c *MiddlewareChain) Before(mw ...func(http.Handler) http.Handler) *MiddlewareChain {
+	c.before = append(c.before, mw...)
+	return c
+}
+
+func (c *MiddlewareChain) After(mw ...func(http.Handler) http.Handler) *MiddlewareChain {
+	c.after = append(c.after, mw...)
+	return c
+}
+
+func (c *MiddlewareChain) Build(handler http.Handler, limiter Limiter, extractor KeyExtractor) http.Handler {
+	for _, mw := range slices.Backward(c.after) {
+		handler = mw(handler)
+	}
+	handler = NewRateLimiterMiddlewareWithOptions(handler, limiter, extractor, c.Options)
+	for _, mw := range slices.Backward(c.before) {
+		handler = mw(handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChainLimiterPerSecondAndPerDay(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+	newLimiter := func(maxLimit, timeLimit int) *RateLimiter {
+		rl, err := NewRateLimiterWithOptions(context.Background(), maxLimit, timeLimit, LimiterOptions{
+			Clock:  clock,
+			Logger: slog.New(slog.DiscardHandler),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(rl.Stop)
+		return rl
+	}
+	perSecond := newLimiter(2, 1)
+	perDay := newLimiter(5, 86400)
+	chain := NewChainLimiter(perSecond, perDay)
+
+	steps := []struct {
+		advance       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantDayLeft   int
+	}{
+		{wantAllowed: true, wantRemaining: 1, wantDayLeft: 4},
+		{wantAllowed: true, wantRemaining: 0, wantDayLeft: 3},
+		// Denied by the per-second limit; the daily quota is not charged.
+		{wantAllowed: false, wantRemaining: 0, wantDayLeft: 3},
+		{advance: time.Second, wantAllowed: true, wantRemaining: 1, wantDayLeft: 2},
+		// The daily quota is now the stricter one.
+		{wantAllowed: true, wantRemaining: 0, wantDayLeft: 1},
+		{advance: time.Second, wantAllowed: true, wantRemaining: 0, wantDayLeft: 0},
+		{wantAllowed: false, wantRemaining: 0, wantDayLeft: 0},
+	}
+
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		result, err := chain.Allow("key")
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		if result.Allowed != step.wantAllowed {
+			t.Errorf("request %d: Allowed = %v, want %v", i+1, result.Allowed, step.wantAllowed)
+		}
+		if result.Remaining != step.wantRemaining {
+			t.Errorf("request %d: Remaining = %d, want %d", i+1, result.Remaining, step.wantRemaining)
+		}
+		if left := perDay.Peek("key").Remaining; left != step.wantDayLeft {
+			t.Errorf("request %d: daily quota has %d left, want %d", i+1, left, step.wantDayLeft)
+		}
+	}
+
+	clock.Advance(time.Second)
+	if result, _ := chain.Allow("key"); result.Limit != 5 || result.RetryAfter < time.Hour {
+		t.Errorf("denial by the daily quota = %+v, want its Limit and RetryAfter", result)
+	}
+}
+
+func TestChainLimiterPicksStrictestResult(t *testing.T) {
+	reset := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name    string
+		results []Result
+		want    Result
+	}{
+		{
+			name:    "no limiters",
+			results: nil,
+			want:    Result{Allowed: true},
+		},
+		{
+			name: "fewest remaining",
+			results: []Result{
+				{Allowed: true, Limit: 10, Remaining: 5, ResetAt: reset},
+				{Allowed: true, Limit: 3, Remaining: 2, ResetAt: reset.Add(time.Hour)},
+			},
+			want: Result{Allowed: true, Limit: 3, Remaining: 2, ResetAt: reset.Add(time.Hour)},
+		},
+		{
+			name: "soonest reset on a tie",
+			results: []Result{
+				{Allowed: true, Limit: 10, Remaining: 2, ResetAt: reset.Add(time.Hour)},
+				{Allowed: true, Limit: 3, Remaining: 2, ResetAt: reset},
+			},
+			want: Result{Allowed: true, Limit: 3, Remaining: 2, ResetAt: reset},
+		},
+		{
+			name: "first denial",
+			results: []Result{
+				{Allowed: true, Limit: 10, Remaining: 0},
+				{Allowed: false, Limit: 3, RetryAfter: time.Second},
+				{Allowed: false, Limit: 1, RetryAfter: time.Hour},
+			},
+			want: Result{Allowed: false, Limit: 3, RetryAfter: time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiters := make([]Limiter, len(tt.results))
+			calls := make([]int, len(tt.results))
+			for i, result := range tt.results {
+				limiters[i] = fixedLimiter{result: result, calls: &calls[i]}
+			}
+
+			got, err := NewChainLimiter(limiters...).Allow("key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Allow = %+v, want %+v", got, tt.want)
+			}
+			if len(calls) == 3 && calls[2] != 0 {
+				t.Error("limiter after the denial was charged")
+			}
+		})
+	}
+}
+
+// fixedLimiter answers every call with result and counts the calls that
+// would have taken tokens.
+type fixedLimiter struct {
+	result Result
+	calls  *int
+}
+
+func (f fixedLimiter) Allow(key string) (Result, error) {
+	return f.AllowN(key, 1)
+}
+
+func (f fixedLimiter) AllowN(key string, n int) (Result, error) {
+	*f.calls++
+	return f.result, nil
+}
+
+func (f fixedLimiter) Peek(key string) Result {
+	return f.result
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	var log []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log = append(log, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log = append(log, "handler")
+	})
+
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	chain := (&MiddlewareChain{}).Before(record("logging"), record("auth")).After(record("decode"))
+	chain.After(record("validate"))
+	built := chain.Build(handler, rl, APIKeyExtractor)
+
+	tests := []struct {
+		name     string
+		wantCode int
+		wantLog  []string
+	}{
+		{name: "allowed", wantCode: http.StatusOK, wantLog: []string{"logging", "auth", "decode", "validate", "handler"}},
+		{name: "denied", wantCode: http.StatusTooManyRequests, wantLog: []string{"logging", "auth"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log = nil
+			if rec := serve(built, "apikey123"); rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if !slices.Equal(log, tt.wantLog) {
+				t.Errorf("ran %v, want %v", log, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		if header := r.Header.Get("X-Request-ID"); header != seen {
+			t.Errorf("request header %q differs from the context's %q", header, seen)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !uuid.MatchString(seen) || rec.Header().Get("X-Request-ID") != seen {
+		t.Errorf("generated ID %q, echoed %q; want one version 4 UUID", seen, rec.Header().Get("X-Request-ID"))
+	}
+	first := seen
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if seen == first {
+		t.Error("two requests got the same generated ID")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "incoming")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen != "incoming" || rec.Header().Get("X-Request-ID") != "incoming" {
+		t.Errorf("incoming X-Request-ID replaced by %q", seen)
+	}
+
+	if id := RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("RequestIDFromContext without an ID = %q", id)
+	}
+}
+
+func TestRequestIDRejectsUnsafeIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		keep bool
+	}{
+		{name: "trace ID", id: "4bf92f3577b34da6a3ce929d0e0e4736", keep: true},
+		{name: "dotted and dashed", id: "web-1.req_42", keep: true},
+		{name: "longest kept", id: strings.Repeat("a", 128), keep: true},
+		{name: "too long", id: strings.Repeat("a", 129)},
+		{name: "space", id: "req 1"},
+		{name: "log injection", id: "req\nlevel=error"},
+		{name: "markup", id: "<script>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Get("X-Request-ID")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-ID", tt.id)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if kept := seen == tt.id; kept != tt.keep {
+				t.Errorf("handler saw ID %q for incoming %q, kept = %t, want %t", seen, tt.id, kept, tt.keep)
+			}
+			if echoed := rec.Header().Get("X-Request-ID"); echoed != seen {
+				t.Errorf("echoed %q, want the handler's %q", echoed, seen)
+			}
+			if got := req.Header.Get("X-Request-ID"); got != tt.id {
+				t.Errorf("caller's request header changed to %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// CheckOnlyHandler answers a rate-limit check without serving the request,
+// for gateways that delegate the decision, such as Envoy ext_authz or nginx
+// auth_request. It takes a token and replies 200, or replies with the
+// denied response and takes nothing.
+func CheckOnlyHandler(limiter Limiter, extractor KeyExtractor) http.Handler {
+	return CheckOnlyHandlerWithOptions(limiter, extractor, Options{})
+}
+
+func CheckOnlyHandlerWithOptions(limiter Limiter, extractor KeyExtractor, opts Options) http.Handler {
+	opts = opts.withDefaults()
+
+	return checkHandler(extractor, opts, func(r *http.Request, key string) (Result, error) {
+		return allowN(r.Context(), limiter, key, requestCost(r, limiter, key, opts))
+	})
+}
+
+// PeekOnlyHandler is CheckOnlyHandler without taking a token: it replies
+// 200 while the key has tokens left.
+func PeekOnlyHandler(limiter Limiter, extractor KeyExtractor) http.Handler {
+	return PeekOnlyHandlerWithOptions(limiter, extractor, Options{})
+}
+
+func PeekOnlyHandlerWithOptions(limiter Limiter, extractor KeyExtractor, opts Options) http.Handler {
+	opts = opts.withDefaults()
+
+	return checkHandler(extractor, opts, func(r *http.Request, key string) (Result, error) {
+		return limiter.Peek(key), nil
+	})
+}
+
+func checkHandler(extractor KeyExtractor, opts Options, check func(r *http.Request, key string) (Result, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := extractor(r)
+		if err != nil {
+			opts.OnError(w, r, err)
+			return
+		}
+
+		result, err := check(r, key)
+		setRateLimitHeaders(w, result)
+		setPolicyHeader(w, opts.RouteID, result)
+
+		if errors.Is(err, ErrCostExceedsCapacity) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil || !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(result)))
+			opts.ErrorBody(w, r, result)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckOnlyHandler(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	handler := CheckOnlyHandler(rl, APIKeyExtractor)
+
+	steps := []struct {
+		wantCode      int
+		wantRemaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	}
+	for i, step := range steps {
+		rec := serve(handler, "apikey123")
+		if rec.Code != step.wantCode || rec.Header().Get("X-RateLimit-Remaining") != step.wantRemaining {
+			t.Errorf("check %d: %d with %q remaining, want %d with %s",
+				i+1, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), step.wantCode, step.wantRemaining)
+		}
+		if rec.Body.String() == "ok" {
+			t.Errorf("check %d reached a handler", i+1)
+		}
+	}
+
+	if rec := serve(handler, "apikey123"); rec.Header().Get("Retry-After") == "" {
+		t.Error("denied check has no Retry-After")
+	}
+	if rec := serve(handler, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("check without a key: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestPeekOnlyHandler(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	handler := PeekOnlyHandler(rl, APIKeyExtractor)
+
+	for range 3 {
+		if rec := serve(handler, "apikey123"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "2" {
+			t.Fatalf("peek: %d with %q remaining, want 200 with 2 and no token taken", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+
+	rl.AllowN("apikey123", 2)
+	if rec := serve(handler, "apikey123"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("peek of an empty bucket: status = %d, want 429", rec.Code)
+	}
+}
+
+func TestCheckHandlerOptions(t *testing.T) {
+	opts := Options{DeniedStatusCode: http.StatusServiceUnavailable, DeniedMessage: "Throttled", RouteID: "check"}
+	tests := []struct {
+		name    string
+		handler func(Limiter) http.Handler
+	}{
+		{name: "check only", handler: func(l Limiter) http.Handler { return CheckOnlyHandlerWithOptions(l, APIKeyExtractor, opts) }},
+		{name: "peek only", handler: func(l Limiter) http.Handler { return PeekOnlyHandlerWithOptions(l, APIKeyExtractor, opts) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+			rl.Allow("apikey123")
+
+			rec := serve(tt.handler(rl), "apikey123")
+			if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "Throttled\n" {
+				t.Errorf("response = %d %q, want the configured 503", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("X-RateLimit-Policy"); got != "check;w=60;q=1" {
+				t.Errorf("X-RateLimit-Policy = %q", got)
+			}
+		})
+	}
+}
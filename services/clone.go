@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// Clone builds a new limiter with the receiver's limit, window, TTL and
+// options, replacing the options that are set in overrides. A zero field in
+// overrides keeps the receiver's value, so Clone cannot switch an option
+// off. The clone starts empty: buckets, per-key overrides, allow and deny
+// lists and groups are not copied. It does not share the receiver's
+// snapshot file unless overrides name one, and like any limiter it should
+// be stopped when done with. The limit and window are copied as they are,
+// so a receiver built by NewRateLimiter without validation clones as it
+// stands. Clone panics if the resulting options are invalid.
+func (rl *RateLimiter) Clone(overrides LimiterOptions) *RateLimiter {
+	rl.mutex.Lock()
+	opts := rl.options
+	ttl := rl.ttl
+	rl.mutex.Unlock()
+
+	opts.SnapshotPath = ""
+	opts = mergeLimiterOptions(opts, overrides)
+
+	if err := validateLimiterOptions(rl.maxLimit, opts); err != nil {
+		panic(fmt.Sprintf("services: invalid clone options: %v", err))
+	}
+
+	clone := newRateLimiter(context.Background(), rl.maxLimit, rl.timeLImit, opts)
+	clone.SetTTL(ttl)
+	return clone
+}
+
+func mergeLimiterOptions(base, overrides LimiterOptions) LimiterOptions {
+	if overrides.Logger != nil {
+		base.Logger = overrides.Logger
+	}
+	if overrides.Burst != 0 {
+		base.Burst = overrides.Burst
+	}
+	if overrides.Clock != nil {
+		base.Clock = overrides.Clock
+	}
+	if overrides.PollInterval != 0 {
+		base.PollInterval = overrides.PollInterval
+	}
+	if overrides.WarmupDuration != 0 {
+		base.WarmupDuration = overrides.WarmupDuration
+	}
+	if overrides.WarmupMultiplier != 0 {
+		base.WarmupMultiplier = overrides.WarmupMultiplier
+	}
+	if overrides.KeyHasher != nil {
+		base.KeyHasher = overrides.KeyHasher
+	}
+	if overrides.CacheWindow != 0 {
+		base.CacheWindow = overrides.CacheWindow
+	}
+	if overrides.SnapshotPath != "" {
+		base.SnapshotPath = overrides.SnapshotPath
+	}
+	if overrides.PersistInterval != 0 {
+		base.PersistInterval = overrides.PersistInterval
+	}
+	if overrides.SnapshotSerializer != nil {
+		base.SnapshotSerializer = overrides.SnapshotSerializer
+	}
+	if overrides.OnKeyUsed != nil {
+		base.OnKeyUsed = overrides.OnKeyUsed
+	}
+	if overrides.RefillJitter != 0 {
+		base.RefillJitter = overrides.RefillJitter
+	}
+	if overrides.RefillStrategy != nil {
+		base.RefillStrategy = overrides.RefillStrategy
+	}
+	if overrides.AutoAllowThreshold != 0 {
+		base.AutoAllowThreshold = overrides.AutoAllowThreshold
+	}
+	if overrides.AutoAllowWindows != 0 {
+		base.AutoAllowWindows = overrides.AutoAllowWindows
+	}
+	if overrides.OnAutoAllow != nil {
+		base.OnAutoAllow = overrides.OnAutoAllow
+	}
+	if overrides.OnAutoRemove != nil {
+		base.OnAutoRemove = overrides.OnAutoRemove
+	}
+
+	return base
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterClone(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	rl.SetTTL(time.Hour)
+	if err := rl.SetLimit("apikey124", 1, 60); err != nil {
+		t.Fatal(err)
+	}
+	rl.AllowN("apikey123", 3)
+
+	clone := rl.Clone(LimiterOptions{Burst: 2})
+	t.Cleanup(clone.Stop)
+
+	// The clone starts empty and without the per-key override.
+	if peek := clone.Peek("apikey123"); peek.Remaining != 2 {
+		t.Errorf("clone Peek(apikey123) = %+v, want an empty limiter's bucket of 2", peek)
+	}
+	if peek := clone.Peek("apikey124"); peek.Limit != 5 {
+		t.Errorf("clone Peek(apikey124).Limit = %d, want the default 5", peek.Limit)
+	}
+	if clone.ttl != time.Hour {
+		t.Errorf("clone TTL = %s, want the receiver's hour", clone.ttl)
+	}
+
+	for i := range 2 {
+		if result, _ := clone.Allow("apikey123"); !result.Allowed {
+			t.Fatalf("clone request %d denied: %+v", i+1, result)
+		}
+	}
+	if result, _ := clone.Allow("apikey123"); result.Allowed {
+		t.Errorf("clone allowed more than its burst of 2: %+v", result)
+	}
+
+	// The original keeps its own buckets and options.
+	if peek := rl.Peek("apikey123"); peek.Remaining != 2 {
+		t.Errorf("original has %d tokens, want the 2 it had before cloning", peek.Remaining)
+	}
+	if result, _ := rl.AllowN("apikey123", 2); !result.Allowed {
+		t.Errorf("original denied its remaining tokens: %+v", result)
+	}
+	if peek := rl.Peek("apikey124"); peek.Limit != 1 {
+		t.Errorf("original lost its override: Limit = %d", peek.Limit)
+	}
+
+	// Both share the receiver's clock unless overrides replace it.
+	clock.Advance(time.Minute)
+	if peek := clone.Peek("apikey123"); peek.Remaining != 2 {
+		t.Errorf("clone after a window = %+v, want a full bucket", peek)
+	}
+}
+
+func TestRateLimiterClonePanicsOnInvalidOptions(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Clone with an invalid refill jitter did not panic")
+		}
+	}()
+	rl.Clone(LimiterOptions{RefillJitter: 2})
+}
+
+func TestRateLimiterCloneUnvalidatedReceiver(t *testing.T) {
+	rl := NewRateLimiter(0, 60)
+	t.Cleanup(rl.Stop)
+
+	clone := rl.Clone(LimiterOptions{})
+	t.Cleanup(clone.Stop)
+	if clone.maxLimit != 0 || clone.timeLImit != 60 {
+		t.Errorf("clone limit = %d per %ds, want the receiver's 0 per 60s", clone.maxLimit, clone.timeLImit)
+	}
+}
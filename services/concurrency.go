@@ -0,0 +1,50 @@
+package services
+
+import "sync"
+
+type ConcurrencyLimiter struct {
+	inflight    map[string]chan struct{}
+	mutex       sync.Mutex
+	maxInflight int
+}
+
+func NewConcurrencyLimiter(maxInflight int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		inflight:    make(map[string]chan struct{}),
+		maxInflight: maxInflight,
+	}
+}
+
+// Acquire takes one of the key's inflight slots. The returned release must
+// be called once the request finishes; calling it more than once is safe.
+func (cl *ConcurrencyLimiter) Acquire(key string) (release func(), ok bool) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	sem, exists := cl.inflight[key]
+	if !exists {
+		sem = make(chan struct{}, cl.maxInflight)
+		cl.inflight[key] = sem
+	}
+
+	select {
+	case sem <- struct{}{}:
+	default:
+		return func() {}, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { cl.release(key, sem) })
+	}, true
+}
+
+func (cl *ConcurrencyLimiter) release(key string, sem chan struct{}) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	<-sem
+	if len(sem) == 0 {
+		delete(cl.inflight, key)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	ListenAddr     string `yaml:"listenAddr"`
+	MaxLimit       int    `yaml:"maxLimit"`
+	WindowSeconds  int    `yaml:"windowSeconds"`
+	Algorithm      string `yaml:"algorithm"`
+	RedisURL       string `yaml:"redisURL"`
+	RedisKeyPrefix string `yaml:"redisKeyPrefix"`
+	FailOpen       bool   `yaml:"failOpen"`
+	AdminToken     string `yaml:"adminToken"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:     ":8083",
+		MaxLimit:       5,
+		WindowSeconds:  60,
+		Algorithm:      string(TokenBucket),
+		RedisKeyPrefix: "ratelimit:",
+	}
+}
+
+// LoadConfig reads the YAML file at path on top of the defaults and then
+// applies any RATE_LIMITER_* environment variables, which take priority. An
+// empty path skips the file.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func LoadConfigFromEnv() (Config, error) {
+	return LoadConfig("")
+}
+
+func applyEnv(cfg *Config) error {
+	stringVars := map[string]*string{
+		"RATE_LIMITER_LISTEN_ADDR":      &cfg.ListenAddr,
+		"RATE_LIMITER_ALGORITHM":        &cfg.Algorithm,
+		"RATE_LIMITER_REDIS_URL":        &cfg.RedisURL,
+		"RATE_LIMITER_REDIS_KEY_PREFIX": &cfg.RedisKeyPrefix,
+		"RATE_LIMITER_ADMIN_TOKEN":      &cfg.AdminToken,
+	}
+	for name, field := range stringVars {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	intVars := map[string]*int{
+		"RATE_LIMITER_MAX_LIMIT":      &cfg.MaxLimit,
+		"RATE_LIMITER_WINDOW_SECONDS": &cfg.WindowSeconds,
+	}
+	for name, field := range intVars {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	if value, ok := os.LookupEnv("RATE_LIMITER_FAIL_OPEN"); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("RATE_LIMITER_FAIL_OPEN: %w", err)
+		}
+		cfg.FailOpen = parsed
+	}
+
+	return nil
+}
+
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.MaxLimit <= 0 {
+		errs = append(errs, fmt.Errorf("maxLimit must be positive, got %d", cfg.MaxLimit))
+	}
+	if cfg.WindowSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("windowSeconds must be positive, got %d", cfg.WindowSeconds))
+	}
+
+	switch AlgorithmKind(cfg.Algorithm) {
+	case TokenBucket, FixedWindow, SlidingWindow, LeakyBucket, GCRA, HybridWindow:
+	default:
+		errs = append(errs, fmt.Errorf("unknown algorithm %q", cfg.Algorithm))
+	}
+
+	if cfg.RedisURL != "" && AlgorithmKind(cfg.Algorithm) != TokenBucket {
+		errs = append(errs, fmt.Errorf("the redis backend only supports %s", TokenBucket))
+	}
+	// The admin endpoints manage an in-memory RateLimiter's buckets.
+	if cfg.AdminToken != "" && (cfg.RedisURL != "" || AlgorithmKind(cfg.Algorithm) != TokenBucket) {
+		errs = append(errs, fmt.Errorf("adminToken needs the in-memory %s limiter", TokenBucket))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cfg Config) LimiterConfig() LimiterConfig {
+	return LimiterConfig{
+		MaxLimit:  cfg.MaxLimit,
+		Window:    time.Duration(cfg.WindowSeconds) * time.Second,
+		Algorithm: AlgorithmKind(cfg.Algorithm),
+	}
+}
+
+func NewRateLimiterFromConfig(cfg Config) (Limiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.RedisURL == "" {
+		return NewLimiter(cfg.LimiterConfig())
+	}
+
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+
+	failMode := FailClosed
+	if cfg.FailOpen {
+		failMode = FailOpen
+	}
+
+	window := time.Duration(cfg.WindowSeconds) * time.Second
+	return NewRedisLimiterWithOptions(redis.NewClient(redisOpts), cfg.MaxLimit, window, cfg.RedisKeyPrefix, RedisOptions{FailMode: failMode}), nil
+}
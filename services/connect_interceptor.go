@@ -0,0 +1,100 @@
+//go:build connect
+
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"connectrpc.com/connect"
+)
+
+func ConnectAPIKeyExtractor(req connect.AnyRequest) string {
+	return req.Header().Get("X-API-Key")
+}
+
+func ConnectStreamAPIKeyExtractor(conn connect.StreamingHandlerConn) string {
+	return conn.RequestHeader().Get("X-API-Key")
+}
+
+func ConnectUnaryInterceptor(limiter Limiter, extractor func(connect.AnyRequest) string) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient {
+				return next(ctx, req)
+			}
+
+			header, err := checkConnect(ctx, limiter, extractor(req))
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := next(ctx, req)
+			if resp != nil {
+				copyHeader(resp.Header(), header)
+			}
+
+			return resp, err
+		}
+	}
+}
+
+// ConnectStreamInterceptor checks the limit once per stream, when the
+// handler is called; messages within the stream are not counted.
+func ConnectStreamInterceptor(limiter Limiter, extractor func(connect.StreamingHandlerConn) string) connect.Interceptor {
+	return &connectStreamInterceptor{limiter: limiter, extractor: extractor}
+}
+
+type connectStreamInterceptor struct {
+	limiter   Limiter
+	extractor func(connect.StreamingHandlerConn) string
+}
+
+func (ci *connectStreamInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return next
+}
+
+func (ci *connectStreamInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (ci *connectStreamInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		header, err := checkConnect(ctx, ci.limiter, ci.extractor(conn))
+		if err != nil {
+			return err
+		}
+
+		copyHeader(conn.ResponseHeader(), header)
+		return next(ctx, conn)
+	}
+}
+
+func checkConnect(ctx context.Context, limiter Limiter, key string) (http.Header, error) {
+	if key == "" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("missing API key"))
+	}
+
+	result, err := allowN(ctx, limiter, key, 1)
+	header := make(http.Header)
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+
+	if err != nil || !result.Allowed {
+		connectErr := connect.NewError(connect.CodeResourceExhausted, errors.New("rate limit exceeded"))
+		copyHeader(connectErr.Meta(), header)
+		connectErr.Meta().Set("Connect-Retry-After", strconv.Itoa(retryAfterSeconds(result)))
+		return nil, connectErr
+	}
+
+	return header, nil
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = values
+	}
+}
@@ -0,0 +1,117 @@
+//go:build connect
+
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	connectPingProcedure  = "/ratelimiter.test.v1.TestService/Ping"
+	connectWatchProcedure = "/ratelimiter.test.v1.TestService/Watch"
+)
+
+// newConnectServer serves a unary Ping and a server-streaming Watch behind
+// the rate-limit interceptors, each with its own limiter of maxLimit.
+func newConnectServer(t *testing.T, maxLimit int) *httptest.Server {
+	t.Helper()
+
+	unaryLimiter, _ := newTestRateLimiter(t, maxLimit, 60, LimiterOptions{})
+	streamLimiter, _ := newTestRateLimiter(t, maxLimit, 60, LimiterOptions{})
+
+	mux := http.NewServeMux()
+	mux.Handle(connectPingProcedure, connect.NewUnaryHandler(connectPingProcedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		connect.WithInterceptors(ConnectUnaryInterceptor(unaryLimiter, ConnectAPIKeyExtractor)),
+	))
+	mux.Handle(connectWatchProcedure, connect.NewServerStreamHandler(connectWatchProcedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty], stream *connect.ServerStream[emptypb.Empty]) error {
+			for range 3 {
+				if err := stream.Send(&emptypb.Empty{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		connect.WithInterceptors(ConnectStreamInterceptor(streamLimiter, ConnectStreamAPIKeyExtractor)),
+	))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func connectPing(server *httptest.Server, apiKey string) (*connect.Response[emptypb.Empty], error) {
+	client := connect.NewClient[emptypb.Empty, emptypb.Empty](server.Client(), server.URL+connectPingProcedure)
+	req := connect.NewRequest(&emptypb.Empty{})
+	if apiKey != "" {
+		req.Header().Set("X-API-Key", apiKey)
+	}
+
+	return client.CallUnary(context.Background(), req)
+}
+
+func TestConnectUnaryInterceptor(t *testing.T) {
+	server := newConnectServer(t, 2)
+
+	for i := range 2 {
+		resp, err := connectPing(server, "apikey123")
+		if err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		if got := resp.Header().Get("X-RateLimit-Remaining"); got != []string{"1", "0"}[i] {
+			t.Errorf("call %d: X-RateLimit-Remaining = %q", i+1, got)
+		}
+	}
+
+	_, err := connectPing(server, "apikey123")
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) || connectErr.Code() != connect.CodeResourceExhausted {
+		t.Fatalf("call over the limit: err = %v, want ResourceExhausted", err)
+	}
+	if got := connectErr.Meta().Get("Connect-Retry-After"); got == "" || got == "0" {
+		t.Errorf("Connect-Retry-After = %q, want the seconds until a token", got)
+	}
+
+	if _, err := connectPing(server, ""); connect.CodeOf(err) != connect.CodeUnauthenticated {
+		t.Errorf("call without a key: err = %v, want Unauthenticated", err)
+	}
+}
+
+func TestConnectStreamInterceptor(t *testing.T) {
+	server := newConnectServer(t, 1)
+	client := connect.NewClient[emptypb.Empty, emptypb.Empty](server.Client(), server.URL+connectWatchProcedure)
+
+	watch := func() (int, error) {
+		req := connect.NewRequest(&emptypb.Empty{})
+		req.Header().Set("X-API-Key", "apikey123")
+		stream, err := client.CallServerStream(context.Background(), req)
+		if err != nil {
+			return 0, err
+		}
+		defer stream.Close()
+
+		received := 0
+		for stream.Receive() {
+			received++
+		}
+		return received, stream.Err()
+	}
+
+	// The stream is charged once, however many messages it carries.
+	if received, err := watch(); err != nil || received != 3 {
+		t.Fatalf("first stream: %d messages, %v; want 3", received, err)
+	}
+	if _, err := watch(); connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Errorf("stream over the limit: err = %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ConnectionLimiter caps how many long-lived connections, such as SSE
+// streams or WebSockets, a key may hold open at once. Per-request limits
+// only see the handshake of those connections.
+type ConnectionLimiter struct {
+	open     map[string]int
+	conns    map[string]string
+	mutex    sync.Mutex
+	maxConns int
+	nextID   uint64
+}
+
+func NewConnectionLimiter(maxConns int) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		open:     make(map[string]int),
+		conns:    make(map[string]string),
+		maxConns: maxConns,
+	}
+}
+
+// Open registers a connection for key, returning false once key already
+// holds maxConns. The returned ID must be passed to Close when the
+// connection ends.
+func (cl *ConnectionLimiter) Open(key string) (connID string, ok bool) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if cl.open[key] >= cl.maxConns {
+		return "", false
+	}
+
+	cl.nextID++
+	connID = strconv.FormatUint(cl.nextID, 10)
+	cl.conns[connID] = key
+	cl.open[key]++
+	return connID, true
+}
+
+// Close releases a connection. Closing an ID twice, or one Open never
+// returned, does nothing.
+func (cl *ConnectionLimiter) Close(connID string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	key, exists := cl.conns[connID]
+	if !exists {
+		return
+	}
+
+	delete(cl.conns, connID)
+	if cl.open[key]--; cl.open[key] <= 0 {
+		delete(cl.open, key)
+	}
+}
+
+func (cl *ConnectionLimiter) OpenConnections(key string) int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return cl.open[key]
+}
+
+// ConnectionLimiterMiddleware holds a connection slot for as long as next
+// is serving the request. The slot is also released as soon as the client
+// goes away, even if next has not noticed yet.
+func ConnectionLimiterMiddleware(next http.Handler, limiter *ConnectionLimiter, extractor KeyExtractor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := extractor(r)
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+
+		connID, ok := limiter.Open(key)
+		if !ok {
+			http.Error(w, "Too many open connections", http.StatusTooManyRequests)
+			return
+		}
+		defer limiter.Close(connID)
+
+		go func() {
+			<-r.Context().Done()
+			limiter.Close(connID)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
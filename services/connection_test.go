@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnectionLimiter(t *testing.T) {
+	cl := NewConnectionLimiter(2)
+
+	first, ok := cl.Open("key")
+	if !ok {
+		t.Fatal("first connection refused")
+	}
+	second, ok := cl.Open("key")
+	if !ok || second == first {
+		t.Fatalf("second connection: id %q, ok %v", second, ok)
+	}
+	if _, ok := cl.Open("key"); ok {
+		t.Error("third connection opened past the limit")
+	}
+	if _, ok := cl.Open("other"); !ok {
+		t.Error("another key refused")
+	}
+
+	cl.Close(first)
+	cl.Close(first)
+	cl.Close("never-opened")
+	if open := cl.OpenConnections("key"); open != 1 {
+		t.Errorf("OpenConnections = %d after closing one, want 1", open)
+	}
+	if _, ok := cl.Open("key"); !ok {
+		t.Error("connection refused after a slot was freed")
+	}
+}
+
+// waitForConnections polls until key holds want connections, since the
+// server notices a client leaving asynchronously.
+func waitForConnections(t *testing.T, cl *ConnectionLimiter, key string, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for cl.OpenConnections(key) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("OpenConnections = %d, want %d", cl.OpenConnections(key), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnectionLimiterMiddlewareSSE(t *testing.T) {
+	cl := NewConnectionLimiter(2)
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: hello\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	server := httptest.NewServer(ConnectionLimiterMiddleware(stream, cl, APIKeyExtractor))
+	defer server.Close()
+
+	connect := func(ctx context.Context) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		req.Header.Set("X-API-KEY", "apikey123")
+		return server.Client().Do(req)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	streams := make([]*http.Response, 2)
+	for i := range streams {
+		wg.Go(func() {
+			resp, err := connect(ctx)
+			if err != nil {
+				t.Errorf("client %d: %v", i+1, err)
+				return
+			}
+			line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+			if !strings.HasPrefix(line, "data: hello") {
+				t.Errorf("client %d read %q, want the first event", i+1, line)
+			}
+			streams[i] = resp
+		})
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	waitForConnections(t, cl, "apikey123", 2)
+
+	resp, err := connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("third stream: status = %d, want 429", resp.StatusCode)
+	}
+
+	// Hanging up one stream frees its slot while the other stays open.
+	streams[0].Body.Close()
+	waitForConnections(t, cl, "apikey123", 1)
+
+	cancel()
+	streams[1].Body.Close()
+	waitForConnections(t, cl, "apikey123", 0)
+}
@@ -0,0 +1,50 @@
+package services
+
+import "context"
+
+// DryRunLimiter lets every request through while still charging the inner
+// limiter, so a new limit can be rolled out and its denials observed before
+// it is enforced. Errors from the inner limiter are not denials and are
+// returned unchanged.
+type DryRunLimiter struct {
+	inner Limiter
+	log   func(key string, wouldBeDenied bool, result Result)
+}
+
+// NewDryRunLimiter calls log, if not nil, with the inner Result for each
+// request the inner limiter would have denied.
+func NewDryRunLimiter(inner Limiter, log func(key string, wouldBeDenied bool, result Result)) *DryRunLimiter {
+	return &DryRunLimiter{inner: inner, log: log}
+}
+
+func (dl *DryRunLimiter) Allow(key string) (Result, error) {
+	return dl.AllowN(key, 1)
+}
+
+func (dl *DryRunLimiter) AllowN(key string, n int) (Result, error) {
+	return dl.AllowNContext(context.Background(), key, n)
+}
+
+func (dl *DryRunLimiter) AllowNContext(ctx context.Context, key string, n int) (Result, error) {
+	result, err := allowN(ctx, dl.inner, key, n)
+	if err != nil {
+		return result, err
+	}
+
+	if !result.Allowed && dl.log != nil {
+		dl.log(key, true, result)
+	}
+	return allowedAnyway(result), nil
+}
+
+func (dl *DryRunLimiter) Peek(key string) Result {
+	return allowedAnyway(dl.inner.Peek(key))
+}
+
+// allowedAnyway keeps the inner quota, which clients may already read from
+// the rate-limit headers, but drops everything that marks a denial.
+func allowedAnyway(result Result) Result {
+	result.Allowed = true
+	result.RetryAfter = 0
+	return result
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDryRunLimiter(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	var logged []int
+	call := 0
+	dl := NewDryRunLimiter(rl, func(key string, wouldBeDenied bool, result Result) {
+		if key != "key" || !wouldBeDenied || result.Allowed {
+			t.Errorf("log(%q, %v, %+v), want the inner denial of key", key, wouldBeDenied, result)
+		}
+		logged = append(logged, call)
+	})
+
+	for call = 1; call <= 10; call++ {
+		result, err := dl.Allow("key")
+		if err != nil || !result.Allowed || result.RetryAfter != 0 {
+			t.Errorf("call %d = %+v, %v; want allowed", call, result, err)
+		}
+	}
+
+	if want := []int{6, 7, 8, 9, 10}; !slices.Equal(logged, want) {
+		t.Errorf("log fired for calls %v, want %v", logged, want)
+	}
+	if stats := rl.Stats(); stats.TotalAllowed != 5 || stats.TotalDenied != 5 {
+		t.Errorf("inner Stats = %+v, want its own decisions counted", stats)
+	}
+	if peek := dl.Peek("key"); !peek.Allowed || peek.Remaining != 0 {
+		t.Errorf("Peek = %+v, want allowed with the inner quota", peek)
+	}
+
+	if _, err := dl.AllowN("key", 6); !errors.Is(err, ErrCostExceedsCapacity) {
+		t.Errorf("AllowN past capacity: err = %v, want the inner error", err)
+	}
+}
@@ -0,0 +1,204 @@
+//go:build dynamodb
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type DynamoDBOptions struct {
+	FailMode   FailMode
+	Logger     *slog.Logger
+	Registerer prometheus.Registerer
+}
+
+// DynamoDBLimiter is a fixed-window limiter whose counters live in a
+// DynamoDB table, for serverless deployments that keep no state between
+// invocations. The table needs a string partition key named "pk"; enable
+// TTL on the "expires" attribute so old windows are cleaned up.
+type DynamoDBLimiter struct {
+	client      *dynamodb.Client
+	table       string
+	maxLimit    int
+	window      time.Duration
+	keyPrefix   string
+	failMode    FailMode
+	logger      *slog.Logger
+	storeErrors prometheus.Counter
+}
+
+func NewDynamoDBLimiter(client *dynamodb.Client, table string, maxLimit int, windowDuration time.Duration, keyPrefix string) *DynamoDBLimiter {
+	return NewDynamoDBLimiterWithOptions(client, table, maxLimit, windowDuration, keyPrefix, DynamoDBOptions{})
+}
+
+func NewDynamoDBLimiterWithOptions(client *dynamodb.Client, table string, maxLimit int, windowDuration time.Duration, keyPrefix string, opts DynamoDBOptions) *DynamoDBLimiter {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	dl := &DynamoDBLimiter{
+		client:    client,
+		table:     table,
+		maxLimit:  maxLimit,
+		window:    windowDuration,
+		keyPrefix: keyPrefix,
+		failMode:  opts.FailMode,
+		logger:    opts.Logger,
+		storeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limiter_store_errors_total",
+			Help: "Rate limit checks that failed to reach the backing store.",
+		}),
+	}
+
+	if opts.Registerer != nil {
+		dl.storeErrors = registerCounter(opts.Registerer, dl.storeErrors)
+	}
+
+	return dl
+}
+
+func (dl *DynamoDBLimiter) Allow(apiKey string) (Result, error) {
+	return dl.AllowNContext(context.Background(), apiKey, 1)
+}
+
+func (dl *DynamoDBLimiter) AllowN(apiKey string, n int) (Result, error) {
+	return dl.AllowNContext(context.Background(), apiKey, n)
+}
+
+// AllowNContext adds n to the window counter only if the total stays within
+// the limit. The ADD and the condition are applied as one write, so
+// concurrent invocations can never push the counter past maxLimit.
+func (dl *DynamoDBLimiter) AllowNContext(ctx context.Context, apiKey string, n int) (Result, error) {
+	windowStart := time.Now().Truncate(dl.window)
+	resetAt := windowStart.Add(dl.window)
+	if n < 1 {
+		return Result{Limit: dl.maxLimit, Window: dl.window, ResetAt: resetAt}, errInvalidCost(n)
+	}
+	if n > dl.maxLimit {
+		return Result{Limit: dl.maxLimit, Window: dl.window, ResetAt: resetAt}, errCostExceedsLimit(n, dl.maxLimit)
+	}
+
+	output, err := dl.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(dl.table),
+		Key:                 dl.itemKey(apiKey, windowStart),
+		UpdateExpression:    aws.String("ADD #count :n SET #expires = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(#count) OR #count <= :last"),
+		ExpressionAttributeNames: map[string]string{
+			"#count":   "count",
+			"#expires": "expires",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":n":       numberValue(int64(n)),
+			":last":    numberValue(int64(dl.maxLimit - n)),
+			":expires": numberValue(resetAt.Add(dl.window).Unix()),
+		},
+		ReturnValues:                        types.ReturnValueUpdatedNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return Result{
+			Limit:      dl.maxLimit,
+			Window:     dl.window,
+			Remaining:  max(dl.maxLimit-itemCount(conditionFailed.Item), 0),
+			ResetAt:    resetAt,
+			RetryAfter: time.Until(resetAt),
+		}, nil
+	}
+	if err != nil {
+		return dl.fail(apiKey, resetAt, err)
+	}
+
+	return Result{
+		Allowed:   true,
+		Limit:     dl.maxLimit,
+		Window:    dl.window,
+		Remaining: max(dl.maxLimit-itemCount(output.Attributes), 0),
+		ResetAt:   resetAt,
+	}, nil
+}
+
+func (dl *DynamoDBLimiter) Peek(apiKey string) Result {
+	windowStart := time.Now().Truncate(dl.window)
+	resetAt := windowStart.Add(dl.window)
+
+	output, err := dl.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(dl.table),
+		Key:            dl.itemKey(apiKey, windowStart),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		result, _ := dl.fail(apiKey, resetAt, err)
+		return result
+	}
+
+	count := itemCount(output.Item)
+	result := Result{
+		Allowed:   count < dl.maxLimit,
+		Limit:     dl.maxLimit,
+		Window:    dl.window,
+		Remaining: max(dl.maxLimit-count, 0),
+		ResetAt:   resetAt,
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Until(resetAt)
+	}
+
+	return result
+}
+
+// HealthCheck describes the table, which also fails when it is missing or
+// the credentials cannot read it.
+func (dl *DynamoDBLimiter) HealthCheck(ctx context.Context) error {
+	_, err := dl.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(dl.table)})
+	return err
+}
+
+func (dl *DynamoDBLimiter) itemKey(apiKey string, windowStart time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: dl.keyPrefix + apiKey + keySeparator + strconv.FormatInt(windowStart.Unix(), 10)},
+	}
+}
+
+func (dl *DynamoDBLimiter) fail(apiKey string, resetAt time.Time, err error) (Result, error) {
+	dl.storeErrors.Inc()
+	dl.logger.Error("dynamodb rate limiter unavailable",
+		"key", apiKey,
+		"table", dl.table,
+		"fail_open", dl.failMode == FailOpen,
+		"error", err,
+	)
+
+	if dl.failMode == FailOpen {
+		return Result{Allowed: true, Limit: dl.maxLimit, Window: dl.window, Remaining: dl.maxLimit, ResetAt: time.Now()}, nil
+	}
+
+	return Result{Limit: dl.maxLimit, Window: dl.window, ResetAt: resetAt, RetryAfter: time.Until(resetAt)}, fmt.Errorf("dynamodb rate limiter: %w", err)
+}
+
+func numberValue(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// itemCount reads the counter from an item, treating a missing item or
+// attribute as an untouched window.
+func itemCount(item map[string]types.AttributeValue) int {
+	value, ok := item["count"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+
+	count, _ := strconv.Atoi(value.Value)
+	return count
+}
@@ -0,0 +1,68 @@
+//go:build dynamodb
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// newFakeDynamoDB serves DescribeTable for table and a
+// ResourceNotFoundException for any other.
+func newFakeDynamoDB(t *testing.T, table string) *dynamodb.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if r.Header.Get("X-Amz-Target") != "DynamoDB_20120810.DescribeTable" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazon.coral.service#UnknownOperationException"}`))
+			return
+		}
+
+		var body struct{ TableName string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.TableName != table {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`))
+			return
+		}
+		w.Write([]byte(`{"Table":{"TableName":"` + table + `","TableStatus":"ACTIVE"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return dynamodb.New(dynamodb.Options{
+		BaseEndpoint: aws.String(server.URL),
+		Region:       "us-east-1",
+		Credentials:  aws.AnonymousCredentials{},
+	})
+}
+
+func TestDynamoDBLimiterHealthCheck(t *testing.T) {
+	client := newFakeDynamoDB(t, "rate-limits")
+
+	tests := []struct {
+		name    string
+		table   string
+		healthy bool
+	}{
+		{name: "table exists", table: "rate-limits", healthy: true},
+		{name: "table missing", table: "other", healthy: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := NewDynamoDBLimiter(client, tt.table, 5, time.Minute, "health:")
+			err := dl.HealthCheck(context.Background())
+			if healthy := err == nil; healthy != tt.healthy {
+				t.Errorf("HealthCheck() = %v, want healthy %v", err, tt.healthy)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const eventBufferSize = 64
+
+type Event struct {
+	Key       string
+	Result    Result
+	RequestID string
+	Timestamp time.Time
+}
+
+type Bus interface {
+	Publish(event Event)
+	Subscribe() <-chan Event
+}
+
+// EventBus fans rate-limit events out to every subscriber from a single
+// goroutine. Publishing never blocks: an event is dropped, and counted in
+// rate_limiter_dropped_events_total, when the bus or a subscriber is full.
+type EventBus struct {
+	events      chan Event
+	subscribers []chan Event
+	mutex       sync.Mutex
+	dropped     prometheus.Counter
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func NewEventBus(reg prometheus.Registerer) *EventBus {
+	bus := &EventBus{
+		events: make(chan Event, eventBufferSize),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limiter_dropped_events_total",
+			Help: "Rate limit events dropped because a subscriber was not keeping up.",
+		}),
+		done: make(chan struct{}),
+	}
+
+	if reg != nil {
+		bus.dropped = registerCounter(reg, bus.dropped)
+	}
+
+	go bus.fanOut()
+	return bus
+}
+
+func (b *EventBus) Publish(event Event) {
+	select {
+	case <-b.done:
+	case b.events <- event:
+	default:
+		b.dropped.Inc()
+	}
+}
+
+func (b *EventBus) Subscribe() <-chan Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscriber := make(chan Event, eventBufferSize)
+	select {
+	case <-b.done:
+		close(subscriber)
+	default:
+		b.subscribers = append(b.subscribers, subscriber)
+	}
+
+	return subscriber
+}
+
+// Close stops the fan-out goroutine and closes every subscriber channel.
+// Events still queued are discarded.
+func (b *EventBus) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+func (b *EventBus) fanOut() {
+	for {
+		select {
+		case <-b.done:
+			b.mutex.Lock()
+			for _, subscriber := range b.subscribers {
+				close(subscriber)
+			}
+			b.subscribers = nil
+			b.mutex.Unlock()
+			return
+		case event := <-b.events:
+			b.mutex.Lock()
+			for _, subscriber := range b.subscribers {
+				select {
+				case subscriber <- event:
+				default:
+					b.dropped.Inc()
+				}
+			}
+			b.mutex.Unlock()
+		}
+	}
+}
+
+// NoOpEventBus discards every event. Its subscriptions never deliver.
+type NoOpEventBus struct{}
+
+func (NoOpEventBus) Publish(event Event) {}
+
+func (NoOpEventBus) Subscribe() <-chan Event {
+	return nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func receiveEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestEventBusTwoSubscribers(t *testing.T) {
+	bus := NewEventBus(nil)
+	defer bus.Close()
+	first, second := bus.Subscribe(), bus.Subscribe()
+
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	handler := NewRateLimiterMiddlewareWithOptions(okHandler, rl, APIKeyExtractor, Options{Events: bus})
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-KEY", "apikey123")
+		req.Header.Set("X-Request-ID", "req-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, events := range []<-chan Event{first, second} {
+		event := receiveEvent(t, events)
+		if event.Key != "apikey123" || event.RequestID != "req-1" || event.Result.Allowed || event.Timestamp.IsZero() {
+			t.Errorf("event = %+v, want the denial of req-1", event)
+		}
+		select {
+		case extra := <-events:
+			t.Errorf("allowed request published %+v without PublishAllowed", extra)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestEventBusPublishAllowed(t *testing.T) {
+	bus := NewEventBus(nil)
+	defer bus.Close()
+	events := bus.Subscribe()
+
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	handler := NewRateLimiterMiddlewareWithOptions(okHandler, rl, APIKeyExtractor, Options{Events: bus, PublishAllowed: true})
+	serve(handler, "apikey123")
+	serve(handler, "apikey123")
+
+	if event := receiveEvent(t, events); !event.Result.Allowed {
+		t.Errorf("first event = %+v, want the allowed request", event)
+	}
+	if event := receiveEvent(t, events); event.Result.Allowed {
+		t.Errorf("second event = %+v, want the denial", event)
+	}
+}
+
+func TestEventBusDropsForSlowSubscribers(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	bus := NewEventBus(reg)
+	defer bus.Close()
+	slow := bus.Subscribe()
+
+	const published = 4 * eventBufferSize
+	for range published {
+		bus.Publish(Event{Key: "key"})
+	}
+
+	// Whatever the bus does not drop ends up waiting in slow.
+	deadline := time.Now().Add(time.Second)
+	for len(slow)+int(testutil.ToFloat64(bus.dropped)) < published {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d delivered and %v dropped of %d", len(slow), testutil.ToFloat64(bus.dropped), published)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(slow) != eventBufferSize {
+		t.Errorf("slow subscriber holds %d events, want a full buffer of %d", len(slow), eventBufferSize)
+	}
+	if got := testutil.ToFloat64(bus.dropped); got == 0 {
+		t.Error("rate_limiter_dropped_events_total not incremented")
+	}
+	if count := testutil.CollectAndCount(reg, "rate_limiter_dropped_events_total"); count != 1 {
+		t.Errorf("dropped counter registered %d times, want 1", count)
+	}
+}
+
+func TestEventBusClose(t *testing.T) {
+	bus := NewEventBus(nil)
+	events := bus.Subscribe()
+	bus.Close()
+	bus.Close()
+
+	select {
+	case _, open := <-events:
+		if open {
+			t.Error("event delivered after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber channel not closed")
+	}
+	if _, open := <-bus.Subscribe(); open {
+		t.Error("Subscribe after Close returned an open channel")
+	}
+	bus.Publish(Event{Key: "key"})
+}
+
+func TestNoOpEventBus(t *testing.T) {
+	var bus Bus = NoOpEventBus{}
+	bus.Publish(Event{Key: "key"})
+	if events := bus.Subscribe(); events != nil {
+		t.Error("NoOpEventBus subscription is not nil")
+	}
+}
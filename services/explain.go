@@ -0,0 +1,75 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Explain describes, one fact per line, why key is or is not being limited:
+// its list membership, the limit in effect and where it comes from, and its
+// bucket's state with refill applied. It is for debugging; it takes the
+// limiter's lock, so keep it off hot paths.
+func (rl *RateLimiter) Explain(key string) string {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	apiKey := rl.hashKey(key)
+	bucket := rl.bucketKey(apiKey)
+	now := rl.clock.Now()
+	limit := rl.limitFor(bucket, now)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "key: %s\n", key)
+	if bucket != apiKey {
+		fmt.Fprintf(&b, "group: %s\n", bucket)
+	}
+
+	_, denied := rl.denylist[apiKey]
+	_, allowed := rl.allowlist[apiKey]
+	switch {
+	case denied:
+		b.WriteString("list: denylist, every request is rejected\n")
+	case allowed:
+		b.WriteString("list: allowlist, every request is admitted\n")
+	default:
+		b.WriteString("list: none\n")
+	}
+
+	fmt.Fprintf(&b, "limit: %d per %s, burst %d (%s)\n", limit.MaxLimit, limit.window(), limit.capacity(), rl.limitSource(bucket, now))
+
+	metadata, exists := rl.requests[bucket]
+	if !exists {
+		fmt.Fprintf(&b, "last seen: never\ntokens: %d/%d\n", limit.capacity(), limit.capacity())
+		return b.String()
+	}
+
+	current := *metadata
+	current.burst = limit.capacity()
+	rl.refill(&current, limit, now)
+
+	fmt.Fprintf(&b, "last seen: %s (%s ago)\n", metadata.lastSeen.Format(time.RFC3339), now.Sub(metadata.lastSeen).Round(time.Millisecond))
+	fmt.Fprintf(&b, "tokens: %d/%d\n", current.tokenCount, current.burst)
+	if current.tokenCount >= current.burst {
+		b.WriteString("next refill: none, bucket is full\n")
+	} else {
+		next := rl.refilledAt(&current, limit, 1)
+		fmt.Fprintf(&b, "next refill: +1 token in %s\n", max(next.Sub(now), 0).Round(time.Millisecond))
+	}
+
+	return b.String()
+}
+
+func (rl *RateLimiter) limitSource(bucket string, now time.Time) string {
+	source := "global"
+	if _, exists := rl.overrides[bucket]; exists {
+		source = "per-key override"
+	} else if cfg, exists := rl.keys[bucket]; exists && (cfg.MaxLimit > 0 || cfg.WindowSeconds > 0) {
+		source = "API key config"
+	}
+	if now.Sub(rl.started) < rl.warmup {
+		source += ", scaled for warmup"
+	}
+
+	return source
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterExplain(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 3, 60, LimiterOptions{})
+	rl.AllowN("spent", 3)
+	rl.Allow("spent")
+	clock.Advance(5 * time.Second)
+	rl.SetLimitConfig("override", LimitConfig{MaxLimit: 10, WindowSeconds: 10})
+	rl.Allowlist("vip")
+	rl.Denylist("banned")
+	rl.Group("team", "member")
+
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{key: "spent", want: []string{
+			"key: spent\n",
+			"list: none\n",
+			"limit: 3 per 1m0s, burst 3 (global)\n",
+			"(5s ago)\n",
+			"tokens: 0/3\n",
+			"next refill: +1 token in 15s\n",
+		}},
+		{key: "unseen", want: []string{"last seen: never\n", "tokens: 3/3\n"}},
+		{key: "override", want: []string{"limit: 10 per 10s, burst 10 (per-key override)\n"}},
+		{key: "vip", want: []string{"list: allowlist"}},
+		{key: "banned", want: []string{"list: denylist"}},
+		{key: "member", want: []string{"group: team\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			explanation := rl.Explain(tt.key)
+			for _, want := range tt.want {
+				if !strings.Contains(explanation, want) {
+					t.Errorf("Explain(%q) = %q, want it to contain %q", tt.key, explanation, want)
+				}
+			}
+		})
+	}
+}
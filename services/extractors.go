@@ -0,0 +1,302 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type KeyExtractor func(*http.Request) (string, error)
+
+var (
+	ErrMissingAPIKey     = errors.New("missing API key")
+	ErrInvalidAPIKey     = errors.New("invalid API key")
+	ErrInvalidRemoteAddr = errors.New("invalid remote address")
+	ErrUntrustedForward  = errors.New("X-Forwarded-For has fewer entries than the trust depth")
+	ErrMissingBearer     = errors.New("missing bearer token")
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrMissingClaim      = errors.New("missing claim")
+	ErrMissingSegment    = errors.New("missing path segment")
+	ErrMissingRouteVar   = errors.New("missing route variable")
+	ErrMissingQueryParam = errors.New("missing query parameter")
+	ErrMissingHeader     = errors.New("missing header")
+)
+
+const keySeparator = ":"
+
+func APIKeyExtractor(r *http.Request) (string, error) {
+	apiKey := r.Header.Get("X-API-KEY")
+	if apiKey == "" {
+		return "", ErrMissingAPIKey
+	}
+
+	if !isValidApiKey(apiKey) {
+		return "", ErrInvalidAPIKey
+	}
+
+	return apiKey, nil
+}
+
+func RemoteIPExtractor(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", ErrInvalidRemoteAddr
+	}
+
+	return ip.String(), nil
+}
+
+// SubnetExtractor keys on the network of the remote address, so every host
+// in e.g. a /24 shares one bucket: with maskBits 24, 192.168.1.42 and
+// 192.168.1.100 both map to 192.168.1.0. The same prefix length applies to
+// IPv4 and IPv6 addresses, capped at each family's width.
+func SubnetExtractor(maskBits int) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		host, err := RemoteIPExtractor(r)
+		if err != nil {
+			return "", err
+		}
+
+		ip := net.ParseIP(host)
+		bits := 8 * net.IPv6len
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, 8*net.IPv4len
+		}
+
+		return ip.Mask(net.CIDRMask(min(max(maskBits, 0), bits), bits)).String(), nil
+	}
+}
+
+// ForwardedIPExtractor keys on the client address recorded by the trusted
+// proxies in front of the service. trustDepth is the number of those proxies:
+// each appends the address it received the connection from, so the client is
+// the entry trustDepth positions from the end of X-Forwarded-For. Requests
+// without the header, or with trustDepth <= 0, fall back to RemoteAddr.
+func ForwardedIPExtractor(trustDepth int) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		header := r.Header.Values("X-Forwarded-For")
+		if trustDepth <= 0 || len(header) == 0 {
+			return RemoteIPExtractor(r)
+		}
+
+		var hops []string
+		for _, value := range header {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+
+		if len(hops) < trustDepth {
+			return "", ErrUntrustedForward
+		}
+
+		ip := net.ParseIP(hops[len(hops)-trustDepth])
+		if ip == nil {
+			return "", ErrInvalidRemoteAddr
+		}
+
+		return ip.String(), nil
+	}
+}
+
+// JWTClaimExtractor keys on a claim of the bearer token. Token validation is
+// left to parser so callers can use whichever JWT library they already do.
+func JWTClaimExtractor(claimName string, parser func(tokenString string) (jwt.MapClaims, error)) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || strings.TrimSpace(token) == "" {
+			return "", ErrMissingBearer
+		}
+
+		claims, err := parser(strings.TrimSpace(token))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+
+		value, exists := claims[claimName]
+		if !exists || value == nil {
+			return "", fmt.Errorf("%w %q", ErrMissingClaim, claimName)
+		}
+
+		key := fmt.Sprint(value)
+		if key == "" {
+			return "", fmt.Errorf("%w %q", ErrMissingClaim, claimName)
+		}
+
+		return key, nil
+	}
+}
+
+// PathSegmentExtractor keys on the segmentIndex-th segment of the URL path,
+// counting from zero after the leading slash, so index 1 of /orgs/acme/users
+// is "acme". Segments are split before unescaping so an encoded slash stays
+// part of its segment.
+func PathSegmentExtractor(segmentIndex int) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+		if segmentIndex < 0 || segmentIndex >= len(segments) {
+			return "", fmt.Errorf("%w %d", ErrMissingSegment, segmentIndex)
+		}
+
+		segment, err := url.PathUnescape(segments[segmentIndex])
+		if err != nil {
+			return "", fmt.Errorf("%w %d: %v", ErrMissingSegment, segmentIndex, err)
+		}
+		if segment == "" {
+			return "", fmt.Errorf("%w %d", ErrMissingSegment, segmentIndex)
+		}
+
+		return segment, nil
+	}
+}
+
+// CombinedExtractor joins the keys of every extractor with ":", producing
+// composite keys such as orgID:userID. The first extractor error is returned.
+func CombinedExtractor(extractors ...KeyExtractor) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		parts := make([]string, 0, len(extractors))
+		for _, extractor := range extractors {
+			part, err := extractor(r)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+
+		return strings.Join(parts, keySeparator), nil
+	}
+}
+
+// MethodScopedExtractor gives each HTTP method its own bucket by appending
+// the method to the inner key, e.g. apikey123:POST. Per-method limits are
+// then set on that key, as in SetLimit("apikey123:POST", 10, 60).
+func MethodScopedExtractor(inner KeyExtractor) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		key, err := inner(r)
+		if err != nil {
+			return "", err
+		}
+
+		return key + keySeparator + r.Method, nil
+	}
+}
+
+// CompositeKeyExtractor joins the keys of every extractor, in the order
+// given, with separator (":" when empty). Unlike CombinedExtractor it
+// percent-encodes the separator inside each part, so a path containing it
+// cannot make two different combinations share a bucket. For example
+// CompositeKeyExtractor("", APIKeyExtractor, MethodExtractor, PathExtractor)
+// keys a GET of /search as apikey123:GET:/search.
+func CompositeKeyExtractor(separator string, extractors ...KeyExtractor) KeyExtractor {
+	if separator == "" {
+		separator = keySeparator
+	}
+	escaper := strings.NewReplacer("%", "%25", separator, percentEncode(separator))
+
+	return func(r *http.Request) (string, error) {
+		parts := make([]string, 0, len(extractors))
+		for _, extractor := range extractors {
+			part, err := extractor(r)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, escaper.Replace(part))
+		}
+
+		return strings.Join(parts, separator), nil
+	}
+}
+
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&b, "%%%02X", s[i])
+	}
+
+	return b.String()
+}
+
+func MethodExtractor(r *http.Request) (string, error) {
+	return r.Method, nil
+}
+
+// PathExtractor keys on the request path as sent, without the query.
+func PathExtractor(r *http.Request) (string, error) {
+	return r.URL.EscapedPath(), nil
+}
+
+func QueryParamExtractor(name string) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return "", fmt.Errorf("%w %q", ErrMissingQueryParam, name)
+		}
+
+		return value, nil
+	}
+}
+
+// HeaderExtractor keys on a request header such as X-Tenant-ID. Combine it
+// with CompositeKeyExtractor to limit each API key within a tenant.
+func HeaderExtractor(headerName string) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(headerName)
+		if value == "" {
+			return "", fmt.Errorf("%w %q", ErrMissingHeader, headerName)
+		}
+
+		return value, nil
+	}
+}
+
+// unknownCountry is the code Cloudflare sends when it cannot place an IP.
+const unknownCountry = "XX"
+
+// GeoKeyExtractor appends the country code from countryHeader, such as
+// Cloudflare's CF-IPCountry, to the inner key: apikey123:US. Per-country
+// limits are then set on those keys. Requests without the header, or with
+// anything but a two-letter code, are grouped under XX.
+//
+// The header must be set by a proxy that strips any value the client sent.
+// Otherwise a client can pick its own country, and a fresh bucket with it.
+func GeoKeyExtractor(countryHeader string, inner KeyExtractor) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		key, err := inner(r)
+		if err != nil {
+			return "", err
+		}
+
+		country := strings.ToUpper(strings.TrimSpace(r.Header.Get(countryHeader)))
+		if !isCountryCode(country) {
+			country = unknownCountry
+		}
+
+		return key + keySeparator + country, nil
+	}
+}
+
+// isCountryCode reports whether code has the shape of an ISO 3166-1 alpha-2
+// code. It limits a spoofed header to 676 buckets per key rather than
+// one per value; only a proxy that sets the header closes the gap.
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for i := range len(code) {
+		if code[i] < 'A' || code[i] > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,432 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTClaimExtractor(t *testing.T) {
+	errBadSignature := errors.New("bad signature")
+	parsers := map[string]func(string) (jwt.MapClaims, error){
+		"valid": func(string) (jwt.MapClaims, error) {
+			return jwt.MapClaims{"sub": "user-42", "plan": "pro", "empty": "", "null": nil}, nil
+		},
+		"invalid": func(string) (jwt.MapClaims, error) {
+			return nil, errBadSignature
+		},
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		parser        string
+		claim         string
+		want          string
+		wantErr       error
+	}{
+		{name: "subject", authorization: "Bearer token", parser: "valid", claim: "sub", want: "user-42"},
+		{name: "custom claim", authorization: "Bearer token", parser: "valid", claim: "plan", want: "pro"},
+		{name: "padded token", authorization: "Bearer  token ", parser: "valid", claim: "sub", want: "user-42"},
+		{name: "no header", parser: "valid", claim: "sub", wantErr: ErrMissingBearer},
+		{name: "basic auth", authorization: "Basic dXNlcjpwYXNz", parser: "valid", claim: "sub", wantErr: ErrMissingBearer},
+		{name: "empty bearer", authorization: "Bearer  ", parser: "valid", claim: "sub", wantErr: ErrMissingBearer},
+		{name: "invalid token", authorization: "Bearer token", parser: "invalid", claim: "sub", wantErr: ErrInvalidToken},
+		{name: "missing claim", authorization: "Bearer token", parser: "valid", claim: "org", wantErr: ErrMissingClaim},
+		{name: "empty claim", authorization: "Bearer token", parser: "valid", claim: "empty", wantErr: ErrMissingClaim},
+		{name: "null claim", authorization: "Bearer token", parser: "valid", claim: "null", wantErr: ErrMissingClaim},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			got, err := JWTClaimExtractor(tt.claim, parsers[tt.parser])(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathSegmentExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		index   int
+		want    string
+		wantErr error
+	}{
+		{name: "org", path: "/orgs/acme/users", index: 1, want: "acme"},
+		{name: "first segment", path: "/orgs/acme/users", index: 0, want: "orgs"},
+		{name: "last segment", path: "/orgs/acme/users", index: 2, want: "users"},
+		{name: "path too short", path: "/orgs", index: 1, wantErr: ErrMissingSegment},
+		{name: "negative index", path: "/orgs/acme", index: -1, wantErr: ErrMissingSegment},
+		{name: "empty segment", path: "/orgs//users", index: 1, wantErr: ErrMissingSegment},
+		{name: "trailing slash", path: "/orgs/", index: 1, wantErr: ErrMissingSegment},
+		{name: "escaped space", path: "/orgs/acme%20corp/users", index: 1, want: "acme corp"},
+		{name: "escaped slash stays in its segment", path: "/orgs/a%2Fb/users", index: 1, want: "a/b"},
+		{name: "escaped slash does not shift segments", path: "/orgs/a%2Fb/users", index: 2, want: "users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+
+			got, err := PathSegmentExtractor(tt.index)(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinedExtractor(t *testing.T) {
+	user := HeaderExtractor("X-User")
+	tests := []struct {
+		name    string
+		path    string
+		user    string
+		want    string
+		wantErr error
+	}{
+		{name: "org and user", path: "/orgs/acme/items", user: "alice", want: "acme:alice"},
+		{name: "missing org", path: "/orgs", user: "alice", wantErr: ErrMissingSegment},
+		{name: "missing user", path: "/orgs/acme/items", wantErr: ErrMissingHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.user != "" {
+				req.Header.Set("X-User", tt.user)
+			}
+
+			got, err := CombinedExtractor(PathSegmentExtractor(1), user)(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// orgAndUserLimiter charges the org's bucket and the user's bucket for
+// keys of the form org:user.
+type orgAndUserLimiter struct {
+	perOrg, perUser Limiter
+}
+
+func (l orgAndUserLimiter) Allow(key string) (Result, error) {
+	return l.AllowN(key, 1)
+}
+
+func (l orgAndUserLimiter) AllowN(key string, n int) (Result, error) {
+	org, _, _ := strings.Cut(key, keySeparator)
+	return NewChainLimiter(keyedLimiter{l.perUser, ""}, keyedLimiter{l.perOrg, org}).AllowN(key, n)
+}
+
+func (l orgAndUserLimiter) Peek(key string) Result {
+	return l.perUser.Peek(key)
+}
+
+// keyedLimiter sends every key to inner as fixed, or unchanged when
+// fixed is empty.
+type keyedLimiter struct {
+	inner Limiter
+	fixed string
+}
+
+func (p keyedLimiter) key(key string) string {
+	if p.fixed != "" {
+		return p.fixed
+	}
+	return key
+}
+
+func (p keyedLimiter) Allow(key string) (Result, error) {
+	return p.inner.Allow(p.key(key))
+}
+
+func (p keyedLimiter) AllowN(key string, n int) (Result, error) {
+	return p.inner.AllowN(p.key(key), n)
+}
+
+func (p keyedLimiter) Peek(key string) Result {
+	return p.inner.Peek(p.key(key))
+}
+
+func TestPerOrgAndPerUserLimits(t *testing.T) {
+	perOrg, _ := newTestRateLimiter(t, 3, 60, LimiterOptions{})
+	perUser, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	handler := NewRateLimiterMiddleware(okHandler, orgAndUserLimiter{perOrg: perOrg, perUser: perUser},
+		CombinedExtractor(PathSegmentExtractor(1), HeaderExtractor("X-User")))
+
+	steps := []struct {
+		user     string
+		wantCode int
+	}{
+		{"alice", http.StatusOK},
+		{"alice", http.StatusOK},
+		// alice's own limit.
+		{"alice", http.StatusTooManyRequests},
+		{"bob", http.StatusOK},
+		// acme's limit, shared by alice and bob.
+		{"bob", http.StatusTooManyRequests},
+	}
+
+	for i, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, "/orgs/acme/items", nil)
+		req.Header.Set("X-User", step.user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != step.wantCode {
+			t.Errorf("request %d from %s: status = %d, want %d", i+1, step.user, rec.Code, step.wantCode)
+		}
+	}
+}
+
+func TestMethodScopedExtractor(t *testing.T) {
+	tests := []struct {
+		name         string
+		extractor    KeyExtractor
+		wantPostCode int
+	}{
+		{name: "method scoped", extractor: MethodScopedExtractor(APIKeyExtractor), wantPostCode: http.StatusOK},
+		{name: "plain", extractor: APIKeyExtractor, wantPostCode: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddleware(okHandler, rl, tt.extractor)
+			send := func(method string) int {
+				req := httptest.NewRequest(method, "/hello", nil)
+				req.Header.Set("X-API-KEY", "apikey123")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			send(http.MethodGet)
+			send(http.MethodGet)
+			if code := send(http.MethodGet); code != http.StatusTooManyRequests {
+				t.Fatalf("third GET: status = %d, want 429", code)
+			}
+			if code := send(http.MethodPost); code != tt.wantPostCode {
+				t.Errorf("POST after GETs: status = %d, want %d", code, tt.wantPostCode)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.Header.Set("X-API-KEY", "apikey123")
+	if key, err := MethodScopedExtractor(APIKeyExtractor)(req); err != nil || key != "apikey123:DELETE" {
+		t.Errorf("key = %q, %v; want apikey123:DELETE", key, err)
+	}
+	if _, err := MethodScopedExtractor(APIKeyExtractor)(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("err = %v, want the inner extractor's error", err)
+	}
+}
+
+func TestSubnetExtractor(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		maskBits   int
+		want       string
+	}{
+		{name: "ipv4 /24", remoteAddr: "192.168.1.42:1234", maskBits: 24, want: "192.168.1.0"},
+		{name: "ipv4 /16", remoteAddr: "192.168.1.42:1234", maskBits: 16, want: "192.168.0.0"},
+		{name: "ipv4 /32", remoteAddr: "192.168.1.42:1234", maskBits: 32, want: "192.168.1.42"},
+		{name: "ipv4 mask capped", remoteAddr: "192.168.1.42:1234", maskBits: 48, want: "192.168.1.42"},
+		{name: "ipv6 /48", remoteAddr: "[2001:db8:abcd:12::1]:1234", maskBits: 48, want: "2001:db8:abcd::"},
+		{name: "ipv6 /128", remoteAddr: "[2001:db8::1]:1234", maskBits: 128, want: "2001:db8::1"},
+		{name: "negative mask", remoteAddr: "192.168.1.42:1234", maskBits: -8, want: "0.0.0.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			got, err := SubnetExtractor(tt.maskBits)(req)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubnetExtractorSharesQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		maskBits int
+		same     []string
+		other    string
+	}{
+		{
+			name:     "ipv4 /24",
+			maskBits: 24,
+			same:     []string{"192.168.1.42:1000", "192.168.1.100:2000"},
+			other:    "192.168.2.42:1000",
+		},
+		{
+			name:     "ipv6 /48",
+			maskBits: 48,
+			same:     []string{"[2001:db8:abcd:1::1]:1000", "[2001:db8:abcd:ffff::2]:2000"},
+			other:    "[2001:db8:abce::1]:1000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+			handler := NewRateLimiterMiddleware(okHandler, rl, SubnetExtractor(tt.maskBits))
+			send := func(remoteAddr string) int {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = remoteAddr
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			for _, addr := range tt.same {
+				if code := send(addr); code != http.StatusOK {
+					t.Fatalf("%s: status = %d, want 200", addr, code)
+				}
+			}
+			if code := send(tt.same[0]); code != http.StatusTooManyRequests {
+				t.Errorf("third request from the subnet: status = %d, want 429", code)
+			}
+			if code := send(tt.other); code != http.StatusOK {
+				t.Errorf("%s in another subnet: status = %d, want 200", tt.other, code)
+			}
+		})
+	}
+}
+
+func TestCompositeKeyExtractor(t *testing.T) {
+	all := []KeyExtractor{APIKeyExtractor, MethodExtractor, PathExtractor, QueryParamExtractor("tenant")}
+
+	tests := []struct {
+		name      string
+		separator string
+		method    string
+		target    string
+		apiKey    string
+		want      string
+		wantErr   error
+	}{
+		{name: "all four", method: http.MethodGet, target: "/search?tenant=acme", apiKey: "apikey123", want: "apikey123:GET:/search:acme"},
+		{name: "method changes the key", method: http.MethodPost, target: "/search?tenant=acme", apiKey: "apikey123", want: "apikey123:POST:/search:acme"},
+		{name: "separator in a part is escaped", method: http.MethodGet, target: "/a:b?tenant=x%3Ay", apiKey: "apikey123", want: "apikey123:GET:/a%3Ab:x%3Ay"},
+		{name: "escape character is escaped", method: http.MethodGet, target: "/search?tenant=100%25", apiKey: "apikey123", want: "apikey123:GET:/search:100%25"},
+		{name: "custom separator", separator: "|", method: http.MethodGet, target: "/a:b?tenant=x%7Cy", apiKey: "apikey123", want: "apikey123|GET|/a:b|x%7Cy"},
+		{name: "missing api key", method: http.MethodGet, target: "/search?tenant=acme", wantErr: ErrMissingAPIKey},
+		{name: "missing query param", method: http.MethodGet, target: "/search", apiKey: "apikey123", wantErr: ErrMissingQueryParam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-KEY", tt.apiKey)
+			}
+
+			got, err := CompositeKeyExtractor(tt.separator, all...)(req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompositeKeyExtractorPerMethodLimits(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 100, 60, LimiterOptions{})
+	rl.SetLimitConfig("apikey123:POST:/search", LimitConfig{MaxLimit: 1, WindowSeconds: 60})
+	handler := NewRateLimiterMiddleware(okHandler, rl, CompositeKeyExtractor("", APIKeyExtractor, MethodExtractor, PathExtractor))
+	send := func(method string) int {
+		req := httptest.NewRequest(method, "/search", nil)
+		req.Header.Set("X-API-KEY", "apikey123")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	steps := []struct {
+		method   string
+		wantCode int
+	}{
+		{http.MethodPost, http.StatusOK},
+		{http.MethodPost, http.StatusTooManyRequests},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodGet, http.StatusOK},
+	}
+	for i, step := range steps {
+		if code := send(step.method); code != step.wantCode {
+			t.Errorf("request %d (%s): status = %d, want %d", i+1, step.method, code, step.wantCode)
+		}
+	}
+}
+
+func TestGeoKeyExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		country string
+		want    string
+	}{
+		{name: "country header", country: "DE", want: "apikey123:DE"},
+		{name: "lower case", country: " de ", want: "apikey123:DE"},
+		{name: "missing header", want: "apikey123:XX"},
+		{name: "cloudflare unknown", country: "XX", want: "apikey123:XX"},
+		{name: "tor", country: "T1", want: "apikey123:XX"},
+		{name: "not a code", country: "Germany", want: "apikey123:XX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-KEY", "apikey123")
+			if tt.country != "" {
+				req.Header.Set("CF-IPCountry", tt.country)
+			}
+
+			got, err := GeoKeyExtractor("CF-IPCountry", APIKeyExtractor)(req)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("CF-IPCountry", "DE")
+	if _, err := GeoKeyExtractor("CF-IPCountry", APIKeyExtractor)(req); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("err = %v, want the inner extractor's error", err)
+	}
+}
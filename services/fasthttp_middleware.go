@@ -0,0 +1,41 @@
+//go:build fasthttp
+
+package services
+
+import (
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+func FastHTTPAPIKeyExtractor(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Request.Header.Peek("X-API-KEY"))
+}
+
+// FastHTTPMiddleware is RateLimiterMiddleware for fasthttp handlers. It
+// sends the same X-RateLimit-* and Retry-After headers.
+func FastHTTPMiddleware(next fasthttp.RequestHandler, limiter Limiter, extractor func(*fasthttp.RequestCtx) string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		key := extractor(ctx)
+		if key == "" {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			ctx.SetBodyString("Missing API key")
+			return
+		}
+
+		result, err := allowN(ctx, limiter, key, 1)
+		header := &ctx.Response.Header
+		header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+
+		if err != nil || !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(retryAfterSeconds(result)))
+			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+			ctx.SetBodyString("Rate limit exceeded")
+			return
+		}
+
+		next(ctx)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+type FixedWindowLimiter struct {
+	windows        map[string]*windowCounter
+	mutex          sync.Mutex
+	maxLimit       int
+	windowDuration time.Duration
+	currentWindow  time.Time
+}
+
+type windowCounter struct {
+	windowStart time.Time
+	count       int
+}
+
+func NewFixedWindowLimiter(maxLimit int, windowDuration time.Duration) *FixedWindowLimiter {
+	return &FixedWindowLimiter{
+		windows:        make(map[string]*windowCounter),
+		maxLimit:       maxLimit,
+		windowDuration: windowDuration,
+	}
+}
+
+func (fw *FixedWindowLimiter) Allow(apiKey string) (Result, error) {
+	return fw.AllowN(apiKey, 1)
+}
+
+func (fw *FixedWindowLimiter) AllowN(apiKey string, n int) (Result, error) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	windowStart := time.Now().Truncate(fw.windowDuration)
+	if n < 1 {
+		return Result{Limit: fw.maxLimit, Window: fw.windowDuration, ResetAt: windowStart.Add(fw.windowDuration)}, errInvalidCost(n)
+	}
+	if n > fw.maxLimit {
+		return Result{Limit: fw.maxLimit, Window: fw.windowDuration, ResetAt: windowStart.Add(fw.windowDuration)}, errCostExceedsLimit(n, fw.maxLimit)
+	}
+
+	if windowStart.After(fw.currentWindow) {
+		fw.collectExpired(windowStart)
+		fw.currentWindow = windowStart
+	}
+
+	key := windowKey(apiKey, windowStart)
+	counter, exists := fw.windows[key]
+	if !exists {
+		counter = &windowCounter{windowStart: windowStart}
+		fw.windows[key] = counter
+	}
+
+	allowed := counter.count+n <= fw.maxLimit
+	if allowed {
+		counter.count += n
+	}
+
+	return fw.result(allowed, counter.count, windowStart), nil
+}
+
+func (fw *FixedWindowLimiter) Peek(apiKey string) Result {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	windowStart := time.Now().Truncate(fw.windowDuration)
+	count := 0
+	if counter, exists := fw.windows[windowKey(apiKey, windowStart)]; exists {
+		count = counter.count
+	}
+
+	return fw.result(count < fw.maxLimit, count, windowStart)
+}
+
+func (fw *FixedWindowLimiter) result(allowed bool, count int, windowStart time.Time) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     fw.maxLimit,
+		Window:    fw.windowDuration,
+		Remaining: fw.maxLimit - count,
+		ResetAt:   windowStart.Add(fw.windowDuration),
+	}
+	if !allowed {
+		result.RetryAfter = time.Until(result.ResetAt)
+	}
+
+	return result
+}
+
+func windowKey(apiKey string, windowStart time.Time) string {
+	return apiKey + ":" + strconv.FormatInt(windowStart.UnixNano(), 10)
+}
+
+func (fw *FixedWindowLimiter) collectExpired(windowStart time.Time) {
+	for windowKey, counter := range fw.windows {
+		if counter.windowStart.Before(windowStart) {
+			delete(fw.windows, windowKey)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func FuzzAllow(f *testing.F) {
+	f.Add("", 1)
+	f.Add("apikey123", 1)
+	f.Add("clé-🔑-ключ", 3)
+	f.Add(strings.Repeat("k", 1<<20), 1)
+	f.Add("key\r\nSet-Cookie: session=injected", 2)
+	f.Add("key\x00null", 0)
+	f.Add("key", -1)
+	f.Add("key", 6)
+
+	f.Fuzz(func(t *testing.T, key string, n int) {
+		rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+
+		checkResult := func(call string, result Result) {
+			if result.Remaining < 0 || result.Remaining > 5 {
+				t.Errorf("%s: Remaining = %d, want 0 to 5", call, result.Remaining)
+			}
+		}
+
+		result, err := rl.Allow(key)
+		if err != nil || !result.Allowed {
+			t.Fatalf("Allow(%q) on a fresh key = %+v, %v", key, result, err)
+		}
+		checkResult("Allow", result)
+
+		result, err = rl.AllowN(key, n)
+		switch {
+		case n < 1:
+			if !errors.Is(err, ErrInvalidCost) {
+				t.Errorf("AllowN(%d): err = %v, want ErrInvalidCost", n, err)
+			}
+		case n > 5:
+			if !errors.Is(err, ErrCostExceedsCapacity) {
+				t.Errorf("AllowN(%d): err = %v, want ErrCostExceedsCapacity", n, err)
+			}
+		case err != nil:
+			t.Errorf("AllowN(%d): %v", n, err)
+		case result.Allowed != (n <= 4):
+			t.Errorf("AllowN(%d) with 4 tokens left: Allowed = %v", n, result.Allowed)
+		}
+		if err != nil && result.Allowed {
+			t.Errorf("AllowN(%d) failed with %v but was allowed", n, err)
+		}
+		checkResult("AllowN", result)
+		checkResult("Peek", rl.Peek(key))
+
+		handler := NewRateLimiterMiddleware(okHandler, rl, HeaderExtractor("X-Client"))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header["X-Client"] = []string{key}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		for name, values := range rec.Header() {
+			switch name {
+			case "Content-Type", "X-Content-Type-Options", "Retry-After",
+				"X-Ratelimit-Limit", "X-Ratelimit-Remaining", "X-Ratelimit-Reset":
+			default:
+				t.Errorf("unexpected response header %q", name)
+			}
+			for _, value := range values {
+				if strings.ContainsAny(value, "\r\n") {
+					t.Errorf("header %s = %q breaks the header block", name, value)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// GCRALimiter tracks a single theoretical arrival time (TAT) per key: the
+// moment the key's bucket would be empty if requests kept arriving at
+// exactly the allowed rate. A request is allowed while it does not push the
+// TAT further than burst emission intervals ahead of now.
+type GCRALimiter struct {
+	tats             map[string]time.Time
+	mutex            sync.Mutex
+	emissionInterval time.Duration
+	delayTolerance   time.Duration
+	burst            int
+	nextSweep        time.Time
+}
+
+// NewGCRALimiter allows rate requests per period, up to burst at once; a
+// burst of 0 is taken as 1. It panics unless rate and period are positive,
+// burst is not negative, and period is at least rate nanoseconds, since a
+// zero emission interval would divide by zero on the first request.
+func NewGCRALimiter(rate int, period time.Duration, burst int) *GCRALimiter {
+	if rate <= 0 || period <= 0 {
+		panic(fmt.Sprintf("gcra limiter needs a positive rate and period, got %d per %s", rate, period))
+	}
+	if burst < 0 {
+		panic(fmt.Sprintf("gcra limiter burst must not be negative, got %d", burst))
+	}
+	emissionInterval := period / time.Duration(rate)
+	if emissionInterval <= 0 {
+		panic(fmt.Sprintf("gcra limiter rate of %d per %s is below one nanosecond per request", rate, period))
+	}
+	burst = max(burst, 1)
+
+	return &GCRALimiter{
+		tats:             make(map[string]time.Time),
+		emissionInterval: emissionInterval,
+		delayTolerance:   emissionInterval * time.Duration(burst),
+		burst:            burst,
+	}
+}
+
+func (g *GCRALimiter) Allow(apiKey string) (Result, error) {
+	return g.AllowN(apiKey, 1)
+}
+
+func (g *GCRALimiter) AllowN(apiKey string, n int) (Result, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	if !now.Before(g.nextSweep) {
+		g.sweep(now)
+	}
+
+	tat := g.tats[apiKey]
+	if tat.Before(now) {
+		tat = now
+	}
+
+	if n < 1 {
+		return g.result(false, now, tat), errInvalidCost(n)
+	}
+	if n > g.burst {
+		return g.result(false, now, tat), errCostExceedsLimit(n, g.burst)
+	}
+
+	newTat := tat.Add(g.emissionInterval * time.Duration(n))
+	allowAt := newTat.Add(-g.delayTolerance)
+	allowed := !now.Before(allowAt)
+	if allowed {
+		tat = newTat
+		g.tats[apiKey] = tat
+	}
+
+	result := g.result(allowed, now, tat)
+	if !allowed {
+		result.RetryAfter = allowAt.Sub(now)
+	}
+
+	return result, nil
+}
+
+func (g *GCRALimiter) Peek(apiKey string) Result {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	tat := g.tats[apiKey]
+	if tat.Before(now) {
+		tat = now
+	}
+
+	allowAt := tat.Add(g.emissionInterval - g.delayTolerance)
+	allowed := !now.Before(allowAt)
+	result := g.result(allowed, now, tat)
+	if !allowed {
+		result.RetryAfter = allowAt.Sub(now)
+	}
+
+	return result
+}
+
+// sweep forgets TATs that are not ahead of now: those keys are back to a
+// full burst, as if never seen. No TAT is more than delayTolerance ahead,
+// so sweeping that often keeps only keys active within the last interval.
+func (g *GCRALimiter) sweep(now time.Time) {
+	for apiKey, tat := range g.tats {
+		if !tat.After(now) {
+			delete(g.tats, apiKey)
+		}
+	}
+	g.nextSweep = now.Add(max(g.delayTolerance, time.Second))
+}
+
+func (g *GCRALimiter) result(allowed bool, now, tat time.Time) Result {
+	return Result{
+		Allowed:   allowed,
+		Limit:     g.burst,
+		Window:    g.delayTolerance,
+		Remaining: int((g.delayTolerance - tat.Sub(now)) / g.emissionInterval),
+		ResetAt:   tat,
+	}
+}
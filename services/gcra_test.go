@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGCRALimiter(t *testing.T) {
+	g := NewGCRALimiter(10, time.Second, 3)
+
+	for i := range 3 {
+		result, err := g.Allow("key")
+		if err != nil || !result.Allowed {
+			t.Fatalf("request %d: %+v, %v", i+1, result, err)
+		}
+		if result.Limit != 3 {
+			t.Errorf("request %d: Limit = %d, want the burst", i+1, result.Limit)
+		}
+	}
+
+	result, _ := g.Allow("key")
+	if result.Allowed {
+		t.Fatal("request past the burst allowed")
+	}
+	if result.Remaining != 0 {
+		t.Errorf("Remaining = %d, want 0", result.Remaining)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 100*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want at most one emission interval", result.RetryAfter)
+	}
+	if peek := g.Peek("key"); peek.Allowed {
+		t.Error("Peek allowed a key past its burst")
+	}
+
+	time.Sleep(result.RetryAfter)
+	if result, _ := g.Allow("key"); !result.Allowed {
+		t.Error("request one emission interval later denied")
+	}
+}
+
+func TestGCRALimiterAllowN(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		wantAllowed bool
+		wantErr     error
+	}{
+		{name: "whole burst", n: 4, wantAllowed: true},
+		{name: "zero", n: 0, wantErr: ErrInvalidCost},
+		{name: "past the burst", n: 5, wantErr: ErrCostExceedsCapacity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGCRALimiter(1, time.Minute, 4)
+
+			result, err := g.AllowN("key", tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if want := 4 - tt.n; tt.wantAllowed && result.Remaining != want {
+				t.Errorf("Remaining = %d, want %d", result.Remaining, want)
+			}
+		})
+	}
+}
+
+func TestGCRALimiterSweep(t *testing.T) {
+	g := NewGCRALimiter(1, time.Second, 2)
+	now := time.Now()
+	g.tats["idle"] = now.Add(-time.Second)
+	g.tats["due"] = now
+	g.tats["active"] = now.Add(time.Second)
+
+	g.sweep(now)
+
+	if _, kept := g.tats["active"]; !kept || len(g.tats) != 1 {
+		t.Errorf("after sweep: %v, want only the active key", g.tats)
+	}
+	if want := now.Add(2 * time.Second); !g.nextSweep.Equal(want) {
+		t.Errorf("nextSweep = %v, want %v", g.nextSweep, want)
+	}
+}
+
+func TestNewGCRALimiterRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   int
+		period time.Duration
+		burst  int
+	}{
+		{"zero rate", 0, time.Second, 1},
+		{"negative rate", -1, time.Second, 1},
+		{"zero period", 10, 0, 1},
+		{"negative burst", 10, time.Second, -1},
+		{"rate above one per nanosecond", 10, 5 * time.Nanosecond, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewGCRALimiter(%d, %s, %d) did not panic", tt.rate, tt.period, tt.burst)
+				}
+			}()
+			NewGCRALimiter(tt.rate, tt.period, tt.burst)
+		})
+	}
+}
+
+func TestNewLimiterRejectsGCRABelowOneNanosecond(t *testing.T) {
+	_, err := NewLimiter(LimiterConfig{Algorithm: GCRA, MaxLimit: 10, Window: 5 * time.Nanosecond})
+	if err == nil {
+		t.Fatal("NewLimiter returned no error for a 0.5ns emission interval")
+	}
+}
@@ -0,0 +1,42 @@
+//go:build geoip
+
+package services
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// MaxMindExtractor is GeoKeyExtractor for deployments without a CDN header:
+// it looks the remote address up in a GeoIP2 or GeoLite2 country database.
+// Addresses the database cannot place are grouped under XX. The database
+// is memory-mapped and read-only, so lookups need no locking.
+func MaxMindExtractor(dbPath string, inner KeyExtractor) (KeyExtractor, error) {
+	db, err := geoip2.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r *http.Request) (string, error) {
+		key, err := inner(r)
+		if err != nil {
+			return "", err
+		}
+
+		ip, err := RemoteIPExtractor(r)
+		if err != nil {
+			return "", err
+		}
+
+		record, err := db.Country(net.ParseIP(ip))
+
+		country := unknownCountry
+		if err == nil && record.Country.IsoCode != "" {
+			country = record.Country.IsoCode
+		}
+
+		return key + keySeparator + country, nil
+	}, nil
+}
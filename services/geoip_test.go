@@ -0,0 +1,14 @@
+//go:build geoip
+
+package services
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMaxMindExtractorMissingDatabase(t *testing.T) {
+	if _, err := MaxMindExtractor(filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb"), APIKeyExtractor); err == nil {
+		t.Error("extractor built without a database")
+	}
+}
@@ -0,0 +1,316 @@
+//go:build gossip
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	gossipSyncInterval = time.Second
+	gossipMaxMessage   = 1200
+)
+
+// GossipLimiter shares per-key usage between instances over memberlist so
+// a cluster can enforce one limit without Redis.
+//
+// Consistency model: each node admits a request when its local limiter
+// allows it and the node's own count plus the last counts heard from every
+// peer stays within the limit for the current window. Counts are exchanged
+// every gossipSyncInterval (1s) as best-effort UDP messages, so a peer's
+// view is at most one sync interval plus one network hop stale, and lost
+// messages are only corrected by the next sync. A cluster of N nodes can
+// therefore overshoot by up to what N nodes admit in one sync interval.
+type GossipLimiter struct {
+	local   Limiter
+	list    *memberlist.Memberlist
+	node    string
+	mutex   sync.Mutex
+	counts  map[string]*gossipCount
+	peers   map[string]map[string]gossipCount
+	dirty   map[string]struct{}
+	events  memberlist.EventDelegate
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+type gossipCount struct {
+	WindowStart int64 `json:"w"`
+	// Length is the window's length in milliseconds, so a node can tell
+	// when a peer's count has expired.
+	Length int64 `json:"l"`
+	Count  int   `json:"c"`
+}
+
+func (c gossipCount) ended(nowMs int64) bool {
+	return c.WindowStart+c.Length <= nowMs
+}
+
+type gossipMessage struct {
+	Node   string                 `json:"node"`
+	Counts map[string]gossipCount `json:"counts"`
+}
+
+func NewGossipLimiter(localLimiter Limiter, memberlistConfig *memberlist.Config) (*GossipLimiter, error) {
+	g := &GossipLimiter{
+		local:  localLimiter,
+		node:   memberlistConfig.Name,
+		counts: make(map[string]*gossipCount),
+		peers:  make(map[string]map[string]gossipCount),
+		dirty:  make(map[string]struct{}),
+		events: memberlistConfig.Events,
+		stop:   make(chan struct{}),
+	}
+
+	memberlistConfig.Delegate = g
+	memberlistConfig.Events = g
+	list, err := memberlist.Create(memberlistConfig)
+	if err != nil {
+		return nil, err
+	}
+	g.list = list
+
+	go g.syncLoop()
+	return g, nil
+}
+
+// Join contacts existing members so this node starts exchanging counts.
+func (g *GossipLimiter) Join(peers ...string) (int, error) {
+	return g.list.Join(peers)
+}
+
+// Stop leaves the cluster and stops the sync loop. The node is shut down
+// even if announcing its departure fails.
+func (g *GossipLimiter) Stop() error {
+	g.stopped.Do(func() { close(g.stop) })
+	leaveErr := g.list.Leave(gossipSyncInterval)
+	return errors.Join(leaveErr, g.list.Shutdown())
+}
+
+func (g *GossipLimiter) Allow(key string) (Result, error) {
+	return g.AllowN(key, 1)
+}
+
+func (g *GossipLimiter) AllowN(key string, n int) (Result, error) {
+	result, err := g.local.AllowN(key, n)
+	if err != nil || !result.Allowed {
+		return result, err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	window, length := gossipWindow(result)
+	used := g.clusterCount(key, window)
+	if used+n > result.Limit {
+		if rl, ok := g.local.(returner); ok {
+			for range n {
+				rl.Return(key)
+			}
+		}
+
+		result.Allowed = false
+		result.Remaining = 0
+		result.RetryAfter = max(time.Until(time.UnixMilli(window).Add(length)), 0)
+		return result, nil
+	}
+
+	count := g.counts[key]
+	if count == nil || count.WindowStart != window {
+		count = &gossipCount{WindowStart: window, Length: length.Milliseconds()}
+		g.counts[key] = count
+	}
+	count.Count += n
+	g.dirty[key] = struct{}{}
+
+	result.Remaining = min(result.Remaining, result.Limit-used-n)
+	return result, nil
+}
+
+func (g *GossipLimiter) Peek(key string) Result {
+	result := g.local.Peek(key)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	window, _ := gossipWindow(result)
+	used := g.clusterCount(key, window)
+	result.Remaining = max(min(result.Remaining, result.Limit-used), 0)
+	result.Allowed = result.Allowed && result.Remaining > 0
+	return result
+}
+
+// clusterCount sums this node's and every peer's count for key in the
+// window starting at window. Counts from earlier windows no longer apply.
+func (g *GossipLimiter) clusterCount(key string, window int64) int {
+	total := 0
+	if count := g.counts[key]; count != nil && count.WindowStart == window {
+		total += count.Count
+	}
+	for _, counts := range g.peers {
+		if count, exists := counts[key]; exists && count.WindowStart == window {
+			total += count.Count
+		}
+	}
+
+	return total
+}
+
+// gossipWindow aligns counts to the limiter's window so every node agrees
+// on when they reset. It returns the window's start and length.
+func gossipWindow(result Result) (int64, time.Duration) {
+	window := result.Window
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	return time.Now().Truncate(window).UnixMilli(), window
+}
+
+func (g *GossipLimiter) syncLoop() {
+	ticker := time.NewTicker(gossipSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			g.prune()
+			g.broadcast()
+		}
+	}
+}
+
+func (g *GossipLimiter) broadcast() {
+	for _, message := range g.pendingMessages() {
+		for _, member := range g.list.Members() {
+			if member.Name != g.node {
+				g.list.SendBestEffort(member, message)
+			}
+		}
+	}
+}
+
+// prune drops every count whose window has ended, this node's and its
+// peers', so keys that have gone quiet do not stay in memory.
+func (g *GossipLimiter) prune() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	for key, count := range g.counts {
+		if count.ended(now) {
+			delete(g.counts, key)
+			delete(g.dirty, key)
+		}
+	}
+	for node, counts := range g.peers {
+		for key, count := range counts {
+			if count.ended(now) {
+				delete(counts, key)
+			}
+		}
+		if len(counts) == 0 {
+			delete(g.peers, node)
+		}
+	}
+}
+
+// pendingMessages encodes the counts changed since the last sync, split so
+// each message fits in a single UDP packet.
+func (g *GossipLimiter) pendingMessages() [][]byte {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var messages [][]byte
+	batch := gossipMessage{Node: g.node, Counts: make(map[string]gossipCount)}
+	size := 0
+	for key := range g.dirty {
+		if size+len(key)+32 > gossipMaxMessage && len(batch.Counts) > 0 {
+			messages = append(messages, encodeGossip(batch))
+			batch.Counts = make(map[string]gossipCount)
+			size = 0
+		}
+
+		batch.Counts[key] = *g.counts[key]
+		size += len(key) + 32
+	}
+	if len(batch.Counts) > 0 {
+		messages = append(messages, encodeGossip(batch))
+	}
+
+	g.dirty = make(map[string]struct{})
+	return messages
+}
+
+func encodeGossip(message gossipMessage) []byte {
+	data, _ := json.Marshal(message)
+	return data
+}
+
+func (g *GossipLimiter) NodeMeta(limit int) []byte {
+	return nil
+}
+
+func (g *GossipLimiter) NotifyMsg(data []byte) {
+	var message gossipMessage
+	if err := json.Unmarshal(data, &message); err != nil || message.Node == g.node {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	counts := g.peers[message.Node]
+	if counts == nil {
+		counts = make(map[string]gossipCount)
+		g.peers[message.Node] = counts
+	}
+	for key, count := range message.Counts {
+		if count.WindowStart >= counts[key].WindowStart {
+			counts[key] = count
+		}
+	}
+}
+
+func (g *GossipLimiter) GetBroadcasts(overhead, limit int) [][]byte {
+	return nil
+}
+
+func (g *GossipLimiter) LocalState(join bool) []byte {
+	return nil
+}
+
+func (g *GossipLimiter) MergeRemoteState(buf []byte, join bool) {}
+
+// NotifyJoin, NotifyLeave and NotifyUpdate make GossipLimiter the
+// memberlist EventDelegate, so a departed node's counts stop applying at
+// once. Events are passed on to the delegate set in the memberlist config,
+// if any.
+func (g *GossipLimiter) NotifyJoin(node *memberlist.Node) {
+	if g.events != nil {
+		g.events.NotifyJoin(node)
+	}
+}
+
+func (g *GossipLimiter) NotifyLeave(node *memberlist.Node) {
+	g.mutex.Lock()
+	delete(g.peers, node.Name)
+	g.mutex.Unlock()
+
+	if g.events != nil {
+		g.events.NotifyLeave(node)
+	}
+}
+
+func (g *GossipLimiter) NotifyUpdate(node *memberlist.Node) {
+	if g.events != nil {
+		g.events.NotifyUpdate(node)
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func GRPCAPIKeyExtractor(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("x-api-key")
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func UnaryServerInterceptor(limiter Limiter, extractor func(context.Context) string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, err := checkGRPC(ctx, limiter, extractor)
+		grpc.SetHeader(ctx, md)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor(limiter Limiter, extractor func(context.Context) string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, err := checkGRPC(ss.Context(), limiter, extractor)
+		ss.SetHeader(md)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+func checkGRPC(ctx context.Context, limiter Limiter, extractor func(context.Context) string) (metadata.MD, error) {
+	key := extractor(ctx)
+	if key == "" {
+		return metadata.MD{}, status.Error(codes.Unauthenticated, "missing API key")
+	}
+
+	result, err := limiter.Allow(key)
+	md := metadata.Pairs(
+		"x-ratelimit-limit", strconv.Itoa(result.Limit),
+		"x-ratelimit-remaining", strconv.Itoa(max(result.Remaining, 0)),
+		"x-ratelimit-reset", strconv.FormatInt(result.ResetAt.Unix(), 10),
+	)
+
+	if err != nil || !result.Allowed {
+		md.Set("grpc-retry-after", strconv.Itoa(retryAfterSeconds(result)))
+		return md, status.Errorf(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	return md, nil
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// HealthChecker reports whether a limiter can reach its backing store.
+// RateLimiter, RedisLimiter, MemcachedLimiter and DynamoDBLimiter
+// implement it.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthCheck always succeeds: RateLimiter keeps its state in memory.
+func (rl *RateLimiter) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// HealthHandler serves a readiness probe: 200 with {"status":"ok"}, or 503
+// with the error when checker cannot reach its store.
+func HealthHandler(checker HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := healthResponse{Status: "ok"}
+		status := http.StatusOK
+		if err := checker.HealthCheck(r.Context()); err != nil {
+			response = healthResponse{Status: "unavailable", Error: err.Error()}
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+var (
+	_ HealthChecker = (*RateLimiter)(nil)
+	_ HealthChecker = (*RedisLimiter)(nil)
+	_ HealthChecker = (*MemcachedLimiter)(nil)
+)
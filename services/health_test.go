@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+type healthCheckerFunc func(ctx context.Context) error
+
+func (f healthCheckerFunc) HealthCheck(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestHealthHandler(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	_, redisClient := newMiniredisClient(t, time.Unix(1_700_000_000, 0))
+	downServer, downClient := newMiniredisClient(t, time.Unix(1_700_000_000, 0))
+	downServer.Close()
+
+	tests := []struct {
+		name    string
+		checker HealthChecker
+		status  int
+		want    healthResponse
+	}{
+		{name: "in memory", checker: rl, status: http.StatusOK, want: healthResponse{Status: "ok"}},
+		{
+			name:    "redis up",
+			checker: NewRedisLimiter(redisClient, 5, time.Minute, "health:"),
+			status:  http.StatusOK,
+			want:    healthResponse{Status: "ok"},
+		},
+		{
+			name:    "redis down",
+			checker: NewRedisLimiter(downClient, 5, time.Minute, "health:"),
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "memcached down",
+			checker: NewMemcachedLimiter(memcache.New("127.0.0.1:1"), 5, time.Minute, "health:"),
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "failing backend",
+			checker: healthCheckerFunc(func(ctx context.Context) error { return errors.New("store unreachable") }),
+			status:  http.StatusServiceUnavailable,
+			want:    healthResponse{Status: "unavailable", Error: "store unreachable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HealthHandler(tt.checker).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			var got healthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != (healthResponse{}) && got != tt.want {
+				t.Errorf("body = %+v, want %+v", got, tt.want)
+			}
+			if tt.status != http.StatusOK && (got.Status != "unavailable" || got.Error == "") {
+				t.Errorf("body = %+v, want the unavailable status with an error", got)
+			}
+		})
+	}
+}
+
+func TestHealthHandlerPassesRequestContext(t *testing.T) {
+	checker := healthCheckerFunc(func(ctx context.Context) error { return ctx.Err() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	HealthHandler(checker).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil).WithContext(ctx))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status with a cancelled request = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+type EventHooks struct {
+	OnAllow func(key string, result Result)
+	OnDeny  func(key string, result Result)
+	OnError func(key string, err error)
+	// OnAllowCtx and OnDenyCtx also receive the request context when the
+	// middleware makes the call, so a hook can read RequestIDFromContext.
+	// Calls made without a context pass context.Background().
+	OnAllowCtx func(ctx context.Context, key string, result Result)
+	OnDenyCtx  func(ctx context.Context, key string, result Result)
+}
+
+// HookLimiter reports every decision of the wrapped limiter to EventHooks.
+// Each hook runs in its own goroutine so a slow audit sink never delays the
+// request, which also means hooks may run concurrently and out of order.
+type HookLimiter struct {
+	inner Limiter
+	hooks EventHooks
+}
+
+func WithHooks(inner Limiter, hooks EventHooks) Limiter {
+	return &HookLimiter{inner: inner, hooks: hooks}
+}
+
+func (hl *HookLimiter) Allow(key string) (Result, error) {
+	result, err := hl.inner.Allow(key)
+	hl.emit(context.Background(), key, result, err)
+	return result, err
+}
+
+func (hl *HookLimiter) AllowN(key string, n int) (Result, error) {
+	result, err := hl.inner.AllowN(key, n)
+	hl.emit(context.Background(), key, result, err)
+	return result, err
+}
+
+func (hl *HookLimiter) AllowNContext(ctx context.Context, key string, n int) (Result, error) {
+	result, err := allowN(ctx, hl.inner, key, n)
+	hl.emit(ctx, key, result, err)
+	return result, err
+}
+
+func (hl *HookLimiter) Peek(key string) Result {
+	return hl.inner.Peek(key)
+}
+
+// emit reports a *RateLimitError as a denial rather than an error, since it
+// is how waiting limiters say no.
+func (hl *HookLimiter) emit(ctx context.Context, key string, result Result, err error) {
+	var limitErr *RateLimitError
+	if errors.As(err, &limitErr) {
+		result, err = limitErr.Result(), nil
+	}
+
+	switch {
+	case err != nil:
+		if hl.hooks.OnError != nil {
+			go hl.hooks.OnError(key, err)
+		}
+	case result.Allowed:
+		if hl.hooks.OnAllow != nil {
+			go hl.hooks.OnAllow(key, result)
+		}
+		if hl.hooks.OnAllowCtx != nil {
+			go hl.hooks.OnAllowCtx(ctx, key, result)
+		}
+	default:
+		if hl.hooks.OnDeny != nil {
+			go hl.hooks.OnDeny(key, result)
+		}
+		if hl.hooks.OnDenyCtx != nil {
+			go hl.hooks.OnDenyCtx(ctx, key, result)
+		}
+	}
+}
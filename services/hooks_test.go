@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type hookEvent struct {
+	kind      string
+	key       string
+	remaining int
+	err       error
+	requestID string
+}
+
+func collectHooks() (EventHooks, <-chan hookEvent) {
+	events := make(chan hookEvent, 16)
+	hooks := EventHooks{
+		OnAllow: func(key string, result Result) {
+			events <- hookEvent{kind: "allow", key: key, remaining: result.Remaining}
+		},
+		OnDeny: func(key string, result Result) {
+			events <- hookEvent{kind: "deny", key: key, remaining: result.Remaining}
+		},
+		OnError: func(key string, err error) {
+			events <- hookEvent{kind: "error", key: key, err: err}
+		},
+	}
+
+	return hooks, events
+}
+
+func nextHookEvent(t *testing.T, events <-chan hookEvent) hookEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("hook not called")
+		return hookEvent{}
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	hooks, events := collectHooks()
+	limiter := WithHooks(rl, hooks)
+
+	calls := []struct {
+		call func() (Result, error)
+		want hookEvent
+	}{
+		{func() (Result, error) { return limiter.Allow("key") }, hookEvent{kind: "allow", key: "key", remaining: 1}},
+		{func() (Result, error) { return limiter.AllowN("key", 1) }, hookEvent{kind: "allow", key: "key", remaining: 0}},
+		{func() (Result, error) { return limiter.Allow("key") }, hookEvent{kind: "deny", key: "key", remaining: 0}},
+		{func() (Result, error) { return limiter.AllowN("other", 3) }, hookEvent{kind: "error", key: "other"}},
+	}
+
+	for i, c := range calls {
+		c.call()
+		event := nextHookEvent(t, events)
+		if event.kind != c.want.kind || event.key != c.want.key || event.remaining != c.want.remaining {
+			t.Errorf("call %d: hook event %+v, want %+v", i+1, event, c.want)
+		}
+		if c.want.kind == "error" && event.err == nil {
+			t.Errorf("call %d: OnError got a nil error", i+1)
+		}
+	}
+
+	limiter.Peek("key")
+	select {
+	case event := <-events:
+		t.Errorf("Peek fired hook %+v", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWithHooksRateLimitErrorIsDenial(t *testing.T) {
+	hooks, events := collectHooks()
+	limiter := WithHooks(rateLimitErrorLimiter{Result{Limit: 7, Remaining: 0}}, hooks)
+
+	if _, err := limiter.Allow("key"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Allow: err = %v, want the inner *RateLimitError passed on", err)
+	}
+	if event := nextHookEvent(t, events); event.kind != "deny" || event.key != "key" {
+		t.Errorf("hook event %+v, want a deny for key", event)
+	}
+}
+
+func TestWithHooksContext(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	events := make(chan hookEvent, 4)
+	limiter := WithHooks(rl, EventHooks{
+		OnAllowCtx: func(ctx context.Context, key string, result Result) {
+			events <- hookEvent{kind: "allow", key: key, requestID: RequestIDFromContext(ctx)}
+		},
+		OnDenyCtx: func(ctx context.Context, key string, result Result) {
+			events <- hookEvent{kind: "deny", key: key, requestID: RequestIDFromContext(ctx)}
+		},
+	})
+	handler := RequestID(RateLimiterMiddleware(okHandler, limiter))
+
+	for _, want := range []string{"allow", "deny"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-KEY", "apikey123")
+		req.Header.Set("X-Request-ID", "req-"+want)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		event := nextHookEvent(t, events)
+		if event.kind != want || event.requestID != "req-"+want {
+			t.Errorf("hook event %+v, want %s with req-%s", event, want, want)
+		}
+	}
+
+	limiter.Allow("apikey123")
+	if event := nextHookEvent(t, events); event.requestID != "" {
+		t.Errorf("call without a context got request ID %q", event.requestID)
+	}
+}
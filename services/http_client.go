@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// waiter is implemented by limiters that can block until a token is free,
+// such as RateLimiter.
+type waiter interface {
+	AllowCtx(ctx context.Context, key string) (Result, error)
+}
+
+// RateLimitedHTTPClient limits outbound requests, e.g. to stay within a
+// third-party API's quota. Use it as an http.Client's Transport.
+type RateLimitedHTTPClient struct {
+	inner   http.RoundTripper
+	limiter Limiter
+	keyFn   func(*http.Request) string
+}
+
+// NewRateLimitedHTTPClient wraps inner, or http.DefaultTransport when inner
+// is nil. keyFn picks the bucket for each request, typically its host.
+func NewRateLimitedHTTPClient(inner http.RoundTripper, limiter Limiter, keyFn func(*http.Request) string) http.RoundTripper {
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+
+	return &RateLimitedHTTPClient{inner: inner, limiter: limiter, keyFn: keyFn}
+}
+
+// RoundTrip waits for a token when the limiter supports AllowCtx, until the
+// request's context ends, and checks once otherwise. A denied request never
+// reaches inner; it gets a synthetic 429 carrying Retry-After instead.
+func (c *RateLimitedHTTPClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := c.keyFn(req)
+
+	var (
+		result Result
+		err    error
+	)
+	if w, ok := c.limiter.(waiter); ok {
+		result, err = w.AllowCtx(req.Context(), key)
+	} else {
+		result, err = allowN(req.Context(), c.limiter, key, 1)
+	}
+
+	if err == nil && result.Allowed {
+		return c.inner.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if ctxErr := req.Context().Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	return tooManyRequestsResponse(req, result), nil
+}
+
+func tooManyRequestsResponse(req *http.Request, result Result) *http.Response {
+	header := make(http.Header)
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+	header.Set("Retry-After", strconv.Itoa(retryAfterSeconds(result)))
+
+	return &http.Response{
+		Status:     strconv.Itoa(http.StatusTooManyRequests) + " " + http.StatusText(http.StatusTooManyRequests),
+		StatusCode: http.StatusTooManyRequests,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func byHost(r *http.Request) string {
+	return r.URL.Host
+}
+
+func newUpstream(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream, &hits
+}
+
+func TestRateLimitedHTTPClientDenies(t *testing.T) {
+	upstream, hits := newUpstream(t)
+	client := &http.Client{Transport: NewRateLimitedHTTPClient(upstream.Client().Transport, NewSlidingWindowLimiter(2, time.Hour), byHost)}
+
+	for i := range 2 {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, resp.StatusCode)
+		}
+	}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("third request: status = %d, want 429", resp.StatusCode)
+	}
+	if retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retryAfter <= 0 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream received %d requests, want 2", got)
+	}
+}
+
+func TestRateLimitedHTTPClientWaits(t *testing.T) {
+	upstream, hits := newUpstream(t)
+	rl, clock := newTestRateLimiter(t, 1, 60, LimiterOptions{PollInterval: time.Millisecond})
+	client := &http.Client{Transport: NewRateLimitedHTTPClient(upstream.Client().Transport, rl, byHost)}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("request with an empty bucket: err = %v, want the context's deadline", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(upstream.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(time.Minute)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("request after refill: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request still waiting after the bucket refilled")
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream received %d requests, want 2", got)
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// HybridWindowLimiter approximates a sliding window with two fixed-window
+// counters per key, so memory stays O(1) per key instead of one timestamp
+// per request as in SlidingWindowLimiter.
+//
+// For a request at time t with elapsed = t - start of the current window,
+// the sliding window [t-window, t] covers all of the current window and the
+// last (window-elapsed) of the previous one. Assuming the previous window's
+// requests were spread evenly over it, that tail held
+// prev * (window-elapsed)/window of them, so
+//
+//	rate = prev * (window-elapsed)/window + curr
+//
+// estimates the number of requests in the sliding window, and a cost n is
+// admitted only while rate + n <= maxLimit.
+//
+// Bounds: the weight lies in (0, 1], so rate >= curr and the check alone
+// keeps every fixed window at or below maxLimit, which is never worse than
+// FixedWindowLimiter. The estimate errs only in how the previous window's
+// requests are placed; it is exact when they are uniform and in the worst
+// case (all of them packed at its very end) lets through prev*elapsed/window
+// extra requests, so no sliding window ever holds more than 2*maxLimit.
+// When traffic is steady the error is small in practice, which is why this
+// scheme is the one used by Cloudflare and Nginx.
+type HybridWindowLimiter struct {
+	counters      map[string]*hybridCounter
+	mutex         sync.Mutex
+	maxLimit      int
+	window        time.Duration
+	currentWindow time.Time
+}
+
+type hybridCounter struct {
+	windowStart time.Time
+	prev        int
+	curr        int
+}
+
+func NewHybridWindowLimiter(maxLimit int, window time.Duration) *HybridWindowLimiter {
+	return &HybridWindowLimiter{
+		counters: make(map[string]*hybridCounter),
+		maxLimit: maxLimit,
+		window:   window,
+	}
+}
+
+func (hw *HybridWindowLimiter) Allow(apiKey string) (Result, error) {
+	return hw.AllowN(apiKey, 1)
+}
+
+func (hw *HybridWindowLimiter) AllowN(apiKey string, n int) (Result, error) {
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+
+	now := time.Now()
+	windowStart := now.Truncate(hw.window)
+	if n < 1 {
+		return Result{Limit: hw.maxLimit, Window: hw.window, ResetAt: windowStart.Add(hw.window)}, errInvalidCost(n)
+	}
+	if n > hw.maxLimit {
+		return Result{Limit: hw.maxLimit, Window: hw.window, ResetAt: windowStart.Add(hw.window)}, errCostExceedsLimit(n, hw.maxLimit)
+	}
+
+	if windowStart.After(hw.currentWindow) {
+		hw.collectExpired(windowStart)
+		hw.currentWindow = windowStart
+	}
+
+	counter, exists := hw.counters[apiKey]
+	if !exists {
+		counter = &hybridCounter{windowStart: windowStart}
+		hw.counters[apiKey] = counter
+	}
+	counter.roll(windowStart, hw.window)
+
+	rate := counter.rate(now, hw.window)
+	allowed := rate+float64(n) <= float64(hw.maxLimit)
+	if allowed {
+		counter.curr += n
+		rate += float64(n)
+	}
+
+	result := counter.result(allowed, hw.maxLimit, rate, now, hw.window)
+	if !allowed {
+		result.RetryAfter = counter.retryAfter(hw.maxLimit-n, now, hw.window)
+	}
+
+	return result, nil
+}
+
+func (hw *HybridWindowLimiter) Peek(apiKey string) Result {
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+
+	now := time.Now()
+	current := hybridCounter{windowStart: now.Truncate(hw.window)}
+	if counter, exists := hw.counters[apiKey]; exists {
+		current = *counter
+		current.roll(now.Truncate(hw.window), hw.window)
+	}
+
+	rate := current.rate(now, hw.window)
+	result := current.result(rate+1 <= float64(hw.maxLimit), hw.maxLimit, rate, now, hw.window)
+	if !result.Allowed {
+		result.RetryAfter = current.retryAfter(hw.maxLimit-1, now, hw.window)
+	}
+
+	return result
+}
+
+// roll moves the counter forward to the window starting at windowStart. The
+// current count becomes the previous one only if the two windows are
+// adjacent; after a longer gap both are zero.
+func (c *hybridCounter) roll(windowStart time.Time, window time.Duration) {
+	switch {
+	case !windowStart.After(c.windowStart):
+		return
+	case windowStart.Sub(c.windowStart) == window:
+		c.prev = c.curr
+	default:
+		c.prev = 0
+	}
+
+	c.curr = 0
+	c.windowStart = windowStart
+}
+
+func (c *hybridCounter) rate(now time.Time, window time.Duration) float64 {
+	elapsed := now.Sub(c.windowStart)
+	weight := float64(window-elapsed) / float64(window)
+	return float64(c.prev)*weight + float64(c.curr)
+}
+
+// result reports ResetAt as the moment both counters have aged out: the end
+// of the next window if the current one saw requests, otherwise the end of
+// this one.
+func (c *hybridCounter) result(allowed bool, maxLimit int, rate float64, now time.Time, window time.Duration) Result {
+	resetAt := now
+	switch {
+	case c.curr > 0:
+		resetAt = c.windowStart.Add(2 * window)
+	case c.prev > 0:
+		resetAt = c.windowStart.Add(window)
+	}
+
+	return Result{
+		Allowed:   allowed,
+		Limit:     maxLimit,
+		Window:    window,
+		Remaining: max(maxLimit-int(math.Ceil(rate)), 0),
+		ResetAt:   resetAt,
+	}
+}
+
+// retryAfter solves rate(t) <= target for the earliest t. Within the
+// current window rate falls linearly as the previous count's weight decays,
+// so when curr <= target the answer is at
+//
+//	elapsed = window * (1 - (target-curr)/prev)
+//
+// Otherwise it lies past the next boundary, where curr becomes the previous
+// count and the same reasoning applies from zero.
+func (c *hybridCounter) retryAfter(target int, now time.Time, window time.Duration) time.Duration {
+	windowStart, prev, curr := c.windowStart, c.prev, c.curr
+	if curr > target {
+		windowStart, prev, curr = windowStart.Add(window), curr, 0
+	}
+
+	elapsed := time.Duration(0)
+	if prev > 0 {
+		elapsed = time.Duration(float64(window) * (1 - float64(target-curr)/float64(prev)))
+	}
+
+	return max(windowStart.Add(elapsed).Sub(now), 0)
+}
+
+// collectExpired drops counters with nothing left in either window: their
+// current window ended before the previous one started.
+func (hw *HybridWindowLimiter) collectExpired(windowStart time.Time) {
+	cutoff := windowStart.Add(-hw.window)
+	for apiKey, counter := range hw.counters {
+		if counter.windowStart.Before(cutoff) {
+			delete(hw.counters, apiKey)
+		}
+	}
+}
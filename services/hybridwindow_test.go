@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+const hybridTestWindow = time.Minute
+
+var hybridTestStart = time.Unix(1_700_000_000, 0).Truncate(hybridTestWindow)
+
+// hybridCase turns quick's arbitrary values into a counter with up to 255
+// requests in each window and a moment within the current window.
+func hybridCase(prev, curr uint8, at uint16) (hybridCounter, time.Time) {
+	counter := hybridCounter{windowStart: hybridTestStart, prev: int(prev), curr: int(curr)}
+	now := hybridTestStart.Add(time.Duration(at) * hybridTestWindow / (1 << 16))
+	return counter, now
+}
+
+func TestHybridCounterRateBounds(t *testing.T) {
+	property := func(prev, curr uint8, at uint16) bool {
+		counter, now := hybridCase(prev, curr, at)
+		rate := counter.rate(now, hybridTestWindow)
+		return rate >= float64(curr) && rate <= float64(prev)+float64(curr)
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHybridCounterRateDecays(t *testing.T) {
+	property := func(prev, curr uint8, at, later uint16) bool {
+		if later < at {
+			at, later = later, at
+		}
+		counter, now := hybridCase(prev, curr, at)
+		_, then := hybridCase(prev, curr, later)
+		return counter.rate(then, hybridTestWindow) <= counter.rate(now, hybridTestWindow)
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestHybridCounterRetryAfter checks that waiting RetryAfter brings the
+// rate down to target, rolling into the next window if that is where it
+// lands, and that waiting noticeably less does not.
+func TestHybridCounterRetryAfter(t *testing.T) {
+	rateAt := func(counter hybridCounter, at time.Time) float64 {
+		counter.roll(at.Truncate(hybridTestWindow), hybridTestWindow)
+		return counter.rate(at, hybridTestWindow)
+	}
+
+	property := func(prev, curr uint8, at uint16, target uint8) bool {
+		counter, now := hybridCase(prev, curr, at)
+		wait := counter.retryAfter(int(target), now, hybridTestWindow)
+		if wait < 0 || wait > 2*hybridTestWindow {
+			return false
+		}
+
+		const slack = time.Millisecond
+		if rateAt(counter, now.Add(wait+slack)) > float64(target)+1e-6 {
+			return false
+		}
+		return wait <= slack || rateAt(counter, now.Add(wait-slack)) > float64(target)-1e-6
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHybridCounterRoll(t *testing.T) {
+	property := func(prev, curr uint8, windows uint8) bool {
+		counter, _ := hybridCase(prev, curr, 0)
+		counter.roll(hybridTestStart.Add(time.Duration(windows)*hybridTestWindow), hybridTestWindow)
+
+		switch windows {
+		case 0:
+			return counter.prev == int(prev) && counter.curr == int(curr)
+		case 1:
+			return counter.prev == int(curr) && counter.curr == 0
+		default:
+			return counter.prev == 0 && counter.curr == 0
+		}
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestHybridWindowLimiterNeverExceedsFixedWindow drives the limiter with
+// arbitrary costs and checks the bound shown in its doc comment: no fixed
+// window admits more than maxLimit.
+func TestHybridWindowLimiterNeverExceedsFixedWindow(t *testing.T) {
+	property := func(maxLimit uint8, costs []uint8) bool {
+		limit := int(maxLimit%20) + 1
+		hw := NewHybridWindowLimiter(limit, 365*24*time.Hour)
+
+		admitted := 0
+		for _, cost := range costs {
+			n := int(cost%uint8(limit)) + 1
+			result, err := hw.AllowN("key", n)
+			if err != nil {
+				return false
+			}
+			if result.Allowed {
+				admitted += n
+			}
+			if result.Remaining < 0 || result.Remaining > limit {
+				return false
+			}
+		}
+
+		// With a year-long window every call lands in the same one.
+		return admitted <= limit
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHybridWindowLimiter(t *testing.T) {
+	hw := NewHybridWindowLimiter(3, time.Hour)
+
+	for i := range 3 {
+		if result, err := hw.Allow("key"); err != nil || !result.Allowed {
+			t.Fatalf("request %d: %+v, %v", i+1, result, err)
+		}
+	}
+	result, _ := hw.Allow("key")
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 {
+		t.Errorf("fourth request = %+v, want a denial with RetryAfter", result)
+	}
+	if peek := hw.Peek("other"); !peek.Allowed || peek.Remaining != 3 {
+		t.Errorf("Peek of an unseen key = %+v", peek)
+	}
+
+	if _, err := hw.AllowN("key", 0); !errors.Is(err, ErrInvalidCost) {
+		t.Errorf("AllowN(0): err = %v, want ErrInvalidCost", err)
+	}
+	if _, err := hw.AllowN("key", 4); !errors.Is(err, ErrCostExceedsCapacity) {
+		t.Errorf("AllowN(4): err = %v, want ErrCostExceedsCapacity", err)
+	}
+}
@@ -0,0 +1,275 @@
+//go:build k8s
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	apistore "rate-limiter/api-store"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// RateLimitPolicyResource is the custom resource defined in
+// deploy/crds/ratelimitpolicy.yaml.
+var RateLimitPolicyResource = schema.GroupVersionResource{
+	Group:    "ratelimiter.binary0-1.github.io",
+	Version:  "v1alpha1",
+	Resource: "ratelimitpolicies",
+}
+
+// k8sResyncPeriod also bounds how long a key registered in the store after
+// the last policy event waits before its policy applies.
+const k8sResyncPeriod = time.Minute
+
+type RateLimitPolicySpec struct {
+	MaxLimit int    `json:"maxLimit"`
+	Window   string `json:"window"`
+	// Selector matches APIKeyConfig.Labels. A policy without one selects
+	// no keys.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type k8sPolicy struct {
+	config   LimitConfig
+	selector labels.Selector
+}
+
+// k8sPolicyApplier turns the current set of policies into per-key overrides
+// on a RateLimiter. It is shared by K8sWatcher and FakeK8sWatcher so tests
+// exercise the same matching rules as a cluster.
+type k8sPolicyApplier struct {
+	limiter  *RateLimiter
+	store    apistore.Store
+	mutex    sync.Mutex
+	policies map[string]k8sPolicy
+	applied  map[string]LimitConfig
+}
+
+func newK8sPolicyApplier(limiter *RateLimiter, store apistore.Store) *k8sPolicyApplier {
+	return &k8sPolicyApplier{
+		limiter:  limiter,
+		store:    store,
+		policies: make(map[string]k8sPolicy),
+		applied:  make(map[string]LimitConfig),
+	}
+}
+
+func (a *k8sPolicyApplier) upsert(name string, spec RateLimitPolicySpec) error {
+	policy, err := parseRateLimitPolicySpec(spec)
+	if err != nil {
+		return fmt.Errorf("rate limit policy %s: %w", name, err)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.policies[name] = policy
+	return a.applyLocked()
+}
+
+func (a *k8sPolicyApplier) remove(name string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.policies, name)
+	return a.applyLocked()
+}
+
+func (a *k8sPolicyApplier) resync() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.applyLocked()
+}
+
+// applyLocked matches every key in the store against the policies. When
+// several policies select the same key, the one whose name sorts first
+// wins, so the result doesn't depend on event order. Keys no policy selects
+// any more are Reset to their configured limit.
+func (a *k8sPolicyApplier) applyLocked() error {
+	names := slices.Sorted(maps.Keys(a.policies))
+
+	wanted := make(map[string]LimitConfig)
+	for key, cfg := range a.store.GetApiKeys() {
+		keyLabels := labels.Set(cfg.Labels)
+		for _, name := range names {
+			if a.policies[name].selector.Matches(keyLabels) {
+				wanted[key] = a.policies[name].config
+				break
+			}
+		}
+	}
+
+	var errs []error
+	for key, cfg := range wanted {
+		if old, exists := a.applied[key]; exists && old == cfg {
+			continue
+		}
+		if err := a.limiter.SetLimitConfig(key, cfg); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		a.applied[key] = cfg
+	}
+	for key := range a.applied {
+		if _, exists := wanted[key]; exists {
+			continue
+		}
+		if err := a.limiter.Reset(key); err != nil && !errors.Is(err, ErrUnknownKey) {
+			errs = append(errs, err)
+			continue
+		}
+		delete(a.applied, key)
+	}
+
+	return errors.Join(errs...)
+}
+
+func parseRateLimitPolicySpec(spec RateLimitPolicySpec) (k8sPolicy, error) {
+	window, err := time.ParseDuration(spec.Window)
+	if err != nil {
+		return k8sPolicy{}, fmt.Errorf("window: %w", err)
+	}
+	if window < time.Second || window%time.Second != 0 {
+		return k8sPolicy{}, fmt.Errorf("window must be a whole number of seconds, got %s", window)
+	}
+	if spec.MaxLimit <= 0 {
+		return k8sPolicy{}, fmt.Errorf("maxLimit must be positive, got %d", spec.MaxLimit)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(spec.Selector)
+	if err != nil {
+		return k8sPolicy{}, fmt.Errorf("selector: %w", err)
+	}
+
+	return k8sPolicy{
+		config:   LimitConfig{MaxLimit: spec.MaxLimit, WindowSeconds: int(window / time.Second)},
+		selector: selector,
+	}, nil
+}
+
+// K8sWatcher applies RateLimitPolicy resources from a cluster to a
+// RateLimiter, selecting keys from store by their labels.
+type K8sWatcher struct {
+	*k8sPolicyApplier
+	onError func(err error)
+	stop    chan struct{}
+}
+
+// NewK8sWatcher starts watching RateLimitPolicy resources in namespace, or
+// in every namespace when it is empty, and returns once the initial list
+// has been applied. Invalid policies are passed to onError, if not nil,
+// and otherwise ignored.
+func NewK8sWatcher(client dynamic.Interface, namespace string, limiter *RateLimiter, store apistore.Store, onError func(err error)) (*K8sWatcher, error) {
+	w := &K8sWatcher{
+		k8sPolicyApplier: newK8sPolicyApplier(limiter, store),
+		onError:          onError,
+		stop:             make(chan struct{}),
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, k8sResyncPeriod, namespace, nil)
+	informer := factory.ForResource(RateLimitPolicyResource).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.onUpsert,
+		UpdateFunc: func(_, obj any) { w.onUpsert(obj) },
+		DeleteFunc: w.onDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	factory.Start(w.stop)
+	if !cache.WaitForCacheSync(w.stop, informer.HasSynced) {
+		close(w.stop)
+		return nil, errors.New("rate limit policy informer did not sync")
+	}
+
+	return w, nil
+}
+
+func (w *K8sWatcher) Stop() {
+	close(w.stop)
+}
+
+func (w *K8sWatcher) onUpsert(obj any) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	var policy struct {
+		Spec RateLimitPolicySpec `json:"spec"`
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &policy); err != nil {
+		w.report(fmt.Errorf("rate limit policy %s: %w", policyName(u), err))
+		return
+	}
+
+	w.report(w.upsert(policyName(u), policy.Spec))
+}
+
+func (w *K8sWatcher) onDelete(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	w.report(w.remove(policyName(u)))
+}
+
+func (w *K8sWatcher) report(err error) {
+	if err != nil && w.onError != nil {
+		w.onError(err)
+	}
+}
+
+func policyName(u *unstructured.Unstructured) string {
+	if u.GetNamespace() == "" {
+		return u.GetName()
+	}
+
+	return u.GetNamespace() + "/" + u.GetName()
+}
+
+// FakeK8sWatcher applies policies the way K8sWatcher does, but takes its
+// events from the caller instead of a cluster.
+type FakeK8sWatcher struct {
+	*k8sPolicyApplier
+}
+
+func NewFakeK8sWatcher(limiter *RateLimiter, store apistore.Store) *FakeK8sWatcher {
+	return &FakeK8sWatcher{k8sPolicyApplier: newK8sPolicyApplier(limiter, store)}
+}
+
+// Apply simulates the policy called name being created or updated.
+func (f *FakeK8sWatcher) Apply(name string, spec RateLimitPolicySpec) error {
+	return f.upsert(name, spec)
+}
+
+// Delete simulates the policy called name being deleted.
+func (f *FakeK8sWatcher) Delete(name string) error {
+	return f.remove(name)
+}
+
+// Resync re-matches the store's keys, as the informer's periodic resync
+// does, picking up keys registered since the last event.
+func (f *FakeK8sWatcher) Resync() error {
+	return f.resync()
+}
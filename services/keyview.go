@@ -0,0 +1,64 @@
+package services
+
+import "context"
+
+// KeyView binds a RateLimiter to one key, so per-key calls read as
+// limiter.ForKey("apikey123").Allow(). It holds nothing but the two, and
+// ForKey is small enough to inline, so a view used in a single expression
+// stays on the stack.
+type KeyView struct {
+	limiter *RateLimiter
+	key     string
+	err     error
+}
+
+func (rl *RateLimiter) ForKey(key string) *KeyView {
+	return &KeyView{limiter: rl, key: key}
+}
+
+func (kv *KeyView) Key() string {
+	return kv.key
+}
+
+func (kv *KeyView) Allow() (Result, error) {
+	return kv.limiter.Allow(kv.key)
+}
+
+func (kv *KeyView) AllowN(n int) (Result, error) {
+	return kv.limiter.AllowN(kv.key, n)
+}
+
+func (kv *KeyView) AllowCtx(ctx context.Context) (Result, error) {
+	return kv.limiter.AllowCtx(ctx, kv.key)
+}
+
+func (kv *KeyView) Peek() Result {
+	return kv.limiter.Peek(kv.key)
+}
+
+func (kv *KeyView) Reset() error {
+	return kv.limiter.Reset(kv.key)
+}
+
+func (kv *KeyView) Return() {
+	kv.limiter.Return(kv.key)
+}
+
+func (kv *KeyView) Explain() string {
+	return kv.limiter.Explain(kv.key)
+}
+
+// SetOverride registers cfg as the key's limit and returns the view for
+// chaining. An invalid cfg is not applied; Err reports why.
+func (kv *KeyView) SetOverride(cfg LimitConfig) *KeyView {
+	if err := kv.limiter.SetLimitConfig(kv.key, cfg); err != nil && kv.err == nil {
+		kv.err = err
+	}
+
+	return kv
+}
+
+// Err is the first error from SetOverride on this view.
+func (kv *KeyView) Err() error {
+	return kv.err
+}
@@ -0,0 +1,61 @@
+package services
+
+import "testing"
+
+func TestKeyView(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+
+	view := rl.ForKey("apikey123").SetOverride(LimitConfig{MaxLimit: 2, WindowSeconds: 60})
+	if err := view.Err(); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	if view.Key() != "apikey123" {
+		t.Errorf("Key() = %q", view.Key())
+	}
+
+	if result, _ := view.Allow(); !result.Allowed || result.Limit != 2 {
+		t.Fatalf("Allow = %+v, want the override's limit of 2", result)
+	}
+	if result, _ := view.Allow(); !result.Allowed {
+		t.Fatalf("second Allow = %+v", result)
+	}
+	if result, _ := view.Allow(); result.Allowed {
+		t.Errorf("Allow past the override = %+v, want a denial", result)
+	}
+
+	view.Return()
+	if peek := view.Peek(); peek.Remaining != 1 {
+		t.Errorf("Peek after Return = %+v, want 1 remaining", peek)
+	}
+	if err := view.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if peek := rl.Peek("apikey123"); peek.Remaining != 10 {
+		t.Errorf("Peek after Reset = %+v, want the override dropped", peek)
+	}
+	if peek := rl.Peek("other"); peek.Remaining != 10 {
+		t.Errorf("another key = %+v, want it unaffected", peek)
+	}
+
+	// The first error sticks, and invalid overrides are not applied.
+	bad := rl.ForKey("apikey124").
+		SetOverride(LimitConfig{MaxLimit: 0, WindowSeconds: 60}).
+		SetOverride(LimitConfig{MaxLimit: 5, WindowSeconds: 60})
+	if bad.Err() == nil {
+		t.Error("invalid override accepted")
+	}
+	if peek := bad.Peek(); peek.Limit != 5 {
+		t.Errorf("Peek = %+v, want the valid override applied", peek)
+	}
+}
+
+func TestKeyViewInlineDoesNotAllocate(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = rl.ForKey("apikey123").Key()
+	})
+	if allocs != 0 {
+		t.Errorf("inline ForKey allocated %v times per call, want 0", allocs)
+	}
+}
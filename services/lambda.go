@@ -0,0 +1,66 @@
+//go:build lambda
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// LambdaMiddleware rate limits API Gateway proxy invocations before they
+// reach handler. Lambda keeps no memory between invocations, so limiter
+// should be backed by a shared store such as DynamoDBLimiter or Redis.
+func LambdaMiddleware(handler lambda.Handler, limiter Limiter, extractor func(events.APIGatewayProxyRequest) string) lambda.Handler {
+	return lambdaHandler(func(ctx context.Context, payload []byte) ([]byte, error) {
+		var request events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, err
+		}
+
+		key := extractor(request)
+		if key == "" {
+			return json.Marshal(events.APIGatewayProxyResponse{
+				StatusCode: http.StatusUnauthorized,
+				Body:       "Missing API key",
+			})
+		}
+
+		result, err := allowN(ctx, limiter, key, 1)
+		if err != nil || !result.Allowed {
+			return json.Marshal(events.APIGatewayProxyResponse{
+				StatusCode: http.StatusTooManyRequests,
+				Headers: map[string]string{
+					"X-RateLimit-Limit":     strconv.Itoa(result.Limit),
+					"X-RateLimit-Remaining": strconv.Itoa(max(result.Remaining, 0)),
+					"X-RateLimit-Reset":     strconv.FormatInt(result.ResetAt.Unix(), 10),
+					"Retry-After":           strconv.Itoa(retryAfterSeconds(result)),
+				},
+				Body: "Rate limit exceeded",
+			})
+		}
+
+		return handler.Invoke(ctx, payload)
+	})
+}
+
+// LambdaAPIKeyExtractor reads the X-API-Key header from an API Gateway event.
+func LambdaAPIKeyExtractor(request events.APIGatewayProxyRequest) string {
+	for name, value := range request.Headers {
+		if http.CanonicalHeaderKey(name) == "X-Api-Key" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+type lambdaHandler func(ctx context.Context, payload []byte) ([]byte, error)
+
+func (h lambdaHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	return h(ctx, payload)
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+type LeakyBucketLimiter struct {
+	buckets   map[string]chan time.Time
+	mutex     sync.Mutex
+	capacity  int
+	drainRate float64
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewLeakyBucketLimiter(capacity int, drainRate float64) *LeakyBucketLimiter {
+	lb := &LeakyBucketLimiter{
+		buckets:   make(map[string]chan time.Time),
+		capacity:  capacity,
+		drainRate: drainRate,
+		stop:      make(chan struct{}),
+	}
+
+	go lb.drain()
+	return lb
+}
+
+func (lb *LeakyBucketLimiter) Allow(apiKey string) (Result, error) {
+	return lb.AllowN(apiKey, 1)
+}
+
+func (lb *LeakyBucketLimiter) AllowN(apiKey string, n int) (Result, error) {
+	if n < 1 {
+		return Result{Limit: lb.capacity, Window: lb.window(), ResetAt: time.Now()}, errInvalidCost(n)
+	}
+	if n > lb.capacity {
+		return Result{Limit: lb.capacity, Window: lb.window(), ResetAt: time.Now()}, errCostExceedsLimit(n, lb.capacity)
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	bucket, exists := lb.buckets[apiKey]
+	if !exists {
+		bucket = make(chan time.Time, lb.capacity)
+		lb.buckets[apiKey] = bucket
+	}
+
+	now := time.Now()
+	allowed := cap(bucket)-len(bucket) >= n
+	if allowed {
+		for i := 0; i < n; i++ {
+			bucket <- now
+		}
+	}
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     lb.capacity,
+		Window:    lb.window(),
+		Remaining: cap(bucket) - len(bucket),
+		ResetAt:   now.Add(lb.drainInterval() * time.Duration(len(bucket))),
+	}
+	if !allowed {
+		result.RetryAfter = lb.drainInterval() * time.Duration(n-result.Remaining)
+	}
+
+	return result, nil
+}
+
+func (lb *LeakyBucketLimiter) Peek(apiKey string) Result {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	queued := 0
+	if bucket, exists := lb.buckets[apiKey]; exists {
+		queued = len(bucket)
+	}
+
+	result := Result{
+		Allowed:   queued < lb.capacity,
+		Limit:     lb.capacity,
+		Window:    lb.window(),
+		Remaining: lb.capacity - queued,
+		ResetAt:   time.Now().Add(lb.drainInterval() * time.Duration(queued)),
+	}
+	if !result.Allowed {
+		result.RetryAfter = lb.drainInterval()
+	}
+
+	return result
+}
+
+func (lb *LeakyBucketLimiter) Stop() {
+	lb.stopOnce.Do(func() { close(lb.stop) })
+}
+
+func (lb *LeakyBucketLimiter) drainInterval() time.Duration {
+	if lb.drainRate <= 0 {
+		return time.Second
+	}
+
+	return time.Duration(float64(time.Second) / lb.drainRate)
+}
+
+// window is how long a full bucket takes to drain.
+func (lb *LeakyBucketLimiter) window() time.Duration {
+	return lb.drainInterval() * time.Duration(lb.capacity)
+}
+
+// drain leaks one request out of every bucket per interval, dropping the
+// buckets that have emptied so idle keys do not accumulate.
+func (lb *LeakyBucketLimiter) drain() {
+	ticker := time.NewTicker(lb.drainInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lb.stop:
+			return
+		case <-ticker.C:
+			lb.mutex.Lock()
+			for apiKey, bucket := range lb.buckets {
+				select {
+				case <-bucket:
+				default:
+				}
+				if len(bucket) == 0 {
+					delete(lb.buckets, apiKey)
+				}
+			}
+			lb.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Limiter interface {
+	Allow(key string) (Result, error)
+	AllowN(key string, n int) (Result, error)
+	Peek(key string) Result
+}
+
+type Result struct {
+	Allowed bool `json:"allowed"`
+	Limit   int  `json:"limit"`
+	// Window is the period Limit applies to. It is not part of the JSON
+	// quota body; clients read it from the X-RateLimit-Policy header.
+	Window    time.Duration `json:"-"`
+	Remaining int           `json:"remaining"`
+	ResetAt   time.Time     `json:"resetAt"`
+	// RetryAfter is how long a denied caller must wait before the same
+	// request can succeed. It is zero when the request was allowed.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// ErrCostExceedsCapacity is returned by AllowN when n is more than the
+// bucket can ever hold, so the request could never succeed however long the
+// caller waited.
+var ErrCostExceedsCapacity = errors.New("cost exceeds capacity")
+
+// ErrInvalidCost is returned by AllowN when n is less than 1. Without it a
+// negative cost would add tokens to the bucket instead of taking them.
+var ErrInvalidCost = errors.New("cost must be at least 1")
+
+// ErrRateLimited matches every *RateLimitError under errors.Is.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitError reports a denial with the quota behind it, so callers
+// several frames up can recover it with errors.As rather than re-reading
+// headers.
+type RateLimitError struct {
+	Key        string
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration
+	// Err is why the caller stopped waiting, such as context.Canceled. It
+	// is nil for an immediate denial.
+	Err    error
+	window time.Duration
+}
+
+func newRateLimitError(key string, result Result, cause error) *RateLimitError {
+	return &RateLimitError{
+		Key:        key,
+		Limit:      result.Limit,
+		Remaining:  result.Remaining,
+		ResetAt:    result.ResetAt,
+		RetryAfter: result.RetryAfter,
+		Err:        cause,
+		window:     result.Window,
+	}
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("rate limit exceeded for %q: %d of %d remaining, retry after %s", e.Key, e.Remaining, e.Limit, e.RetryAfter)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *RateLimitError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrRateLimited}
+	}
+
+	return []error{ErrRateLimited, e.Err}
+}
+
+// Result rebuilds the denied Result the error was made from.
+func (e *RateLimitError) Result() Result {
+	return Result{
+		Limit:      e.Limit,
+		Window:     e.window,
+		Remaining:  e.Remaining,
+		ResetAt:    e.ResetAt,
+		RetryAfter: e.RetryAfter,
+	}
+}
+
+func errInvalidCost(n int) error {
+	return fmt.Errorf("%w, got %d", ErrInvalidCost, n)
+}
+
+func errCostExceedsLimit(n, limit int) error {
+	return fmt.Errorf("%w: request cost %d, maximum %d", ErrCostExceedsCapacity, n, limit)
+}
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*ShardedRateLimiter)(nil)
+	_ Limiter = (*AtomicRateLimiter)(nil)
+	_ Limiter = (*SlidingWindowLimiter)(nil)
+	_ Limiter = (*FixedWindowLimiter)(nil)
+	_ Limiter = (*LeakyBucketLimiter)(nil)
+	_ Limiter = (*GCRALimiter)(nil)
+	_ Limiter = (*HybridWindowLimiter)(nil)
+	_ Limiter = (*RedisLimiter)(nil)
+	_ Limiter = (*MemcachedLimiter)(nil)
+	_ Limiter = (*MetricsLimiter)(nil)
+	_ Limiter = (*ChainLimiter)(nil)
+	_ Limiter = (*ScheduledLimiter)(nil)
+	_ Limiter = (*HookLimiter)(nil)
+	_ Limiter = (*QueueingLimiter)(nil)
+	_ Limiter = (*DryRunLimiter)(nil)
+	_ Limiter = (*AdmissionController)(nil)
+)
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitErrorAs(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{PollInterval: time.Millisecond})
+	rl.AllowN("key", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := rl.AllowCtx(ctx, "key")
+
+	// Wrapped as it would be deep in a caller's stack.
+	err = fmt.Errorf("sync batch: %w", err)
+	var limitErr *RateLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("err = %v, want a *RateLimitError", err)
+	}
+	if limitErr.Key != "key" || limitErr.Limit != 2 || limitErr.Remaining != 0 || limitErr.RetryAfter != 30*time.Second {
+		t.Errorf("RateLimitError = %+v", limitErr)
+	}
+	if limitErr.ResetAt.IsZero() {
+		t.Error("RateLimitError has no ResetAt")
+	}
+	if !errors.Is(err, ErrRateLimited) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want it to match ErrRateLimited and the context's error", err)
+	}
+	if !strings.Contains(err.Error(), `"key"`) {
+		t.Errorf("Error() = %q, want it to name the key", err.Error())
+	}
+
+	if _, err := rl.Reserve(ctx, "key", 1); !errors.As(err, &limitErr) {
+		t.Errorf("Reserve err = %v, want a *RateLimitError", err)
+	}
+
+	immediate := newRateLimitError("key", Result{Limit: 2}, nil)
+	if !errors.Is(immediate, ErrRateLimited) || errors.Unwrap(immediate) != nil {
+		t.Errorf("immediate denial unwraps to %v", errors.Unwrap(immediate))
+	}
+}
+
+// rateLimitErrorLimiter denies every request with a *RateLimitError, as
+// waiting limiters do when the request context ends.
+type rateLimitErrorLimiter struct {
+	result Result
+}
+
+func (l rateLimitErrorLimiter) Allow(key string) (Result, error) {
+	return l.AllowN(key, 1)
+}
+
+func (l rateLimitErrorLimiter) AllowN(key string, n int) (Result, error) {
+	return Result{}, newRateLimitError(key, l.result, context.Canceled)
+}
+
+func (l rateLimitErrorLimiter) Peek(key string) Result {
+	return l.result
+}
+
+func TestRateLimiterMiddlewareRateLimitError(t *testing.T) {
+	resetAt := time.Unix(1_700_000_060, 0)
+	limiter := rateLimitErrorLimiter{Result{Limit: 7, Remaining: 0, ResetAt: resetAt, RetryAfter: 3 * time.Second}}
+	rec := serve(NewRateLimiterMiddleware(okHandler, limiter, APIKeyExtractor), "apikey123")
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	headers := map[string]string{
+		"X-RateLimit-Limit":     "7",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     "1700000060",
+		"Retry-After":           "3",
+	}
+	for name, want := range headers {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q from the error", name, got, want)
+		}
+	}
+}
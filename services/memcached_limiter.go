@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type MemcachedOptions struct {
+	FailMode   FailMode
+	Logger     *slog.Logger
+	Registerer prometheus.Registerer
+}
+
+// MemcachedLimiter is a fixed-window limiter whose counters live in
+// Memcached, for deployments that do not run Redis.
+type MemcachedLimiter struct {
+	client      *memcache.Client
+	maxLimit    int
+	window      time.Duration
+	keyPrefix   string
+	failMode    FailMode
+	logger      *slog.Logger
+	storeErrors prometheus.Counter
+}
+
+func NewMemcachedLimiter(client *memcache.Client, maxLimit int, windowDuration time.Duration, keyPrefix string) *MemcachedLimiter {
+	return NewMemcachedLimiterWithOptions(client, maxLimit, windowDuration, keyPrefix, MemcachedOptions{})
+}
+
+func NewMemcachedLimiterWithOptions(client *memcache.Client, maxLimit int, windowDuration time.Duration, keyPrefix string, opts MemcachedOptions) *MemcachedLimiter {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	ml := &MemcachedLimiter{
+		client:    client,
+		maxLimit:  maxLimit,
+		window:    windowDuration,
+		keyPrefix: keyPrefix,
+		failMode:  opts.FailMode,
+		logger:    opts.Logger,
+		storeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limiter_store_errors_total",
+			Help: "Rate limit checks that failed to reach the backing store.",
+		}),
+	}
+
+	if opts.Registerer != nil {
+		ml.storeErrors = registerCounter(opts.Registerer, ml.storeErrors)
+	}
+
+	return ml
+}
+
+func (ml *MemcachedLimiter) Allow(apiKey string) (Result, error) {
+	return ml.AllowN(apiKey, 1)
+}
+
+func (ml *MemcachedLimiter) AllowN(apiKey string, n int) (Result, error) {
+	windowStart := time.Now().Truncate(ml.window)
+	resetAt := windowStart.Add(ml.window)
+	if n < 1 {
+		return Result{Limit: ml.maxLimit, Window: ml.window, ResetAt: resetAt}, errInvalidCost(n)
+	}
+	if n > ml.maxLimit {
+		return Result{Limit: ml.maxLimit, Window: ml.window, ResetAt: resetAt}, errCostExceedsLimit(n, ml.maxLimit)
+	}
+
+	key := ml.counterKey(apiKey, windowStart)
+	count, err := ml.increment(key, n)
+	if err != nil {
+		return ml.fail(apiKey, resetAt, err)
+	}
+
+	allowed := count <= uint64(ml.maxLimit)
+	if !allowed {
+		// Give the cost back so a denied request does not eat into the
+		// window; the counter can never go below what was allowed.
+		if current, err := ml.client.Decrement(key, uint64(n)); err == nil {
+			count = current
+		}
+	}
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     ml.maxLimit,
+		Window:    ml.window,
+		Remaining: max(ml.maxLimit-int(count), 0),
+		ResetAt:   resetAt,
+	}
+	if !allowed {
+		result.RetryAfter = time.Until(resetAt)
+	}
+
+	return result, nil
+}
+
+func (ml *MemcachedLimiter) Peek(apiKey string) Result {
+	windowStart := time.Now().Truncate(ml.window)
+	resetAt := windowStart.Add(ml.window)
+
+	count := 0
+	item, err := ml.client.Get(ml.counterKey(apiKey, windowStart))
+	if err == nil {
+		count, err = strconv.Atoi(strings.TrimSpace(string(item.Value)))
+	}
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		result, _ := ml.fail(apiKey, resetAt, err)
+		return result
+	}
+
+	result := Result{
+		Allowed:   count < ml.maxLimit,
+		Limit:     ml.maxLimit,
+		Window:    ml.window,
+		Remaining: max(ml.maxLimit-count, 0),
+		ResetAt:   resetAt,
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Until(resetAt)
+	}
+
+	return result
+}
+
+// HealthCheck pings every memcached server. The client takes no context,
+// so ctx is only checked before the ping starts.
+func (ml *MemcachedLimiter) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ml.client.Ping()
+}
+
+// increment bumps the window counter by n, creating it on first use. Add
+// fails with ErrNotStored when another instance created the counter between
+// our Increment and Add, in which case the second Increment wins.
+func (ml *MemcachedLimiter) increment(key string, n int) (uint64, error) {
+	count, err := ml.client.Increment(key, uint64(n))
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return count, err
+	}
+
+	err = ml.client.Add(&memcache.Item{
+		Key:        key,
+		Value:      []byte(strconv.Itoa(n)),
+		Expiration: int32(max(ml.window/time.Second, 1)),
+	})
+	if err == nil {
+		return uint64(n), nil
+	}
+	if !errors.Is(err, memcache.ErrNotStored) {
+		return 0, err
+	}
+
+	return ml.client.Increment(key, uint64(n))
+}
+
+func (ml *MemcachedLimiter) counterKey(apiKey string, windowStart time.Time) string {
+	return ml.keyPrefix + apiKey + keySeparator + strconv.FormatInt(windowStart.Unix(), 10)
+}
+
+func (ml *MemcachedLimiter) fail(apiKey string, resetAt time.Time, err error) (Result, error) {
+	ml.storeErrors.Inc()
+	ml.logger.Error("memcached rate limiter unavailable",
+		"key", apiKey,
+		"prefix", ml.keyPrefix,
+		"fail_open", ml.failMode == FailOpen,
+		"error", err,
+	)
+
+	if ml.failMode == FailOpen {
+		return Result{Allowed: true, Limit: ml.maxLimit, Window: ml.window, Remaining: ml.maxLimit, ResetAt: time.Now()}, nil
+	}
+
+	return Result{Limit: ml.maxLimit, Window: ml.window, ResetAt: resetAt, RetryAfter: time.Until(resetAt)}, fmt.Errorf("memcached rate limiter: %w", err)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unknownPlan labels keys when no PlanExtractor is set or it returns "".
+const unknownPlan = "unknown"
+
+type MetricsOptions struct {
+	// PlanExtractor maps a key to its plan, such as the Plan in its
+	// apistore.APIKeyConfig. Metrics are labelled by plan rather than key so
+	// keys neither leak into the metrics nor create a series each.
+	PlanExtractor func(key string) string
+}
+
+type MetricsLimiter struct {
+	inner     Limiter
+	plan      func(key string) string
+	requests  *prometheus.CounterVec
+	remaining *prometheus.GaugeVec
+	duration  *prometheus.HistogramVec
+}
+
+func NewMetricsLimiter(inner Limiter, reg prometheus.Registerer) *MetricsLimiter {
+	return NewMetricsLimiterWithOptions(inner, reg, MetricsOptions{})
+}
+
+func NewMetricsLimiterWithOptions(inner Limiter, reg prometheus.Registerer, opts MetricsOptions) *MetricsLimiter {
+	ml := &MetricsLimiter{
+		inner: inner,
+		plan:  opts.PlanExtractor,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limiter_requests_total",
+			Help: "Rate limit decisions by plan and result.",
+		}, []string{"plan", "result"}),
+		remaining: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rate_limiter_tokens_remaining",
+			Help: "Tokens left for the key of the plan's last decision.",
+		}, []string{"plan"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rate_limiter_allow_duration_seconds",
+			Help:    "Time spent deciding whether to allow a request.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"plan"}),
+	}
+
+	reg.MustRegister(ml.requests, ml.remaining, ml.duration)
+	return ml
+}
+
+func (ml *MetricsLimiter) Allow(key string) (Result, error) {
+	start := time.Now()
+	result, err := ml.inner.Allow(key)
+	ml.observe(key, start, result)
+	return result, err
+}
+
+func (ml *MetricsLimiter) AllowN(key string, n int) (Result, error) {
+	start := time.Now()
+	result, err := ml.inner.AllowN(key, n)
+	ml.observe(key, start, result)
+	return result, err
+}
+
+func (ml *MetricsLimiter) Peek(key string) Result {
+	return ml.inner.Peek(key)
+}
+
+func (ml *MetricsLimiter) observe(key string, start time.Time, result Result) {
+	plan := ml.planFor(key)
+	ml.duration.WithLabelValues(plan).Observe(time.Since(start).Seconds())
+
+	outcome := "denied"
+	if result.Allowed {
+		outcome = "allowed"
+	}
+	ml.requests.WithLabelValues(plan, outcome).Inc()
+	ml.remaining.WithLabelValues(plan).Set(float64(result.Remaining))
+}
+
+func (ml *MetricsLimiter) planFor(key string) string {
+	if ml.plan == nil {
+		return unknownPlan
+	}
+	if plan := ml.plan(key); plan != "" {
+		return plan
+	}
+
+	return unknownPlan
+}
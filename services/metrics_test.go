@@ -0,0 +1,120 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	apistore "rate-limiter/api-store"
+)
+
+func TestMetricsLimiter(t *testing.T) {
+	tests := []struct {
+		name          string
+		planExtractor func(key string) string
+		wantPlan      string
+	}{
+		{name: "no extractor", wantPlan: "unknown"},
+		{name: "empty plan", planExtractor: func(string) string { return "" }, wantPlan: "unknown"},
+		{name: "plan", planExtractor: func(string) string { return "pro" }, wantPlan: "pro"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+			reg := prometheus.NewPedanticRegistry()
+			ml := NewMetricsLimiterWithOptions(rl, reg, MetricsOptions{PlanExtractor: tt.planExtractor})
+
+			ml.Allow("apikey123")
+			ml.AllowN("apikey123", 1)
+			ml.Allow("apikey123")
+
+			plan := `plan="` + tt.wantPlan + `"`
+			want := `
+# HELP rate_limiter_requests_total Rate limit decisions by plan and result.
+# TYPE rate_limiter_requests_total counter
+rate_limiter_requests_total{` + plan + `,result="allowed"} 2
+rate_limiter_requests_total{` + plan + `,result="denied"} 1
+# HELP rate_limiter_tokens_remaining Tokens left for the key of the plan's last decision.
+# TYPE rate_limiter_tokens_remaining gauge
+rate_limiter_tokens_remaining{` + plan + `} 0
+`
+			err := testutil.CollectAndCompare(reg, strings.NewReader(want),
+				"rate_limiter_requests_total", "rate_limiter_tokens_remaining")
+			if err != nil {
+				t.Error(err)
+			}
+
+			if count := testutil.CollectAndCount(reg, "rate_limiter_allow_duration_seconds"); count != 1 {
+				t.Errorf("%d duration series, want 1", count)
+			}
+		})
+	}
+}
+
+func TestMetricsLimiterPlanLabels(t *testing.T) {
+	store := apistore.NewInMemoryStore(nil)
+	plans := map[string]string{
+		"free-1": "free",
+		"free-2": "free",
+		"pro-1":  "pro",
+		"pro-2":  "pro",
+	}
+	for key, plan := range plans {
+		if err := store.RegisterKey(key, apistore.APIKeyConfig{Plan: plan}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	reg := prometheus.NewPedanticRegistry()
+	ml := NewMetricsLimiterWithOptions(rl, reg, MetricsOptions{
+		PlanExtractor: func(key string) string { return store.GetApiKeys()[key].Plan },
+	})
+	for key := range plans {
+		ml.Allow(key)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]bool)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "plan" {
+					labels[label.GetValue()] = true
+				}
+			}
+		}
+	}
+	if len(labels) != 2 || !labels["free"] || !labels["pro"] {
+		t.Errorf("plan label values = %v, want free and pro", labels)
+	}
+
+	want := `
+# HELP rate_limiter_requests_total Rate limit decisions by plan and result.
+# TYPE rate_limiter_requests_total counter
+rate_limiter_requests_total{plan="free",result="allowed"} 2
+rate_limiter_requests_total{plan="pro",result="allowed"} 2
+`
+	if err := testutil.CollectAndCompare(reg, strings.NewReader(want), "rate_limiter_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMetricsLimiterPeekRecordsNothing(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	reg := prometheus.NewPedanticRegistry()
+	ml := NewMetricsLimiter(rl, reg)
+
+	if result := ml.Peek("apikey123"); result.Remaining != 2 {
+		t.Errorf("Peek Remaining = %d, want 2", result.Remaining)
+	}
+	if count := testutil.CollectAndCount(reg); count != 0 {
+		t.Errorf("Peek recorded %d series", count)
+	}
+}
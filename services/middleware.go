@@ -0,0 +1,98 @@
+package services
+
+import "net/http"
+
+// MiddlewareOption configures NewMiddleware.
+type MiddlewareOption func(*middlewareConfig)
+
+type middlewareConfig struct {
+	extractor KeyExtractor
+	opts      Options
+	hooks     *EventHooks
+	dryRun    bool
+}
+
+// Middleware is the rate limiter as an http.Handler wrapper, in the style
+// of http.MaxBytesHandler and http.TimeoutHandler:
+//
+//	mw := services.NewMiddleware(limiter, services.WithExtractor(extractor))
+//	http.Handle("/", mw.Handler(myHandler))
+//
+// One Middleware can wrap any number of handlers, which then share its
+// limiter. Its Handler method can be passed to MiddlewareChain.Before.
+type Middleware struct {
+	limiter   Limiter
+	extractor KeyExtractor
+	opts      Options
+}
+
+// NewMiddleware builds a Middleware. Without options it behaves like
+// RateLimiterMiddleware: keyed on X-API-KEY, with the rate-limit headers
+// and the default denied response.
+func NewMiddleware(limiter Limiter, opts ...MiddlewareOption) *Middleware {
+	cfg := middlewareConfig{extractor: APIKeyExtractor}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.hooks != nil {
+		limiter = WithHooks(limiter, *cfg.hooks)
+	}
+	if cfg.dryRun {
+		logger := cfg.opts.withDefaults().Logger
+		limiter = NewDryRunLimiter(limiter, func(key string, wouldBeDenied bool, result Result) {
+			logger.Info("rate limit dry run would deny request", "key", key, "limit", result.Limit, "remaining", result.Remaining)
+		})
+	}
+
+	return &Middleware{limiter: limiter, extractor: cfg.extractor, opts: cfg.opts}
+}
+
+// Handler rate limits requests to h.
+func (m *Middleware) Handler(h http.Handler) http.Handler {
+	return NewRateLimiterMiddlewareWithOptions(h, m.limiter, m.extractor, m.opts)
+}
+
+// WithOptions sets every Options field at once, for settings without an
+// option of their own. Options given after it still apply on top.
+func WithOptions(opts Options) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.opts = opts
+	}
+}
+
+func WithExtractor(e KeyExtractor) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.extractor = e
+	}
+}
+
+func WithErrorBody(fn ErrorBodyFunc) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.opts.ErrorBody = fn
+	}
+}
+
+// WithHeaders turns the X-RateLimit-* response headers on or off. They are
+// on by default.
+func WithHeaders(enabled bool) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.opts.OmitHeaders = !enabled
+	}
+}
+
+// WithDryRun admits every request while still charging the limiter, and
+// logs the ones it would have denied. See DryRunLimiter.
+func WithDryRun(enabled bool) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.dryRun = enabled
+	}
+}
+
+// WithEventHooks reports the limiter's decisions to h. In a dry run the
+// hooks see the decisions that would have been enforced.
+func WithEventHooks(h EventHooks) MiddlewareOption {
+	return func(cfg *middlewareConfig) {
+		cfg.hooks = &h
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMiddlewareDefaults(t *testing.T) {
+	oldLimiter, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	newLimiter, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	old := RateLimiterMiddleware(okHandler, oldLimiter)
+	handler := NewMiddleware(newLimiter).Handler(okHandler)
+
+	for i, key := range []string{"apikey123", "apikey123", ""} {
+		want, got := serve(old, key), serve(handler, key)
+		if got.Code != want.Code || got.Body.String() != want.Body.String() {
+			t.Errorf("request %d = %d %q, want %d %q", i+1, got.Code, got.Body.String(), want.Code, want.Body.String())
+		}
+		for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"} {
+			if got, want := got.Header().Get(name), want.Header().Get(name); got != want {
+				t.Errorf("request %d: %s = %q, want %q", i+1, name, got, want)
+			}
+		}
+	}
+}
+
+func TestNewMiddlewareOptions(t *testing.T) {
+	errorBody := func(w http.ResponseWriter, r *http.Request, result Result) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}
+
+	tests := []struct {
+		name   string
+		dryRun bool
+		codes  []int
+		bodies []string
+		hooks  []string
+	}{
+		{
+			name:   "enforced",
+			codes:  []int{http.StatusOK, http.StatusTooManyRequests},
+			bodies: []string{"ok", "slow down"},
+			hooks:  []string{"allow", "deny"},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			codes:  []int{http.StatusOK, http.StatusOK},
+			bodies: []string{"ok", "ok"},
+			hooks:  []string{"allow", "deny"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+			hooks, events := collectHooks()
+			mw := NewMiddleware(rl,
+				WithOptions(Options{Logger: slog.New(slog.DiscardHandler)}),
+				WithExtractor(HeaderExtractor("X-Client")),
+				WithErrorBody(errorBody),
+				WithHeaders(false),
+				WithDryRun(tt.dryRun),
+				WithEventHooks(hooks),
+			)
+			handler := mw.Handler(okHandler)
+
+			for i := range tt.codes {
+				req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+				req.Header.Set("X-Client", "apikey123")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.codes[i] || rec.Body.String() != tt.bodies[i] {
+					t.Errorf("request %d = %d %q, want %d %q", i+1, rec.Code, rec.Body.String(), tt.codes[i], tt.bodies[i])
+				}
+				if got := rec.Header().Get("X-RateLimit-Limit"); got != "" {
+					t.Errorf("request %d: X-RateLimit-Limit = %q despite WithHeaders(false)", i+1, got)
+				}
+				if event := nextHookEvent(t, events); event.kind != tt.hooks[i] || event.key != "apikey123" {
+					t.Errorf("request %d: hook event %+v, want %s for the X-Client key", i+1, event, tt.hooks[i])
+				}
+			}
+
+			select {
+			case event := <-events:
+				t.Errorf("unexpected hook event %+v", event)
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// TestMiddlewareHandlerComposes chains
+// http.TimeoutHandler → rate limiter → http.MaxBytesHandler → handler.
+func TestMiddlewareHandlerComposes(t *testing.T) {
+	business := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if string(body) == "slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write(body)
+	})
+
+	rl, _ := newTestRateLimiter(t, 3, 60, LimiterOptions{})
+	mw := NewMiddleware(rl)
+	handler := http.TimeoutHandler(mw.Handler(http.MaxBytesHandler(business, 8)), 50*time.Millisecond, "timed out")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "small body", body: "hello", status: http.StatusOK, want: "hello"},
+		{name: "body over MaxBytes", body: "far too large", status: http.StatusRequestEntityTooLarge},
+		{name: "slow handler", body: "slow", status: http.StatusServiceUnavailable, want: "timed out"},
+		{name: "over the rate limit", body: "hello", status: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(tt.body))
+			req.Header.Set("X-API-KEY", "apikey123")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.want != "" && rec.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+type TenantConfig struct {
+	// TenantLimit caps the tenant's requests across all of its keys.
+	TenantLimit LimitConfig
+	// DefaultKeyLimit applies to each of the tenant's keys unless the key
+	// has its own limit in the API key store.
+	DefaultKeyLimit LimitConfig
+}
+
+// MultiTenantRateLimiter limits each API key within its tenant and the
+// tenant as a whole. Keys are scoped to their tenant, so the same key under
+// two tenants has two buckets.
+type MultiTenantRateLimiter struct {
+	mutex   sync.RWMutex
+	tenants map[string]*tenantLimiters
+}
+
+type tenantLimiters struct {
+	tenant *RateLimiter
+	keys   *RateLimiter
+}
+
+func NewMultiTenantRateLimiter() *MultiTenantRateLimiter {
+	return &MultiTenantRateLimiter{
+		tenants: make(map[string]*tenantLimiters),
+	}
+}
+
+// RegisterTenant adds a tenant or replaces its limits. Replacing them
+// starts the tenant and its keys from full buckets.
+func (ml *MultiTenantRateLimiter) RegisterTenant(tenantID string, cfg TenantConfig) error {
+	tenant, err := NewRateLimiterWithOptions(context.Background(), cfg.TenantLimit.MaxLimit, cfg.TenantLimit.WindowSeconds, LimiterOptions{Burst: cfg.TenantLimit.Burst})
+	if err != nil {
+		return fmt.Errorf("tenant %q limit: %w", tenantID, err)
+	}
+	keys, err := NewRateLimiterWithOptions(context.Background(), cfg.DefaultKeyLimit.MaxLimit, cfg.DefaultKeyLimit.WindowSeconds, LimiterOptions{Burst: cfg.DefaultKeyLimit.Burst})
+	if err != nil {
+		tenant.Stop()
+		return fmt.Errorf("tenant %q key limit: %w", tenantID, err)
+	}
+
+	ml.mutex.Lock()
+	old, exists := ml.tenants[tenantID]
+	ml.tenants[tenantID] = &tenantLimiters{tenant: tenant, keys: keys}
+	ml.mutex.Unlock()
+
+	if exists {
+		old.stop()
+	}
+	return nil
+}
+
+// Allow admits a request only if both the key and its tenant have a token.
+// A key's token is given back when the tenant cap denies the request, so
+// one busy key cannot use up its own quota on requests the tenant refused.
+// The returned Result is the denying one, or otherwise the one with fewer
+// tokens left.
+func (ml *MultiTenantRateLimiter) Allow(tenantID, apiKey string) (Result, error) {
+	ml.mutex.RLock()
+	limiters, exists := ml.tenants[tenantID]
+	ml.mutex.RUnlock()
+	if !exists {
+		return Result{}, fmt.Errorf("%w %q", ErrUnknownTenant, tenantID)
+	}
+
+	keyResult, err := limiters.keys.Allow(apiKey)
+	if err != nil || !keyResult.Allowed {
+		return keyResult, err
+	}
+
+	tenantResult, err := limiters.tenant.Allow(tenantID)
+	if err != nil || !tenantResult.Allowed {
+		limiters.keys.Return(apiKey)
+		return tenantResult, err
+	}
+
+	if moreRestrictive(tenantResult, keyResult) {
+		return tenantResult, nil
+	}
+	return keyResult, nil
+}
+
+func (ml *MultiTenantRateLimiter) Stop() {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+
+	for _, limiters := range ml.tenants {
+		limiters.stop()
+	}
+}
+
+func (tl *tenantLimiters) stop() {
+	tl.tenant.Stop()
+	tl.keys.Stop()
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestMultiTenant(t *testing.T, tenants map[string]TenantConfig) *MultiTenantRateLimiter {
+	t.Helper()
+
+	ml := NewMultiTenantRateLimiter()
+	t.Cleanup(ml.Stop)
+	for tenantID, cfg := range tenants {
+		if err := ml.RegisterTenant(tenantID, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ml
+}
+
+func TestMultiTenantRateLimiterTenantCap(t *testing.T) {
+	ml := newTestMultiTenant(t, map[string]TenantConfig{
+		"acme": {
+			TenantLimit:     LimitConfig{MaxLimit: 100, WindowSeconds: 60},
+			DefaultKeyLimit: LimitConfig{MaxLimit: 100, WindowSeconds: 60},
+		},
+	})
+
+	keys := []string{"key-a", "key-b"}
+	allowed := 0
+	for i := range 150 {
+		result, err := ml.Allow("acme", keys[i%2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed {
+			allowed++
+		}
+		if i == 100 && result.Allowed {
+			t.Errorf("request 101 allowed past the tenant cap: %+v", result)
+		}
+	}
+	if allowed != 100 {
+		t.Errorf("%d of 150 requests allowed, want the tenant cap of 100", allowed)
+	}
+}
+
+func TestMultiTenantRateLimiter(t *testing.T) {
+	ml := newTestMultiTenant(t, map[string]TenantConfig{
+		"acme": {
+			TenantLimit:     LimitConfig{MaxLimit: 3, WindowSeconds: 60},
+			DefaultKeyLimit: LimitConfig{MaxLimit: 2, WindowSeconds: 60},
+		},
+		"globex": {
+			TenantLimit:     LimitConfig{MaxLimit: 10, WindowSeconds: 60},
+			DefaultKeyLimit: LimitConfig{MaxLimit: 2, WindowSeconds: 60},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		tenant  string
+		key     string
+		allowed bool
+	}{
+		{name: "first key", tenant: "acme", key: "key-a", allowed: true},
+		{name: "first key again", tenant: "acme", key: "key-a", allowed: true},
+		{name: "first key over its limit", tenant: "acme", key: "key-a", allowed: false},
+		{name: "second key", tenant: "acme", key: "key-b", allowed: true},
+		{name: "second key over the tenant cap", tenant: "acme", key: "key-b", allowed: false},
+		{name: "same key in another tenant", tenant: "globex", key: "key-a", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ml.Allow(tt.tenant, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed != tt.allowed {
+				t.Errorf("Allow(%q, %q) = %+v, want allowed %v", tt.tenant, tt.key, result, tt.allowed)
+			}
+		})
+	}
+
+	// The tenant cap denial gave key-b's token back.
+	if peek := ml.tenants["acme"].keys.Peek("key-b"); peek.Remaining != 1 {
+		t.Errorf("key-b has %d tokens left, want 1", peek.Remaining)
+	}
+}
+
+func TestMultiTenantRateLimiterErrors(t *testing.T) {
+	ml := newTestMultiTenant(t, map[string]TenantConfig{
+		"acme": {
+			TenantLimit:     LimitConfig{MaxLimit: 3, WindowSeconds: 60},
+			DefaultKeyLimit: LimitConfig{MaxLimit: 2, WindowSeconds: 60},
+		},
+	})
+
+	if _, err := ml.Allow("initech", "key-a"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("Allow for an unregistered tenant: err = %v, want ErrUnknownTenant", err)
+	}
+	if err := ml.RegisterTenant("initech", TenantConfig{DefaultKeyLimit: LimitConfig{MaxLimit: 2, WindowSeconds: 60}}); err == nil {
+		t.Error("RegisterTenant accepted a zero tenant limit")
+	}
+	if _, err := ml.Allow("initech", "key-a"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("failed RegisterTenant still registered the tenant: err = %v", err)
+	}
+}
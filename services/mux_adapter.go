@@ -0,0 +1,28 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// routeVarLookups are filled in by the router adapters compiled in with
+// the chi and gorillamux build tags.
+var routeVarLookups []func(r *http.Request, name string) string
+
+// RouteVarExtractor keys on a path variable such as userID in
+// /users/{userID}. It reads patterns registered with http.ServeMux and,
+// when built with the matching tags, chi and gorilla/mux routes.
+func RouteVarExtractor(varName string) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		if value := r.PathValue(varName); value != "" {
+			return value, nil
+		}
+		for _, lookup := range routeVarLookups {
+			if value := lookup(r, varName); value != "" {
+				return value, nil
+			}
+		}
+
+		return "", fmt.Errorf("%w %q", ErrMissingRouteVar, varName)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build chi
+
+package services
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func init() {
+	routeVarLookups = append(routeVarLookups, chi.URLParam)
+}
+
+// ChiMiddleware rate limits the chi routes it is attached to. chi resolves
+// URL parameters only after routing, so attach it with With, Group or Route
+// when the extractor reads one; a top-level Use runs before any match.
+func ChiMiddleware(limiter Limiter, extractor KeyExtractor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return NewRateLimiterMiddleware(next, limiter, extractor)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build gorillamux
+
+package services
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	routeVarLookups = append(routeVarLookups, func(r *http.Request, name string) string {
+		return mux.Vars(r)[name]
+	})
+}
+
+// GorillaMuxMiddleware rate limits every route of a gorilla/mux router it
+// is Use'd on.
+func GorillaMuxMiddleware(limiter Limiter, extractor KeyExtractor) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return NewRateLimiterMiddleware(next, limiter, extractor)
+	}
+}
@@ -0,0 +1,53 @@
+//go:build openapi
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const rateLimitExtension = "x-ratelimit"
+
+// AnnotateSpec documents each route's limit as an x-ratelimit extension on
+// the matching path item of spec, for tools such as Swagger UI to display.
+// Routes are matched against the spec's path templates exactly; every route
+// must exist in the spec.
+func AnnotateSpec(spec *openapi3.T, limitersByRoute map[string]LimiterConfig) error {
+	if spec == nil || spec.Paths == nil {
+		return errors.New("openapi spec has no paths")
+	}
+
+	var missing []string
+	for route, cfg := range limitersByRoute {
+		item := spec.Paths.Value(route)
+		if item == nil {
+			missing = append(missing, route)
+			continue
+		}
+
+		algorithm := cfg.Algorithm
+		if algorithm == "" {
+			algorithm = TokenBucket
+		}
+
+		if item.Extensions == nil {
+			item.Extensions = make(map[string]any)
+		}
+		item.Extensions[rateLimitExtension] = map[string]any{
+			"limit":     cfg.MaxLimit,
+			"window":    cfg.Window.String(),
+			"algorithm": string(algorithm),
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("routes not in openapi spec: %v", missing)
+	}
+
+	return nil
+}
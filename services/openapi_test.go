@@ -0,0 +1,82 @@
+//go:build openapi
+
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+func newTestSpec() *openapi3.T {
+	return &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info:    &openapi3.Info{Title: "rate-limiter", Version: "1.0.0"},
+		Paths: openapi3.NewPaths(
+			openapi3.WithPath("/hello", &openapi3.PathItem{Get: &openapi3.Operation{Responses: openapi3.NewResponses()}}),
+			openapi3.WithPath("/world", &openapi3.PathItem{Get: &openapi3.Operation{Responses: openapi3.NewResponses()}}),
+		),
+	}
+}
+
+func TestAnnotateSpecRoundTrip(t *testing.T) {
+	spec := newTestSpec()
+	err := AnnotateSpec(spec, map[string]LimiterConfig{
+		"/hello": {MaxLimit: 10, Window: time.Minute},
+		"/world": {MaxLimit: 100, Window: time.Hour, Algorithm: GCRA},
+	})
+	if err != nil {
+		t.Fatalf("AnnotateSpec: %v", err)
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatalf("marshal spec: %v", err)
+	}
+	loaded, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		t.Fatalf("load annotated spec: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/hello", want: `{"algorithm":"token_bucket","limit":10,"window":"1m0s"}`},
+		{path: "/world", want: `{"algorithm":"gcra","limit":100,"window":"1h0m0s"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			extension, found := loaded.Paths.Value(tt.path).Extensions[rateLimitExtension]
+			if !found {
+				t.Fatalf("%s has no %s extension in %s", tt.path, rateLimitExtension, data)
+			}
+			got, _ := json.Marshal(extension)
+			if string(got) != tt.want {
+				t.Errorf("%s = %s, want %s", rateLimitExtension, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotateSpecMissingRoute(t *testing.T) {
+	spec := newTestSpec()
+	err := AnnotateSpec(spec, map[string]LimiterConfig{
+		"/hello":   {MaxLimit: 10, Window: time.Minute},
+		"/missing": {MaxLimit: 10, Window: time.Minute},
+	})
+	if err == nil || !strings.Contains(err.Error(), "/missing") {
+		t.Errorf("err = %v, want it to name /missing", err)
+	}
+	if _, found := spec.Paths.Value("/hello").Extensions[rateLimitExtension]; !found {
+		t.Error("routes in the spec not annotated when another is missing")
+	}
+
+	if err := AnnotateSpec(&openapi3.T{}, nil); err == nil {
+		t.Error("spec without paths accepted")
+	}
+}
@@ -0,0 +1,75 @@
+//go:build otel
+
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	traceRequest = func(r *http.Request) *http.Request {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		return r.WithContext(ctx)
+	}
+}
+
+type TracingLimiter struct {
+	inner  Limiter
+	tracer trace.Tracer
+}
+
+func NewTracingLimiter(inner Limiter, tracer trace.Tracer) *TracingLimiter {
+	return &TracingLimiter{inner: inner, tracer: tracer}
+}
+
+func (tl *TracingLimiter) Allow(key string) (Result, error) {
+	return tl.AllowContext(context.Background(), key)
+}
+
+func (tl *TracingLimiter) AllowN(key string, n int) (Result, error) {
+	return tl.AllowNContext(context.Background(), key, n)
+}
+
+func (tl *TracingLimiter) AllowContext(ctx context.Context, key string) (Result, error) {
+	_, span := tl.tracer.Start(ctx, "ratelimiter.Allow")
+	defer span.End()
+
+	result, err := tl.inner.Allow(key)
+	annotate(span, key, result)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return result, err
+}
+
+func (tl *TracingLimiter) AllowNContext(ctx context.Context, key string, n int) (Result, error) {
+	_, span := tl.tracer.Start(ctx, "ratelimiter.AllowN", trace.WithAttributes(attribute.Int("ratelimiter.cost", n)))
+	defer span.End()
+
+	result, err := tl.inner.AllowN(key, n)
+	annotate(span, key, result)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return result, err
+}
+
+func (tl *TracingLimiter) Peek(key string) Result {
+	return tl.inner.Peek(key)
+}
+
+func annotate(span trace.Span, key string, result Result) {
+	span.SetAttributes(
+		attribute.String("ratelimiter.key", key),
+		attribute.Bool("ratelimiter.allowed", result.Allowed),
+		attribute.Int("ratelimiter.remaining", result.Remaining),
+	)
+}
+
+var _ Limiter = (*TracingLimiter)(nil)
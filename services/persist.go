@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func WithSnapshot(path string, interval time.Duration) Option {
+	return func(opts *LimiterOptions) {
+		opts.SnapshotPath = path
+		opts.PersistInterval = interval
+	}
+}
+
+func WithSnapshotSerializer(serializer Serializer) Option {
+	return func(opts *LimiterOptions) {
+		opts.SnapshotSerializer = serializer
+	}
+}
+
+// persistLoop writes the buckets to path every interval, and once more when
+// the limiter stops so a clean shutdown loses nothing.
+func (rl *RateLimiter) persistLoop(ctx context.Context, path string, interval time.Duration) {
+	defer close(rl.persisted)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			rl.persist(path)
+			return
+		case <-ticker.C:
+			rl.persist(path)
+		}
+	}
+}
+
+func (rl *RateLimiter) persist(path string) {
+	if err := rl.writeSnapshot(path); err != nil {
+		rl.logger.Error("rate limiter snapshot failed", "path", path, "error", err)
+	}
+}
+
+func (rl *RateLimiter) writeSnapshot(path string) error {
+	rl.mutex.Lock()
+	buckets := make(map[string]*RequestMetadata, len(rl.requests))
+	for key, metadata := range rl.requests {
+		copied := *metadata
+		buckets[key] = &copied
+	}
+	rl.mutex.Unlock()
+
+	data, err := rl.serializer.Marshal(buckets)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// loadSnapshot restores buckets saved by a previous process. Stale buckets
+// would be full by now, so they are left out.
+func (rl *RateLimiter) loadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	buckets, err := rl.serializer.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("parse snapshot %s: %w", path, err)
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.clock.Now()
+	for key, metadata := range buckets {
+		if rl.stale(key, metadata, now) {
+			continue
+		}
+
+		if _, exists := rl.requests[key]; !exists {
+			rl.stats.activeKeys.Add(1)
+		}
+		rl.requests[key] = metadata
+	}
+
+	return nil
+}
+
+// Export encodes every bucket as JSON, in the same format as snapshot
+// files, so another instance can take over without handing every client a
+// full bucket.
+func (rl *RateLimiter) Export() ([]byte, error) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return JSONSerializer{}.Marshal(rl.requests)
+}
+
+// Import merges buckets produced by Export. Buckets this limiter already
+// tracks are kept as they are, since they reflect more recent traffic.
+func (rl *RateLimiter) Import(data []byte) error {
+	buckets, err := JSONSerializer{}.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("import buckets: %w", err)
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for key, metadata := range buckets {
+		if _, exists := rl.requests[key]; exists {
+			continue
+		}
+
+		rl.requests[key] = metadata
+		rl.stats.activeKeys.Add(1)
+	}
+
+	return nil
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory, so a crash mid-write leaves the previous file intact.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSnapshotRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.json")
+	clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+	newLimiter := func() *RateLimiter {
+		rl, err := NewRateLimiterWithOptions(context.Background(), 5, 60, LimiterOptions{
+			Clock:        clock,
+			Logger:       slog.New(slog.DiscardHandler),
+			SnapshotPath: path,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(rl.Stop)
+		return rl
+	}
+
+	rl := newLimiter()
+	rl.AllowN("spent", 3)
+	rl.Allow("idle")
+	clock.Advance(30 * time.Second)
+	rl.AllowN("spent", 2)
+	if err := rl.writeSnapshot(path); err != nil {
+		t.Fatalf("writeSnapshot: %v", err)
+	}
+
+	// idle was last seen 31s ago, so another 30 make it older than the
+	// 60s window and it is left out on restore.
+	clock.Advance(30*time.Second + time.Second)
+	restored := newLimiter()
+	if stats := restored.Stats(); stats.ActiveKeys != 1 {
+		t.Errorf("restored %d buckets, want only the fresh one", stats.ActiveKeys)
+	}
+	if peek, want := restored.Peek("spent"), rl.Peek("spent"); peek.Remaining != want.Remaining || peek.Remaining == 5 {
+		t.Errorf("restored bucket = %+v, want %d remaining as in the original", peek, want.Remaining)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("snapshot file: %v", err)
+	}
+}
+
+func TestRateLimiterSnapshotCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{SnapshotPath: path})
+	if peek := rl.Peek("key"); !peek.Allowed || peek.Remaining != 5 {
+		t.Errorf("Peek after a corrupt snapshot = %+v, want a fresh bucket", peek)
+	}
+}
+
+func TestRateLimiterPersistInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.json")
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{SnapshotPath: path, PersistInterval: 10 * time.Millisecond})
+	rl.Allow("key")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot written within a second")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Stop writes one last snapshot before it returns.
+	rl.Allow("late")
+	rl.Stop()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := JSONSerializer{}.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("parse snapshot: %v", err)
+	}
+	if _, saved := buckets["late"]; !saved {
+		t.Error("snapshot written by Stop is missing the last bucket")
+	}
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestRateLimiterExportImport(t *testing.T) {
+	old, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	old.AllowN("denied", 2)
+	old.Allow("partial")
+
+	data, err := old.Export()
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	fresh, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	fresh.AllowN("partial", 2)
+	if err := fresh.Import(data); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if result, _ := fresh.Allow("denied"); result.Allowed {
+		t.Errorf("exported key with no tokens allowed after Import: %+v", result)
+	}
+	// fresh's own, more recent, state wins over the import.
+	if peek := fresh.Peek("partial"); peek.Remaining != 0 {
+		t.Errorf("bucket fresh already tracked = %+v, want it kept at 0", peek)
+	}
+	if stats := fresh.Stats(); stats.ActiveKeys != 2 {
+		t.Errorf("ActiveKeys = %d after Import, want 2", stats.ActiveKeys)
+	}
+
+	if err := fresh.Import([]byte("not json")); err == nil {
+		t.Error("Import accepted malformed data")
+	}
+}
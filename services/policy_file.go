@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+)
+
+// PolicyFileLoader keeps a RateLimiter's per-key overrides in step with a
+// TOML file such as:
+//
+//	[keys."abc123"]
+//	maxLimit = 100
+//	window = "1m"
+//	burst = 10
+//
+// The file is re-read whenever it is written or replaced. Keys that appear
+// get an override, keys whose policy changes get the new one, and keys that
+// disappear are Reset. A file that fails to parse or validate is ignored
+// as a whole and the previous policies stay in effect.
+type PolicyFileLoader struct {
+	path     string
+	limiter  *RateLimiter
+	onReload func(added, changed, removed []string)
+	logger   *slog.Logger
+	watcher  *fsnotify.Watcher
+	mutex    sync.Mutex
+	policies map[string]LimitConfig
+}
+
+type policyFile struct {
+	Keys map[string]keyPolicy `toml:"keys"`
+}
+
+type keyPolicy struct {
+	MaxLimit int    `toml:"maxLimit"`
+	Window   string `toml:"window"`
+	Burst    int    `toml:"burst"`
+}
+
+// NewPolicyFileLoader applies path to limiter and watches it for changes
+// until Stop. onReload, if not nil, is called after every successful load,
+// including the first.
+func NewPolicyFileLoader(path string, limiter *RateLimiter, onReload func(added, changed, removed []string)) (*PolicyFileLoader, error) {
+	pl := &PolicyFileLoader{
+		path:     path,
+		limiter:  limiter,
+		onReload: onReload,
+		logger:   slog.Default(),
+		policies: make(map[string]LimitConfig),
+	}
+	if err := pl.Reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directory rather than the file: editors and config
+	// management often replace the file by renaming a new one over it,
+	// which would end a watch on the old inode.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	pl.watcher = watcher
+
+	go pl.watch()
+	return pl, nil
+}
+
+func (pl *PolicyFileLoader) Stop() error {
+	return pl.watcher.Close()
+}
+
+// Reload reads the file now and applies the difference from the policies
+// loaded last.
+func (pl *PolicyFileLoader) Reload() error {
+	policies, err := loadPolicyFile(pl.path)
+	if err != nil {
+		return err
+	}
+
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	var added, changed, removed []string
+	for key, cfg := range policies {
+		old, exists := pl.policies[key]
+		if exists && old == cfg {
+			continue
+		}
+		if err := pl.limiter.SetLimitConfig(key, cfg); err != nil {
+			return fmt.Errorf("policy for %q: %w", key, err)
+		}
+
+		if exists {
+			changed = append(changed, key)
+		} else {
+			added = append(added, key)
+		}
+	}
+	for key := range pl.policies {
+		if _, exists := policies[key]; !exists {
+			if err := pl.limiter.Reset(key); err != nil && !errors.Is(err, ErrUnknownKey) {
+				return err
+			}
+			removed = append(removed, key)
+		}
+	}
+	pl.policies = policies
+
+	if pl.onReload != nil {
+		slices.Sort(added)
+		slices.Sort(changed)
+		slices.Sort(removed)
+		pl.onReload(added, changed, removed)
+	}
+
+	return nil
+}
+
+func (pl *PolicyFileLoader) watch() {
+	name := filepath.Clean(pl.path)
+	for {
+		select {
+		case event, ok := <-pl.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != name || !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+
+			if err := pl.Reload(); err != nil {
+				pl.logger.Error("rate limit policy file not reloaded", "path", pl.path, "error", err)
+			}
+		case err, ok := <-pl.watcher.Errors:
+			if !ok {
+				return
+			}
+			pl.logger.Error("rate limit policy file watch failed", "path", pl.path, "error", err)
+		}
+	}
+}
+
+// loadPolicyFile parses and validates every policy in path before any is
+// applied, so a typo in one key cannot leave the limiter half-updated.
+func loadPolicyFile(path string) (map[string]LimitConfig, error) {
+	var file policyFile
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, fmt.Errorf("parse policy file %s: %w", path, err)
+	}
+
+	policies := make(map[string]LimitConfig, len(file.Keys))
+	for key, policy := range file.Keys {
+		window, err := time.ParseDuration(policy.Window)
+		if err != nil {
+			return nil, fmt.Errorf("policy for %q: window: %w", key, err)
+		}
+		if window < time.Second || window%time.Second != 0 {
+			return nil, fmt.Errorf("policy for %q: window must be a whole number of seconds, got %s", key, window)
+		}
+		if policy.MaxLimit <= 0 {
+			return nil, fmt.Errorf("policy for %q: maxLimit must be positive, got %d", key, policy.MaxLimit)
+		}
+		if err := validateBurst(policy.Burst, policy.MaxLimit); err != nil {
+			return nil, fmt.Errorf("policy for %q: %w", key, err)
+		}
+
+		policies[key] = LimitConfig{
+			MaxLimit:      policy.MaxLimit,
+			WindowSeconds: int(window / time.Second),
+			Burst:         policy.Burst,
+		}
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+type policyReload struct {
+	added, changed, removed []string
+}
+
+func writePolicyFile(t *testing.T, path, contents string) {
+	t.Helper()
+
+	// Replace the file in one step, so the watcher never sees it
+	// truncated.
+	if err := writeFileAtomic(path, []byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextReload(t *testing.T, reloads <-chan policyReload) policyReload {
+	t.Helper()
+
+	select {
+	case reload := <-reloads:
+		return reload
+	case <-time.After(2 * time.Second):
+		t.Fatal("policy file not reloaded")
+		return policyReload{}
+	}
+}
+
+func TestPolicyFileLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.toml")
+	writePolicyFile(t, path, `
+[keys."a"]
+maxLimit = 5
+window = "1m"
+
+[keys."b"]
+maxLimit = 20
+window = "1h"
+burst = 10
+`)
+
+	rl, _ := newTestRateLimiter(t, 100, 60, LimiterOptions{})
+	reloads := make(chan policyReload, 4)
+	loader, err := NewPolicyFileLoader(path, rl, func(added, changed, removed []string) {
+		reloads <- policyReload{added, changed, removed}
+	})
+	if err != nil {
+		t.Fatalf("NewPolicyFileLoader: %v", err)
+	}
+	defer loader.Stop()
+
+	if reload := nextReload(t, reloads); !slices.Equal(reload.added, []string{"a", "b"}) {
+		t.Errorf("first load = %+v, want a and b added", reload)
+	}
+	if peek := rl.Peek("a"); peek.Limit != 5 || peek.Window != time.Minute {
+		t.Errorf("a = %+v, want 5 per minute", peek)
+	}
+	if peek := rl.Peek("b"); peek.Limit != 20 || peek.Remaining != 10 {
+		t.Errorf("b = %+v, want 20 per hour with a burst of 10", peek)
+	}
+
+	writePolicyFile(t, path, `
+[keys."a"]
+maxLimit = 50
+window = "1m"
+
+[keys."c"]
+maxLimit = 3
+window = "10s"
+`)
+	reload := nextReload(t, reloads)
+	if !slices.Equal(reload.added, []string{"c"}) || !slices.Equal(reload.changed, []string{"a"}) || !slices.Equal(reload.removed, []string{"b"}) {
+		t.Errorf("reload = %+v, want c added, a changed and b removed", reload)
+	}
+	for key, want := range map[string]int{"a": 50, "b": 100, "c": 3} {
+		if peek := rl.Peek(key); peek.Limit != want {
+			t.Errorf("%s: limit %d after reload, want %d", key, peek.Limit, want)
+		}
+	}
+}
+
+func TestPolicyFileLoaderInvalidFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{name: "not toml", contents: "[keys"},
+		{name: "bad window", contents: "[keys.\"a\"]\nmaxLimit = 5\nwindow = \"soon\""},
+		{name: "fractional window", contents: "[keys.\"a\"]\nmaxLimit = 5\nwindow = \"1500ms\""},
+		{name: "zero limit", contents: "[keys.\"a\"]\nmaxLimit = 0\nwindow = \"1m\""},
+		{name: "burst over limit", contents: "[keys.\"a\"]\nmaxLimit = 5\nwindow = \"1m\"\nburst = 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policies.toml")
+			writePolicyFile(t, path, tt.contents)
+			rl, _ := newTestRateLimiter(t, 100, 60, LimiterOptions{})
+
+			if _, err := NewPolicyFileLoader(path, rl, nil); err == nil {
+				t.Fatal("invalid policy file accepted")
+			}
+			if peek := rl.Peek("a"); peek.Limit != 100 {
+				t.Errorf("a = %+v, want no override applied", peek)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const queuePollInterval = 10 * time.Millisecond
+
+// QueueingLimiter holds requests that inner denies for up to maxWait instead
+// of rejecting them, admitting each key's waiters in arrival order as tokens
+// refill. Once maxQueueDepth requests are waiting for a key, further ones
+// are denied straight away.
+type QueueingLimiter struct {
+	inner    Limiter
+	maxWait  time.Duration
+	maxDepth int
+	mutex    sync.Mutex
+	queues   map[string]*list.List
+	stop     chan struct{}
+	stopped  sync.Once
+}
+
+type queuedRequest struct {
+	n     int
+	ready chan struct{}
+}
+
+func NewQueueingLimiter(inner Limiter, maxWait time.Duration, maxQueueDepth int) *QueueingLimiter {
+	ql := &QueueingLimiter{
+		inner:    inner,
+		maxWait:  maxWait,
+		maxDepth: maxQueueDepth,
+		queues:   make(map[string]*list.List),
+		stop:     make(chan struct{}),
+	}
+
+	go ql.dispatchLoop()
+	return ql
+}
+
+func (ql *QueueingLimiter) Stop() {
+	ql.stopped.Do(func() { close(ql.stop) })
+}
+
+func (ql *QueueingLimiter) Allow(key string) (Result, error) {
+	return ql.AllowNContext(context.Background(), key, 1)
+}
+
+func (ql *QueueingLimiter) AllowN(key string, n int) (Result, error) {
+	return ql.AllowNContext(context.Background(), key, n)
+}
+
+func (ql *QueueingLimiter) AllowCtx(ctx context.Context, key string) (Result, error) {
+	return ql.AllowNContext(ctx, key, 1)
+}
+
+// AllowNContext queues the request if inner denies it, or if others are
+// already queued for key so it cannot jump ahead of them. It returns the
+// last denied result when maxWait passes, and ctx.Err() with it when ctx
+// ends first.
+func (ql *QueueingLimiter) AllowNContext(ctx context.Context, key string, n int) (Result, error) {
+	if n < 1 {
+		// Let inner reject it rather than queue a cost that can never be
+		// admitted.
+		return ql.inner.AllowN(key, n)
+	}
+
+	var (
+		result Result
+		err    error
+	)
+	if !ql.waiting(key) {
+		result, err = ql.inner.AllowN(key, n)
+		if err != nil || result.Allowed {
+			return result, err
+		}
+	} else {
+		result = ql.inner.Peek(key)
+		result.Allowed = false
+	}
+
+	timer := time.NewTimer(ql.maxWait)
+	defer timer.Stop()
+
+	front := false
+	for {
+		request, element, ok := ql.enqueue(key, n, front)
+		if !ok {
+			return result, nil
+		}
+
+		select {
+		case <-request.ready:
+		case <-timer.C:
+			ql.dequeue(key, element)
+			return result, nil
+		case <-ctx.Done():
+			ql.dequeue(key, element)
+			return result, ctx.Err()
+		}
+
+		result, err = ql.inner.AllowN(key, n)
+		if err != nil || result.Allowed {
+			return result, err
+		}
+
+		// Someone took the token between the dispatcher's Peek and our
+		// AllowN; keep our place at the head of the queue.
+		front = true
+	}
+}
+
+func (ql *QueueingLimiter) Peek(key string) Result {
+	return ql.inner.Peek(key)
+}
+
+func (ql *QueueingLimiter) waiting(key string) bool {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	queue, exists := ql.queues[key]
+	return exists && queue.Len() > 0
+}
+
+// enqueue adds a waiter for key, at the front when it is retrying after a
+// wake-up. It fails when the queue is full; a retrying waiter already
+// counted towards the depth, so it is never turned away.
+func (ql *QueueingLimiter) enqueue(key string, n int, front bool) (*queuedRequest, *list.Element, bool) {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	queue, exists := ql.queues[key]
+	if !exists {
+		queue = list.New()
+		ql.queues[key] = queue
+	}
+	if !front && queue.Len() >= ql.maxDepth {
+		return nil, nil, false
+	}
+
+	request := &queuedRequest{n: n, ready: make(chan struct{})}
+	if front {
+		return request, queue.PushFront(request), true
+	}
+
+	return request, queue.PushBack(request), true
+}
+
+// dequeue removes a waiter that gave up. The dispatcher may have removed it
+// already when waking it, in which case there is nothing to do.
+func (ql *QueueingLimiter) dequeue(key string, element *list.Element) {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	if queue, exists := ql.queues[key]; exists {
+		queue.Remove(element)
+		if queue.Len() == 0 {
+			delete(ql.queues, key)
+		}
+	}
+}
+
+func (ql *QueueingLimiter) dispatchLoop() {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ql.stop:
+			return
+		case <-ticker.C:
+			ql.dispatch()
+		}
+	}
+}
+
+// dispatch wakes the head of each key's queue once inner has tokens for it.
+// Only the head is woken, and it takes the tokens itself, so waiters are
+// admitted in order.
+func (ql *QueueingLimiter) dispatch() {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	for key, queue := range ql.queues {
+		head := queue.Front()
+		if head == nil {
+			delete(ql.queues, key)
+			continue
+		}
+
+		request := head.Value.(*queuedRequest)
+		if ql.inner.Peek(key).Remaining >= request.n {
+			queue.Remove(head)
+			close(request.ready)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func queueLen(ql *QueueingLimiter, key string) int {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	if queue, exists := ql.queues[key]; exists {
+		return queue.Len()
+	}
+	return 0
+}
+
+// waitForQueue polls until key has want waiters queued.
+func waitForQueue(t *testing.T, ql *QueueingLimiter, key string, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for queueLen(ql, key) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", queueLen(ql, key), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newTestQueue wraps a limiter that allows one request a minute and has
+// already spent it, so every request queues until the clock moves.
+func newTestQueue(t *testing.T, maxWait time.Duration, maxQueueDepth int) (*QueueingLimiter, *FakeClock) {
+	t.Helper()
+
+	rl, clock := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+	rl.Allow("key")
+	ql := NewQueueingLimiter(rl, maxWait, maxQueueDepth)
+	t.Cleanup(ql.Stop)
+
+	return ql, clock
+}
+
+func TestQueueingLimiterOrder(t *testing.T) {
+	ql, clock := newTestQueue(t, time.Minute, 10)
+
+	admitted := make(chan int, 3)
+	for i := range 3 {
+		go func() {
+			if result, err := ql.Allow("key"); err == nil && result.Allowed {
+				admitted <- i
+			}
+		}()
+		waitForQueue(t, ql, "key", i+1)
+	}
+
+	for want := range 3 {
+		clock.Advance(time.Minute)
+		select {
+		case got := <-admitted:
+			if got != want {
+				t.Errorf("admitted request %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("request %d not admitted after a token refilled", want)
+		}
+	}
+}
+
+func TestQueueingLimiterCancel(t *testing.T) {
+	ql, _ := newTestQueue(t, time.Minute, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := ql.AllowCtx(ctx, "key")
+		done <- err
+	}()
+	waitForQueue(t, ql, "key", 1)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request still queued")
+	}
+	if n := queueLen(ql, "key"); n != 0 {
+		t.Errorf("%d requests queued after the only one was cancelled", n)
+	}
+}
+
+func TestQueueingLimiterMaxDepth(t *testing.T) {
+	ql, _ := newTestQueue(t, time.Minute, 1)
+
+	go ql.Allow("key")
+	waitForQueue(t, ql, "key", 1)
+
+	start := time.Now()
+	result, err := ql.Allow("key")
+	if err != nil || result.Allowed {
+		t.Errorf("request past the queue depth = %+v, %v; want a denial", result, err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("request past the queue depth waited %v, want an immediate denial", waited)
+	}
+}
+
+func TestQueueingLimiterMaxWait(t *testing.T) {
+	ql, _ := newTestQueue(t, 20*time.Millisecond, 10)
+
+	result, err := ql.Allow("key")
+	if err != nil || result.Allowed {
+		t.Errorf("request that outlived MaxWait = %+v, %v; want a denial", result, err)
+	}
+	if n := queueLen(ql, "key"); n != 0 {
+		t.Errorf("%d requests still queued after MaxWait", n)
+	}
+}
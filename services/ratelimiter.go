@@ -1,60 +1,952 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	apistore "rate-limiter/api-store"
 	"sync"
 	"time"
 )
 
 type RateLimiter struct {
-	requests map[string]*RequestMetadata
-	mutex    sync.Mutex
-	maxLimit int
-	timeLImit int
+	requests           map[string]*RequestMetadata
+	mutex              sync.Mutex
+	maxLimit           int
+	timeLImit          int
+	burst              int
+	ttl                time.Duration
+	cancel             context.CancelFunc
+	overrides          map[string]LimitConfig
+	keys               map[string]apistore.APIKeyConfig
+	logger             *slog.Logger
+	allowlist          map[string]struct{}
+	denylist           map[string]struct{}
+	groups             map[string]string
+	clock              Clock
+	poll               time.Duration
+	started            time.Time
+	warmup             time.Duration
+	warmupScale        float64
+	hashKey            func(string) string
+	cacheWindow        time.Duration
+	cache              sync.Map
+	stats              limiterCounters
+	serializer         Serializer
+	onKeyUsed          func(string)
+	jitter             float64
+	refillStrategy     RefillStrategy
+	autoAllowed        map[string]struct{}
+	autoAllowThreshold float64
+	autoAllowWindows   int
+	onAutoAllow        func(string)
+	onAutoRemove       func(string)
+	// persisted is closed once the final snapshot is written, so Stop can
+	// wait for it. It is nil without a snapshot loop.
+	persisted chan struct{}
+	// options are the ones the limiter was built with, for Clone.
+	options LimiterOptions
+}
+
+var (
+	ErrUnknownKey          = errors.New("unknown key")
+	ErrWaitExceedsDeadline = errors.New("wait for token would exceed context deadline")
+)
+
+type LimiterOptions struct {
+	Logger *slog.Logger
+	// Burst caps how many tokens a bucket can hold, independently of the
+	// sustained rate of maxLimit per timeLimit. Zero means maxLimit.
+	Burst int
+	Clock Clock
+	// PollInterval is the first wait between AllowCtx retries. It doubles
+	// after every miss, up to the window duration. Zero means 100ms.
+	PollInterval time.Duration
+	// WarmupDuration and WarmupMultiplier scale every limit, including
+	// per-key overrides, for a while after the limiter is created so a fresh
+	// instance can absorb the traffic that moves to it on start-up.
+	WarmupDuration   time.Duration
+	WarmupMultiplier float64
+	// KeyHasher, when set, is applied to every key before the limiter stores
+	// or looks it up, so raw API keys are not kept in its maps. Keys that
+	// hash alike share a bucket.
+	KeyHasher func(string) string
+	// CacheWindow lets Allow repeat a key's last positive decision for this
+	// long without taking the lock, for throughput under microbursts. The
+	// cached decision takes the bucket's remaining tokens with it and each
+	// hit spends one, so the limit holds as without the cache. While a
+	// decision is cached, the tokens refilled in the meantime are not yet
+	// counted; a hit's Remaining and Snapshot or Export leave them out. Keep
+	// it to a millisecond or so. Zero disables the cache.
+	CacheWindow time.Duration
+	// SnapshotPath, when set, is loaded on start-up and rewritten every
+	// PersistInterval and on Stop, so a restart does not hand every client
+	// a full bucket. Buckets older than the window are not restored.
+	SnapshotPath    string
+	PersistInterval time.Duration
+	// SnapshotSerializer encodes the snapshot file. It defaults to
+	// JSONSerializer.
+	SnapshotSerializer Serializer
+	// OnKeyUsed is called with the key of every Allow, AllowN and AllowCtx,
+	// before hashing, e.g. to feed an apistore.KeyExpirer.
+	OnKeyUsed func(key string)
+	// RefillJitter, between 0 and 1, pushes each denied response's ResetAt
+	// and RetryAfter back by a random part of this fraction of one token's
+	// refill time, so clients throttled together do not all retry at the
+	// same instant. Buckets themselves refill on schedule.
+	RefillJitter float64
+	// RefillStrategy decides how buckets regain tokens. It defaults to
+	// LinearRefill.
+	RefillStrategy RefillStrategy
+	// AutoAllowThreshold and AutoAllowWindows exempt a bucket from the
+	// check once it has used less than this share of its quota for this
+	// many windows in a row, as if it were on the allow list. It goes back
+	// to normal limiting as soon as it uses more than half of a window's
+	// quota. OnAutoAllow and OnAutoRemove, if set, are called in their own
+	// goroutine with the bucket's key when either happens. A bucket evicted
+	// for being idle starts counting again. Zero windows disables it.
+	AutoAllowThreshold float64
+	AutoAllowWindows   int
+	OnAutoAllow        func(key string)
+	OnAutoRemove       func(key string)
+}
+
+type Option func(*LimiterOptions)
+
+func WithKeyUsage(onKeyUsed func(key string)) Option {
+	return func(opts *LimiterOptions) {
+		opts.OnKeyUsed = onKeyUsed
+	}
+}
+
+func WithRefillJitter(jitter float64) Option {
+	return func(opts *LimiterOptions) {
+		opts.RefillJitter = jitter
+	}
+}
+
+func WithRefillStrategy(strategy RefillStrategy) Option {
+	return func(opts *LimiterOptions) {
+		opts.RefillStrategy = strategy
+	}
+}
+
+func WithKeyHasher(h func(string) string) Option {
+	return func(opts *LimiterOptions) {
+		opts.KeyHasher = h
+	}
+}
+
+// SHA256KeyHasher maps a key to the first 16 hex characters of its SHA-256.
+// It keeps keys out of memory dumps; it is not meant to resist collisions.
+func SHA256KeyHasher(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+type LimitConfig struct {
+	MaxLimit      int `json:"maxLimit"`
+	WindowSeconds int `json:"windowSeconds"`
+	Burst         int `json:"burst,omitempty"`
 }
 
 type RequestMetadata struct {
 	lastSeen   time.Time
 	tokenCount int
+	burst      int
+	// granted is what is left of a ForceAllow grant above burst.
+	granted int
+	// usage is shared by copies of the bucket, so SortedKeysByUsage can
+	// read it after releasing the lock.
+	usage  *keyCounters
+	window windowUsage
+}
+
+func NewRateLimiter(maxLimit int, timeLimit int, opts ...Option) *RateLimiter {
+	return NewRateLimiterWithContext(context.Background(), maxLimit, timeLimit, opts...)
+}
+
+func NewRateLimiterWithContext(ctx context.Context, maxLimit, timeLimit int, opts ...Option) *RateLimiter {
+	var options LimiterOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return newRateLimiter(ctx, maxLimit, timeLimit, options)
+}
+
+func NewRateLimiterWithOptions(ctx context.Context, maxLimit, timeLimit int, opts LimiterOptions) (*RateLimiter, error) {
+	if maxLimit <= 0 {
+		return nil, fmt.Errorf("max limit must be positive, got %d", maxLimit)
+	}
+	if timeLimit <= 0 {
+		return nil, fmt.Errorf("time limit must be positive, got %d", timeLimit)
+	}
+	if err := validateLimiterOptions(maxLimit, opts); err != nil {
+		return nil, err
+	}
+
+	return newRateLimiter(ctx, maxLimit, timeLimit, opts), nil
+}
+
+func validateLimiterOptions(maxLimit int, opts LimiterOptions) error {
+	if err := validateBurst(opts.Burst, maxLimit); err != nil {
+		return err
+	}
+	if opts.WarmupDuration > 0 && opts.WarmupMultiplier < 1 {
+		return fmt.Errorf("warmup multiplier must be at least 1, got %g", opts.WarmupMultiplier)
+	}
+	if opts.RefillJitter < 0 || opts.RefillJitter > 1 {
+		return fmt.Errorf("refill jitter must be between 0 and 1, got %g", opts.RefillJitter)
+	}
+	if opts.AutoAllowThreshold < 0 || opts.AutoAllowThreshold > 1 {
+		return fmt.Errorf("auto-allow threshold must be between 0 and 1, got %g", opts.AutoAllowThreshold)
+	}
+
+	return nil
+}
+
+func newRateLimiter(ctx context.Context, maxLimit, timeLimit int, opts LimiterOptions) *RateLimiter {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+	if opts.KeyHasher == nil {
+		opts.KeyHasher = func(key string) string { return key }
+	}
+	if opts.SnapshotSerializer == nil {
+		opts.SnapshotSerializer = JSONSerializer{}
+	}
+	if opts.RefillStrategy == nil {
+		opts.RefillStrategy = LinearRefill{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	rl := &RateLimiter{
+		requests:           make(map[string]*RequestMetadata),
+		maxLimit:           maxLimit,
+		timeLImit:          timeLimit,
+		burst:              opts.Burst,
+		ttl:                time.Duration(timeLimit) * time.Second,
+		cancel:             cancel,
+		overrides:          make(map[string]LimitConfig),
+		logger:             opts.Logger,
+		allowlist:          make(map[string]struct{}),
+		denylist:           make(map[string]struct{}),
+		groups:             make(map[string]string),
+		clock:              opts.Clock,
+		poll:               opts.PollInterval,
+		started:            opts.Clock.Now(),
+		warmup:             opts.WarmupDuration,
+		warmupScale:        opts.WarmupMultiplier,
+		hashKey:            opts.KeyHasher,
+		cacheWindow:        opts.CacheWindow,
+		serializer:         opts.SnapshotSerializer,
+		onKeyUsed:          opts.OnKeyUsed,
+		jitter:             min(max(opts.RefillJitter, 0), 1),
+		refillStrategy:     opts.RefillStrategy,
+		autoAllowed:        make(map[string]struct{}),
+		autoAllowThreshold: opts.AutoAllowThreshold,
+		autoAllowWindows:   opts.AutoAllowWindows,
+		onAutoAllow:        opts.OnAutoAllow,
+		onAutoRemove:       opts.OnAutoRemove,
+		options:            opts,
+	}
+
+	// Keys registered or revoked through the store, by AdminServer or a
+	// KeyExpirer alike, take effect without a manual Reload.
+	context.AfterFunc(ctx, apistore.Default().Subscribe(rl.Reload))
+	rl.Reload()
+
+	if opts.SnapshotPath != "" {
+		if err := rl.loadSnapshot(opts.SnapshotPath); err != nil {
+			rl.logger.Warn("rate limiter snapshot not restored", "path", opts.SnapshotPath, "error", err)
+		}
+		if opts.PersistInterval > 0 {
+			rl.persisted = make(chan struct{})
+			go rl.persistLoop(ctx, opts.SnapshotPath, opts.PersistInterval)
+		}
+	}
+
+	go rl.evictStale(ctx, time.Duration(timeLimit)*time.Second)
+	return rl
+}
+
+func (rl *RateLimiter) SetTTL(ttl time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.ttl = ttl
+}
+
+func (rl *RateLimiter) SetLimit(apiKey string, maxLimit int, windowSeconds int) error {
+	return rl.SetLimitConfig(apiKey, LimitConfig{MaxLimit: maxLimit, WindowSeconds: windowSeconds})
 }
 
-func NewRateLimiter(maxLimit int, timeLimit int) *RateLimiter {
-	return &RateLimiter{
-		requests: make(map[string]*RequestMetadata),
-		maxLimit: maxLimit,
-		timeLImit: timeLimit,
+func (rl *RateLimiter) SetLimitConfig(apiKey string, cfg LimitConfig) error {
+	if err := validateLimitConfig(cfg); err != nil {
+		return err
 	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.overrides[rl.hashKey(apiKey)] = cfg
+	return nil
+}
+
+// validateLimitConfig rejects overrides the refill arithmetic cannot use:
+// a zero limit or window would divide by zero on the key's next request.
+func validateLimitConfig(cfg LimitConfig) error {
+	if cfg.MaxLimit <= 0 {
+		return fmt.Errorf("max limit must be positive, got %d", cfg.MaxLimit)
+	}
+	if cfg.WindowSeconds <= 0 {
+		return fmt.Errorf("window must be positive, got %d seconds", cfg.WindowSeconds)
+	}
+
+	return validateBurst(cfg.Burst, cfg.MaxLimit)
+}
+
+func validateBurst(burst, maxLimit int) error {
+	if burst < 0 || burst > maxLimit {
+		return fmt.Errorf("burst must be between 0 and max limit %d, got %d", maxLimit, burst)
+	}
+
+	return nil
 }
 
-func (rl *RateLimiter) Allow(apiKey string) bool {
+// Allowlist exempts keys from rate limiting entirely. The allow and deny
+// lists take precedence over per-key overrides, and the deny list wins when
+// a key is on both.
+func (rl *RateLimiter) Allowlist(keys ...string) {
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 
-	metadata, exists := rl.requests[apiKey]
+	for _, key := range keys {
+		rl.allowlist[rl.hashKey(key)] = struct{}{}
+	}
+}
+
+// Denylist rejects every request for keys without tracking a bucket for them.
+func (rl *RateLimiter) Denylist(keys ...string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for _, key := range keys {
+		rl.denylist[rl.hashKey(key)] = struct{}{}
+		rl.dropCached(rl.hashKey(key))
+	}
+}
+
+func (rl *RateLimiter) RemoveFromAllowlist(keys ...string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(rl.allowlist, rl.hashKey(key))
+	}
+}
+
+func (rl *RateLimiter) RemoveFromDenylist(keys ...string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(rl.denylist, rl.hashKey(key))
+	}
+}
+
+// Reset forgets the key's bucket and any admin override so its next request
+// starts from a full bucket. RedisLimiter has no equivalent; DEL the key's
+// Redis entry instead.
+func (rl *RateLimiter) Reset(apiKey string) error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	apiKey = rl.hashKey(apiKey)
+	_, tracked := rl.requests[apiKey]
+	_, overridden := rl.overrides[apiKey]
+	if !tracked && !overridden {
+		return fmt.Errorf("%w %q", ErrUnknownKey, apiKey)
+	}
+
+	if tracked {
+		delete(rl.requests, apiKey)
+		rl.stats.activeKeys.Add(^uint64(0))
+	}
+	delete(rl.overrides, apiKey)
+	delete(rl.autoAllowed, apiKey)
+	rl.dropCached(apiKey)
+	return nil
+}
+
+func (rl *RateLimiter) ResetAll() error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.dropAllCached()
+	rl.requests = make(map[string]*RequestMetadata)
+	rl.overrides = make(map[string]LimitConfig)
+	rl.autoAllowed = make(map[string]struct{})
+	rl.stats.activeKeys.Store(0)
+	return nil
+}
+
+// Group makes keys share the bucket named groupID, so they draw from one
+// quota. Overrides are looked up for the group, not the individual keys.
+func (rl *RateLimiter) Group(groupID string, keys ...string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for _, key := range keys {
+		rl.dropCached(rl.hashKey(key))
+		rl.groups[rl.hashKey(key)] = rl.hashKey(groupID)
+	}
+}
+
+func (rl *RateLimiter) AddKeyToGroup(groupID, key string) {
+	rl.Group(groupID, key)
+}
+
+func (rl *RateLimiter) RemoveKeyFromGroup(groupID, key string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	key = rl.hashKey(key)
+	if rl.groups[key] == rl.hashKey(groupID) {
+		delete(rl.groups, key)
+	}
+}
+
+func (rl *RateLimiter) bucketKey(apiKey string) string {
+	if groupID, grouped := rl.groups[apiKey]; grouped {
+		return groupID
+	}
+
+	return apiKey
+}
+
+// Reload re-reads the per-key limits from the default store. The limiter
+// does this itself whenever the store it was built with changes; call it
+// after apistore.SetDefault swaps in a different store.
+func (rl *RateLimiter) Reload() {
+	keys := hashKeys(apistore.GetApiKeys(), rl.hashKey)
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.keys = keys
+}
+
+// Stop ends the limiter's background work. With a snapshot loop it returns
+// once the final snapshot is on disk.
+func (rl *RateLimiter) Stop() {
+	rl.cancel()
+	if rl.persisted != nil {
+		<-rl.persisted
+	}
+}
+
+func (rl *RateLimiter) Allow(apiKey string) (Result, error) {
+	if rl.cacheWindow <= 0 {
+		return rl.AllowN(apiKey, 1)
+	}
+
+	rl.touch(apiKey)
+	apiKey = rl.hashKey(apiKey)
+	if result, hit := rl.cacheHit(apiKey); hit {
+		rl.stats.record(result)
+		return result, nil
+	}
+
+	result, window, err := rl.allowAndCache(apiKey)
+	return rl.decided(apiKey, result, window, err)
+}
+
+func (rl *RateLimiter) AllowN(apiKey string, n int) (Result, error) {
+	rl.touch(apiKey)
+	apiKey = rl.hashKey(apiKey)
+	result, window, err := rl.allowN(apiKey, n)
+	return rl.decided(apiKey, result, window, err)
+}
+
+// decided counts and logs a decision made for the hashed apiKey.
+func (rl *RateLimiter) decided(apiKey string, result Result, window time.Duration, err error) (Result, error) {
+	rl.stats.record(result)
+	if !result.Allowed {
+		rl.logger.Info("rate limit exceeded",
+			"key", apiKey,
+			"limit", result.Limit,
+			"window", window,
+			"remaining", result.Remaining,
+			"reset_at", result.ResetAt,
+		)
+	}
+
+	return result, err
+}
+
+// AllowCtx waits for a token instead of failing fast, returning a
+// *RateLimitError that wraps ctx.Err() if the context ends first. It is
+// meant for background workers; HTTP handlers should not block on it.
+func (rl *RateLimiter) AllowCtx(ctx context.Context, apiKey string) (Result, error) {
+	rl.touch(apiKey)
+	return rl.waitN(ctx, apiKey, 1)
+}
+
+func (rl *RateLimiter) touch(apiKey string) {
+	if rl.onKeyUsed != nil {
+		rl.onKeyUsed(apiKey)
+	}
+}
+
+// WaitForToken takes a token for key, sleeping until the bucket refills if
+// it is empty, so batch workers can pace themselves instead of handling
+// denials. It gives up at once with ErrWaitExceedsDeadline when the token
+// would arrive after ctx's deadline, and returns ctx.Err() if ctx ends
+// while it sleeps.
+func (rl *RateLimiter) WaitForToken(ctx context.Context, key string) error {
+	key = rl.hashKey(key)
+	for {
+		result, _, err := rl.allowN(key, 1)
+		rl.stats.record(result)
+		if err != nil || result.Allowed {
+			return err
+		}
+
+		wait := max(result.RetryAfter, time.Millisecond)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return fmt.Errorf("%w: next token in %s", ErrWaitExceedsDeadline, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// waitN polls allowN with backoff until it admits n tokens or ctx ends, in
+// which case the error is a *RateLimitError wrapping ctx.Err().
+func (rl *RateLimiter) waitN(ctx context.Context, key string, n int) (Result, error) {
+	apiKey := rl.hashKey(key)
+	wait := rl.poll
+	for {
+		result, window, err := rl.allowN(apiKey, n)
+		if err != nil || result.Allowed {
+			rl.stats.record(result)
+			return result, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			rl.stats.record(result)
+			return result, newRateLimitError(key, result, ctx.Err())
+		case <-timer.C:
+		}
+
+		wait = min(wait*2, max(window, rl.poll))
+	}
+}
+
+// allowN expects apiKey to have been through hashKey already.
+func (rl *RateLimiter) allowN(apiKey string, n int) (Result, time.Duration, error) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return rl.allowNLocked(apiKey, n, rl.clock.Now())
+}
+
+func (rl *RateLimiter) allowNLocked(apiKey string, n int, now time.Time) (Result, time.Duration, error) {
+	bucket := rl.bucketKey(apiKey)
+	limit := rl.limitFor(bucket, now)
+	window := limit.window()
+
+	if n < 1 {
+		return Result{Limit: limit.MaxLimit, Window: window, ResetAt: now}, window, errInvalidCost(n)
+	}
+	if result, listed := rl.listResult(apiKey, limit, now); listed {
+		return result, window, nil
+	}
+
+	rl.dropCached(apiKey)
+	metadata, exists := rl.requests[bucket]
 	if !exists {
-		rl.requests[apiKey] = &RequestMetadata{
-			lastSeen:   time.Now(),
-			tokenCount: rl.maxLimit - 1,
+		metadata = &RequestMetadata{
+			lastSeen:   now,
+			tokenCount: limit.capacity(),
+		}
+		rl.requests[bucket] = metadata
+		rl.stats.activeKeys.Add(1)
+	}
+
+	metadata.burst = limit.capacity()
+	rl.refill(metadata, limit, now)
+
+	if n > metadata.burst {
+		metadata.recordUsage(false, now)
+		return rl.resultFor(false, n, metadata, limit, now), window, errCostExceedsLimit(n, metadata.burst)
+	}
+
+	if rl.trackAutoAllow(bucket, metadata, limit, n, now) {
+		metadata.recordUsage(true, now)
+		return Result{Allowed: true, Limit: limit.MaxLimit, Window: window, Remaining: metadata.tokenCount, ResetAt: now}, window, nil
+	}
+
+	allowed := metadata.tokenCount >= n
+	if allowed {
+		metadata.tokenCount -= n
+	}
+	metadata.recordUsage(allowed, now)
+
+	result := rl.resultFor(allowed, n, metadata, limit, now)
+	if !allowed && rl.jitter > 0 {
+		perToken := limit.window() / time.Duration(limit.MaxLimit)
+		delay := time.Duration(rand.Float64() * rl.jitter * float64(perToken))
+		result.ResetAt = result.ResetAt.Add(delay)
+		result.RetryAfter += delay
+	}
+
+	return result, window, nil
+}
+
+// AllowBatch takes one token for each distinct key under a single lock, for
+// callers such as gateway plugins that decide many keys at once. A key that
+// appears more than once is charged once.
+func (rl *RateLimiter) AllowBatch(keys []string) map[string]Result {
+	results := make(map[string]Result, len(keys))
+
+	rl.mutex.Lock()
+	now := rl.clock.Now()
+	for _, key := range keys {
+		if _, done := results[key]; done {
+			continue
+		}
+
+		results[key], _, _ = rl.allowNLocked(rl.hashKey(key), 1, now)
+	}
+	rl.mutex.Unlock()
+
+	for key, result := range results {
+		rl.touch(key)
+		rl.stats.record(result)
+	}
+
+	return results
+}
+
+// Return hands one token back to the key's bucket, capped at its capacity,
+// for requests that were admitted but should not count, such as those that
+// failed on the server's side.
+func (rl *RateLimiter) Return(apiKey string) {
+	rl.returnN(rl.hashKey(apiKey), 1)
+}
+
+// returnN expects apiKey to have been through hashKey already.
+func (rl *RateLimiter) returnN(apiKey string, n int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if metadata, exists := rl.requests[rl.bucketKey(apiKey)]; exists {
+		metadata.tokenCount = min(metadata.tokenCount+n, max(metadata.burst, metadata.tokenCount))
+	}
+}
+
+// ForceAllow grants key n tokens on top of what it has, outside the normal
+// refill, e.g. after a manual review. The grant may take the bucket up to
+// twice the key's limit. Refill never tops a bucket up past capacity, so
+// the overage lasts until it is spent. A key not seen yet starts from a full
+// bucket, as on its first request, plus n. An n below 1 grants nothing.
+func (rl *RateLimiter) ForceAllow(key string, n int) {
+	if n < 1 {
+		return
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.clock.Now()
+	metadata, limit := rl.forcedBucket(key, now)
+	metadata.tokenCount = min(metadata.tokenCount+n, max(2*limit.MaxLimit, metadata.tokenCount))
+	metadata.granted = max(metadata.tokenCount-metadata.burst, 0)
+}
+
+// ForceDeny empties key's bucket. It refills as usual from now on.
+func (rl *RateLimiter) ForceDeny(key string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.clock.Now()
+	metadata, _ := rl.forcedBucket(key, now)
+	metadata.tokenCount = 0
+	metadata.granted = 0
+	metadata.lastSeen = now
+}
+
+// forcedBucket returns key's bucket with refill applied, creating it full
+// if needed. The caller must hold the lock.
+func (rl *RateLimiter) forcedBucket(key string, now time.Time) (*RequestMetadata, LimitConfig) {
+	rl.dropCached(rl.hashKey(key))
+	bucket := rl.bucketKey(rl.hashKey(key))
+	limit := rl.limitFor(bucket, now)
+
+	metadata, exists := rl.requests[bucket]
+	if !exists {
+		metadata = &RequestMetadata{
+			lastSeen:   now,
+			tokenCount: limit.capacity(),
+		}
+		rl.requests[bucket] = metadata
+		rl.stats.activeKeys.Add(1)
+	}
+
+	metadata.burst = limit.capacity()
+	rl.refill(metadata, limit, now)
+	return metadata, limit
+}
+
+// Capacity is the most tokens a single request from apiKey can spend: its
+// burst if one is set, else its limit.
+func (rl *RateLimiter) Capacity(apiKey string) int {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return rl.limitFor(rl.bucketKey(rl.hashKey(apiKey)), rl.clock.Now()).capacity()
+}
+
+// Peek reports the key's quota with refill applied, without taking a token
+// or creating a bucket for unseen keys.
+func (rl *RateLimiter) Peek(apiKey string) Result {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	apiKey = rl.hashKey(apiKey)
+	now := rl.clock.Now()
+	bucket := rl.bucketKey(apiKey)
+	limit := rl.limitFor(bucket, now)
+	if result, listed := rl.listResult(apiKey, limit, now); listed {
+		return result
+	}
+
+	current := RequestMetadata{lastSeen: now, tokenCount: limit.capacity(), burst: limit.capacity()}
+	if metadata, exists := rl.requests[bucket]; exists {
+		current = *metadata
+		current.burst = limit.capacity()
+		rl.refill(&current, limit, now)
+		if budget := rl.cachedBudget(bucket); budget > 0 {
+			current.tokenCount = min(current.tokenCount+budget, max(current.burst, current.tokenCount))
 		}
-		return true
 	}
 
-	refillRate := float64(rl.maxLimit) / float64(rl.timeLImit)
-	timePassed := time.Since(metadata.lastSeen).Seconds()
-	tokensToAdd := int(timePassed * refillRate)
+	_, autoAllowed := rl.autoAllowed[bucket]
+	return rl.resultFor(current.tokenCount > 0 || autoAllowed, 1, &current, limit, now)
+}
+
+func (rl *RateLimiter) listResult(apiKey string, limit LimitConfig, now time.Time) (Result, bool) {
+	if _, denied := rl.denylist[apiKey]; denied {
+		return Result{Limit: limit.MaxLimit, Window: limit.window(), ResetAt: now, RetryAfter: limit.window()}, true
+	}
+	if _, allowed := rl.allowlist[apiKey]; allowed {
+		return Result{Allowed: true, Limit: limit.MaxLimit, Window: limit.window(), Remaining: limit.capacity(), ResetAt: now}, true
+	}
+
+	return Result{}, false
+}
+
+func (rl *RateLimiter) refill(metadata *RequestMetadata, limit LimitConfig, now time.Time) {
+	elapsed := now.Sub(metadata.lastSeen)
+	tokensToAdd := rl.refillStrategy.TokensToAdd(elapsed, limit.window(), metadata.tokenCount, limit.MaxLimit)
 
 	if tokensToAdd > 0 {
-		metadata.tokenCount = metadata.tokenCount + tokensToAdd
-		metadata.lastSeen = time.Now()
+		metadata.tokenCount = min(metadata.tokenCount+tokensToAdd, max(metadata.burst, metadata.tokenCount))
+		metadata.lastSeen = now
+		if s, ok := rl.refillStrategy.(stepper); ok {
+			metadata.lastSeen = now.Add(-elapsed % s.step())
+		}
 	}
 
-	if metadata.tokenCount > rl.maxLimit {
-		metadata.tokenCount = rl.maxLimit
+	if metadata.tokenCount > metadata.burst+metadata.granted {
+		metadata.tokenCount = metadata.burst + metadata.granted
+	}
+	metadata.granted = max(metadata.tokenCount-metadata.burst, 0)
+}
+
+func (rl *RateLimiter) resultFor(allowed bool, n int, metadata *RequestMetadata, limit LimitConfig, now time.Time) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.MaxLimit,
+		Window:    limit.window(),
+		Remaining: metadata.tokenCount,
+		ResetAt:   rl.resetAt(metadata, limit, now),
 	}
+	if !allowed {
+		result.RetryAfter = rl.retryAfter(metadata, limit, n, now)
+	}
+
+	return result
+}
+
+func (rl *RateLimiter) limitFor(apiKey string, now time.Time) LimitConfig {
+	return rl.limitIn(rl.overrides, rl.keys, apiKey, now)
+}
 
-	if metadata.tokenCount > 0 {
-		metadata.tokenCount--
-		return true
+// limitIn is limitFor reading the given override and key tables, so that
+// callers can copy them under the lock and look limits up after releasing
+// it. Everything else it reads is fixed at construction.
+func (rl *RateLimiter) limitIn(overrides map[string]LimitConfig, keys map[string]apistore.APIKeyConfig, apiKey string, now time.Time) LimitConfig {
+	limit := rl.configIn(overrides, keys, apiKey)
+	if now.Sub(rl.started) < rl.warmup {
+		limit.MaxLimit = int(float64(limit.MaxLimit) * rl.warmupScale)
+		limit.Burst = int(float64(limit.Burst) * rl.warmupScale)
 	}
 
-	return false
-}
\ No newline at end of file
+	return limit
+}
+
+func (rl *RateLimiter) configIn(overrides map[string]LimitConfig, keys map[string]apistore.APIKeyConfig, apiKey string) LimitConfig {
+	if override, exists := overrides[apiKey]; exists {
+		return override
+	}
+
+	limit := LimitConfig{MaxLimit: rl.maxLimit, WindowSeconds: rl.timeLImit, Burst: rl.burst}
+	if cfg, exists := keys[apiKey]; exists {
+		if cfg.MaxLimit > 0 {
+			limit.MaxLimit = cfg.MaxLimit
+			limit.Burst = min(limit.Burst, cfg.MaxLimit)
+		}
+		if cfg.WindowSeconds > 0 {
+			limit.WindowSeconds = cfg.WindowSeconds
+		}
+	}
+
+	return limit
+}
+
+func (l LimitConfig) window() time.Duration {
+	return time.Duration(l.WindowSeconds) * time.Second
+}
+
+func (l LimitConfig) capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return l.MaxLimit
+}
+
+// resetAt is the moment the bucket is full again. Refill is measured from
+// lastSeen, so the missing tokens are counted from there.
+func (rl *RateLimiter) resetAt(metadata *RequestMetadata, limit LimitConfig, now time.Time) time.Time {
+	missing := metadata.burst - metadata.tokenCount
+	if missing <= 0 {
+		return now
+	}
+
+	return rl.refilledAt(metadata, limit, missing)
+}
+
+// retryAfter is how long until n tokens are available. It is computed under
+// the same lock as the decision, so no refill can slip in between.
+func (rl *RateLimiter) retryAfter(metadata *RequestMetadata, limit LimitConfig, n int, now time.Time) time.Duration {
+	missing := n - metadata.tokenCount
+	if missing <= 0 {
+		return 0
+	}
+
+	return max(rl.refilledAt(metadata, limit, missing).Sub(now), 0)
+}
+
+// refilledAt is when metadata will have gained missing more tokens. Linear
+// refill has a closed form; for other strategies it is searched for, to the
+// millisecond.
+func (rl *RateLimiter) refilledAt(metadata *RequestMetadata, limit LimitConfig, missing int) time.Time {
+	if _, linear := rl.refillStrategy.(LinearRefill); linear {
+		perToken := limit.window() / time.Duration(limit.MaxLimit)
+		return metadata.lastSeen.Add(time.Duration(missing) * perToken)
+	}
+
+	gained := func(elapsed time.Duration) bool {
+		return rl.refillStrategy.TokensToAdd(elapsed, limit.window(), metadata.tokenCount, limit.MaxLimit) >= missing
+	}
+
+	low, high := time.Duration(0), limit.window()
+	for i := 0; !gained(high) && i < 16; i++ {
+		low, high = high, high*2
+	}
+	for high-low > time.Millisecond {
+		mid := low + (high-low)/2
+		if gained(mid) {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+
+	return metadata.lastSeen.Add(high)
+}
+
+func hashKeys(keys map[string]apistore.APIKeyConfig, hashKey func(string) string) map[string]apistore.APIKeyConfig {
+	hashed := make(map[string]apistore.APIKeyConfig, len(keys))
+	for key, cfg := range keys {
+		hashed[hashKey(key)] = cfg
+	}
+
+	return hashed
+}
+
+// evictStale drops stale buckets. With linear refill they would be full
+// again on their next request, so evicting them does not change any
+// decision.
+func (rl *RateLimiter) evictStale(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.mutex.Lock()
+			now := rl.clock.Now()
+			for apiKey, metadata := range rl.requests {
+				if rl.stale(apiKey, metadata, now) {
+					rl.dropCached(apiKey)
+					delete(rl.requests, apiKey)
+					rl.stats.activeKeys.Add(^uint64(0))
+					rl.stats.evictedKeys.Add(1)
+					rl.dropAutoAllowed(apiKey)
+				}
+			}
+			rl.mutex.Unlock()
+		}
+	}
+}
+
+// stale reports whether bucket has gone without a refill for longer than
+// the limiter's TTL and its own window, whichever is longer. Using the
+// bucket's window keeps an override or store limit with a longer window
+// than the limiter's from being forgotten while it refills. A bucket
+// holding a ForceAllow grant is never stale. The caller must hold the lock.
+func (rl *RateLimiter) stale(bucket string, metadata *RequestMetadata, now time.Time) bool {
+	ttl := max(rl.ttl, rl.limitFor(bucket, now).window())
+	return metadata.granted == 0 && now.Sub(metadata.lastSeen) > ttl
+}
@@ -0,0 +1,778 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	apistore "rate-limiter/api-store"
+)
+
+func newTestRateLimiter(t *testing.T, maxLimit, timeLimit int, opts LimiterOptions) (*RateLimiter, *FakeClock) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+	opts.Clock = clock
+	opts.Logger = slog.New(slog.DiscardHandler)
+	rl, err := NewRateLimiterWithOptions(context.Background(), maxLimit, timeLimit, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rl.Stop)
+
+	return rl, clock
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxLimit      int
+		timeLimit     int
+		burst         int
+		requests      int
+		advance       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{name: "first request", maxLimit: 5, timeLimit: 60, requests: 1, wantAllowed: true, wantRemaining: 4},
+		{name: "last token", maxLimit: 5, timeLimit: 60, requests: 5, wantAllowed: true, wantRemaining: 0},
+		{name: "no tokens left", maxLimit: 5, timeLimit: 60, requests: 6, wantAllowed: false, wantRemaining: 0},
+		{name: "burst caps the bucket", maxLimit: 5, timeLimit: 60, burst: 2, requests: 3, wantAllowed: false, wantRemaining: 0},
+		{name: "partial refill", maxLimit: 5, timeLimit: 60, requests: 6, advance: 12 * time.Second, wantAllowed: true, wantRemaining: 0},
+		{name: "full refill", maxLimit: 5, timeLimit: 60, requests: 6, advance: time.Hour, wantAllowed: true, wantRemaining: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, clock := newTestRateLimiter(t, tt.maxLimit, tt.timeLimit, LimiterOptions{Burst: tt.burst})
+
+			var result Result
+			var err error
+			for i := 0; i < tt.requests; i++ {
+				if i == tt.requests-1 {
+					clock.Advance(tt.advance)
+				}
+				result, err = rl.Allow("key")
+				if err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+			}
+
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if result.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", result.Remaining, tt.wantRemaining)
+			}
+			if result.Limit != tt.maxLimit {
+				t.Errorf("Limit = %d, want %d", result.Limit, tt.maxLimit)
+			}
+		})
+	}
+}
+
+func TestRateLimiterDenialTimes(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	now := clock.Now()
+
+	for range 5 {
+		rl.Allow("key")
+	}
+	result, _ := rl.Allow("key")
+
+	if result.Allowed {
+		t.Fatal("sixth request allowed")
+	}
+	if result.RetryAfter != 12*time.Second {
+		t.Errorf("RetryAfter = %v, want 12s", result.RetryAfter)
+	}
+	if want := now.Add(time.Minute); !result.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %v, want %v", result.ResetAt, want)
+	}
+}
+
+func TestRateLimiterAllowN(t *testing.T) {
+	tests := []struct {
+		name    string
+		burst   int
+		n       int
+		wantErr error
+	}{
+		{name: "within capacity", n: 5},
+		{name: "zero", n: 0, wantErr: ErrInvalidCost},
+		{name: "negative", n: -1, wantErr: ErrInvalidCost},
+		{name: "over capacity", n: 6, wantErr: ErrCostExceedsCapacity},
+		{name: "at burst", burst: 3, n: 3},
+		{name: "over burst", burst: 3, n: 4, wantErr: ErrCostExceedsCapacity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{Burst: tt.burst})
+
+			result, err := rl.AllowN("key", tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !result.Allowed {
+				t.Error("request denied")
+			}
+			if tt.wantErr != nil && result.Allowed {
+				t.Error("request allowed")
+			}
+			capacity := cmp.Or(tt.burst, 5)
+			if peek := rl.Peek("key"); tt.wantErr != nil && peek.Remaining != capacity {
+				t.Errorf("rejected request took tokens: %d of %d left", peek.Remaining, capacity)
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeysAreIndependent(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{})
+
+	if result, _ := rl.Allow("a"); !result.Allowed {
+		t.Fatal("first request for a denied")
+	}
+	if result, _ := rl.Allow("a"); result.Allowed {
+		t.Fatal("second request for a allowed")
+	}
+	if result, _ := rl.Allow("b"); !result.Allowed {
+		t.Fatal("first request for b denied")
+	}
+}
+
+func TestRateLimiterConcurrentAllow(t *testing.T) {
+	for _, cacheWindow := range []time.Duration{0, time.Hour} {
+		rl, _ := newTestRateLimiter(t, 50, 60, LimiterOptions{CacheWindow: cacheWindow})
+
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		allowed := 0
+		for range 16 {
+			wg.Go(func() {
+				for range 20 {
+					result, err := rl.Allow("key")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if result.Allowed {
+						mutex.Lock()
+						allowed++
+						mutex.Unlock()
+					}
+				}
+			})
+		}
+		wg.Wait()
+
+		if allowed != 50 {
+			t.Errorf("CacheWindow %v: %d requests allowed, want 50", cacheWindow, allowed)
+		}
+		if stats := rl.Stats(); stats.TotalAllowed != 50 || stats.TotalDenied != 270 {
+			t.Errorf("CacheWindow %v: Stats = %+v", cacheWindow, stats)
+		}
+	}
+}
+
+func TestRateLimiterEvictsStaleKeys(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 1, LimiterOptions{})
+
+	rl.Allow("idle")
+	rl.ForceAllow("granted", 10)
+	if active := rl.Stats().ActiveKeys; active != 2 {
+		t.Fatalf("ActiveKeys = %d, want 2", active)
+	}
+
+	clock.Advance(2 * time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for rl.Stats().EvictedKeys == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle key not evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := rl.Stats(); stats.ActiveKeys != 1 || stats.EvictedKeys != 1 {
+		t.Errorf("Stats = %+v, want the granted key kept", stats)
+	}
+	if remaining := rl.Peek("granted").Remaining; remaining != 10 {
+		t.Errorf("granted key has %d tokens, want 10", remaining)
+	}
+}
+
+func TestRateLimiterForceAllow(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	rl.AllowN("apikey123", 5)
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Fatalf("exhausted key allowed: %+v", result)
+	}
+
+	rl.ForceAllow("apikey123", 5)
+	for i := range 5 {
+		if result, _ := rl.Allow("apikey123"); !result.Allowed {
+			t.Fatalf("request %d after ForceAllow denied: %+v", i+1, result)
+		}
+	}
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Errorf("sixth request after ForceAllow(5) allowed: %+v", result)
+	}
+}
+
+func TestRateLimiterForceAllowCap(t *testing.T) {
+	tests := []struct {
+		name   string
+		spent  int
+		grant  int
+		tokens int
+	}{
+		{name: "unseen key", grant: 3, tokens: 8},
+		{name: "capped at twice the limit", grant: 10, tokens: 10},
+		{name: "exhausted key", spent: 5, grant: 2, tokens: 2},
+		{name: "zero grant", spent: 2, grant: 0, tokens: 3},
+		{name: "negative grant", spent: 5, grant: -1, tokens: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			if tt.spent > 0 {
+				rl.AllowN("apikey123", tt.spent)
+			}
+
+			rl.ForceAllow("apikey123", tt.grant)
+			if remaining := rl.Peek("apikey123").Remaining; remaining != tt.tokens {
+				t.Errorf("%d tokens after ForceAllow(%d), want %d", remaining, tt.grant, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestRateLimiterForceDeny(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	rl.ForceAllow("apikey123", 5)
+
+	rl.ForceDeny("apikey123")
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Fatalf("request after ForceDeny allowed: %+v", result)
+	}
+	rl.ForceDeny("apikey124")
+	if result, _ := rl.Allow("apikey124"); result.Allowed {
+		t.Errorf("unseen key allowed after ForceDeny: %+v", result)
+	}
+
+	// The bucket refills as usual from the ForceDeny on.
+	clock.Advance(12 * time.Second)
+	if result, _ := rl.Allow("apikey123"); !result.Allowed {
+		t.Errorf("request a refill interval after ForceDeny denied: %+v", result)
+	}
+}
+
+func TestRateLimiterPeek(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+
+	if peek := rl.Peek("key"); !peek.Allowed || peek.Remaining != 5 {
+		t.Fatalf("Peek of an unseen key = %+v, want a full bucket", peek)
+	}
+	if active := rl.Stats().ActiveKeys; active != 0 {
+		t.Errorf("Peek created a bucket: ActiveKeys = %d", active)
+	}
+
+	rl.AllowN("key", 3)
+	for range 3 {
+		if peek := rl.Peek("key"); peek.Remaining != 2 {
+			t.Fatalf("Peek Remaining = %d, want 2 however often it is called", peek.Remaining)
+		}
+	}
+	if result, _ := rl.Allow("key"); result.Remaining != 1 {
+		t.Errorf("Allow after Peek: Remaining = %d, want 1", result.Remaining)
+	}
+
+	clock.Advance(12 * time.Second)
+	peek := rl.Peek("key")
+	if peek.Remaining != 2 {
+		t.Errorf("Peek after 12s = %d, want the refilled token counted", peek.Remaining)
+	}
+	if result, _ := rl.Allow("key"); result.Remaining != peek.Remaining-1 {
+		t.Errorf("Allow after Peek: Remaining = %d, want %d", result.Remaining, peek.Remaining-1)
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	tests := []struct {
+		name     string
+		burst    int
+		wantErr  bool
+		wantFull int
+	}{
+		{name: "default", burst: 0, wantFull: 60},
+		{name: "below max limit", burst: 3, wantFull: 3},
+		{name: "equal to max limit", burst: 60, wantFull: 60},
+		{name: "above max limit", burst: 61, wantErr: true},
+		{name: "negative", burst: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+			rl, err := NewRateLimiterWithOptions(context.Background(), 60, 60, LimiterOptions{
+				Burst:  tt.burst,
+				Clock:  clock,
+				Logger: slog.New(slog.DiscardHandler),
+			})
+			if tt.wantErr {
+				if err == nil {
+					rl.Stop()
+					t.Fatal("limiter created")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rl.Stop()
+
+			if result, _ := rl.AllowN("key", tt.wantFull); !result.Allowed {
+				t.Fatalf("first %d requests denied", tt.wantFull)
+			}
+			if result, _ := rl.Allow("key"); result.Allowed {
+				t.Fatal("request past the burst allowed")
+			}
+
+			// The sustained rate is still 60 per 60 seconds.
+			clock.Advance(2 * time.Second)
+			if peek := rl.Peek("key"); peek.Remaining != 2 {
+				t.Errorf("after 2s: Remaining = %d, want 2", peek.Remaining)
+			}
+
+			clock.Advance(time.Hour)
+			if peek := rl.Peek("key"); peek.Remaining != tt.wantFull {
+				t.Errorf("after an hour: Remaining = %d, want the bucket capped at %d", peek.Remaining, tt.wantFull)
+			}
+		})
+	}
+}
+
+func TestRateLimiterOverrideBurst(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 60, 60, LimiterOptions{})
+
+	if err := rl.SetLimitConfig("key", LimitConfig{MaxLimit: 10, WindowSeconds: 10, Burst: 11}); err == nil {
+		t.Error("override with a burst above its max limit accepted")
+	}
+	if err := rl.SetLimitConfig("key", LimitConfig{MaxLimit: 10, WindowSeconds: 10, Burst: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	rl.AllowN("key", 2)
+	if result, _ := rl.Allow("key"); result.Allowed {
+		t.Error("request past the override's burst allowed")
+	}
+	clock.Advance(time.Hour)
+	if peek := rl.Peek("key"); peek.Remaining != 2 {
+		t.Errorf("Remaining = %d, want the override's burst of 2", peek.Remaining)
+	}
+	if peek := rl.Peek("other"); peek.Remaining != 60 {
+		t.Errorf("other key Remaining = %d, want 60", peek.Remaining)
+	}
+}
+
+func TestRateLimiterGroup(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 3, 60, LimiterOptions{})
+	rl.Group("org", "dev", "prod")
+
+	steps := []struct {
+		key         string
+		wantAllowed bool
+	}{
+		{"dev", true},
+		{"prod", true},
+		{"dev", true},
+		// The group's quota is spent, whichever key asks.
+		{"prod", false},
+		{"dev", false},
+		{"staging", true},
+	}
+	for i, step := range steps {
+		if result, _ := rl.Allow(step.key); result.Allowed != step.wantAllowed {
+			t.Errorf("request %d for %s: Allowed = %v, want %v", i+1, step.key, result.Allowed, step.wantAllowed)
+		}
+	}
+
+	rl.AddKeyToGroup("org", "staging")
+	if result, _ := rl.Allow("staging"); result.Allowed {
+		t.Error("key added to a spent group allowed")
+	}
+
+	rl.RemoveKeyFromGroup("other", "prod")
+	if result, _ := rl.Allow("prod"); result.Allowed {
+		t.Error("removing a key from a group it is not in took it out of its group")
+	}
+	rl.RemoveKeyFromGroup("org", "prod")
+	if result, _ := rl.Allow("prod"); !result.Allowed {
+		t.Error("key removed from its group still shares the group's bucket")
+	}
+}
+
+func TestRateLimiterGroupOverride(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 3, 60, LimiterOptions{})
+	rl.Group("org", "dev", "prod")
+	if err := rl.SetLimit("org", 4, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.SetLimit("dev", 100, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := rl.AllowN("dev", 4)
+	if !result.Allowed || result.Limit != 4 {
+		t.Fatalf("AllowN = %+v, want the group's limit of 4", result)
+	}
+	if result, _ := rl.Allow("prod"); result.Allowed {
+		t.Error("group override not shared")
+	}
+}
+
+func TestRateLimiterAllowCtx(t *testing.T) {
+	tests := []struct {
+		name        string
+		refillAfter time.Duration
+		timeout     time.Duration
+		wantAllowed bool
+	}{
+		{name: "token available", timeout: time.Second, wantAllowed: true},
+		{name: "token refills while waiting", refillAfter: 20 * time.Millisecond, timeout: time.Second, wantAllowed: true},
+		{name: "context expires first", timeout: 30 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, clock := newTestRateLimiter(t, 1, 60, LimiterOptions{PollInterval: 5 * time.Millisecond})
+			if tt.refillAfter > 0 || !tt.wantAllowed {
+				rl.Allow("key")
+			}
+			if tt.refillAfter > 0 {
+				time.AfterFunc(tt.refillAfter, func() { clock.Advance(time.Minute) })
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			result, err := rl.AllowCtx(ctx, "key")
+
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if tt.wantAllowed {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+
+			var limitErr *RateLimitError
+			if !errors.As(err, &limitErr) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("err = %v, want a *RateLimitError wrapping context.DeadlineExceeded", err)
+			}
+			if limitErr.Key != "key" || limitErr.Limit != 1 {
+				t.Errorf("RateLimitError = %+v", limitErr)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllowCtxCancel(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 1, 60, LimiterOptions{PollInterval: 5 * time.Millisecond})
+	rl.Allow("key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := rl.AllowCtx(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiterWarmup(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{
+		WarmupDuration:   10 * time.Second,
+		WarmupMultiplier: 2,
+	})
+	if err := rl.SetLimit("override", 3, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		advance time.Duration
+		want    int
+	}{
+		{name: "during warmup", key: "early", want: 10},
+		{name: "override during warmup", key: "override", want: 6},
+		{name: "after warmup", key: "late", advance: 10 * time.Second, want: 5},
+		{name: "bucket filled during warmup", key: "early", advance: time.Hour, want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+
+			result, _ := rl.AllowN(tt.key, tt.want)
+			if !result.Allowed || result.Limit != tt.want {
+				t.Fatalf("AllowN(%d) = %+v, want allowed with that limit", tt.want, result)
+			}
+			if result, _ := rl.Allow(tt.key); result.Allowed {
+				t.Errorf("request past %d allowed", tt.want)
+			}
+		})
+	}
+
+	if _, err := NewRateLimiterWithOptions(context.Background(), 5, 60, LimiterOptions{
+		WarmupDuration:   time.Second,
+		WarmupMultiplier: 0.5,
+	}); err == nil {
+		t.Error("warmup multiplier below 1 accepted")
+	}
+}
+
+// TestRateLimiterKeyHasherCollision pins down the documented trade-off:
+// keys that hash alike share one bucket.
+func TestRateLimiterKeyHasherCollision(t *testing.T) {
+	collide := func(string) string { return "bucket" }
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{KeyHasher: collide})
+
+	rl.Allow("apikey123")
+	if result, _ := rl.Allow("apikey124"); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("second key = %+v, want the shared bucket's last token", result)
+	}
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Errorf("first key allowed after the shared bucket ran out")
+	}
+	if peek := rl.Peek("anything"); peek.Remaining != 0 {
+		t.Errorf("Peek of a colliding key = %+v, want the shared bucket", peek)
+	}
+
+	if err := rl.Reset("apikey124"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if result, _ := rl.Allow("apikey123"); !result.Allowed {
+		t.Errorf("request after resetting a colliding key = %+v, want allowed", result)
+	}
+}
+
+func TestRateLimiterKeyHasherStoresNoPlaintext(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{KeyHasher: SHA256KeyHasher})
+
+	rl.Allow("apikey123")
+	rl.mutex.Lock()
+	_, plain := rl.requests["apikey123"]
+	_, hashed := rl.requests[SHA256KeyHasher("apikey123")]
+	rl.mutex.Unlock()
+	if plain || !hashed {
+		t.Errorf("bucket stored under plaintext %v, hashed %v; want only the hash", plain, hashed)
+	}
+	if hash := SHA256KeyHasher("apikey123"); len(hash) != 16 || hash == SHA256KeyHasher("apikey124") {
+		t.Errorf("SHA256KeyHasher = %q, want 16 hex characters distinct per key", hash)
+	}
+
+	if peek := rl.Peek("apikey123"); peek.Remaining != 1 {
+		t.Errorf("Peek with the plaintext key = %+v, want the hashed bucket", peek)
+	}
+	if err := rl.Reset("apikey123"); err != nil {
+		t.Errorf("Reset with the plaintext key: %v", err)
+	}
+}
+
+func TestRateLimiterCacheWindow(t *testing.T) {
+	const (
+		maxLimit    = 10
+		timeLimit   = 10
+		cacheWindow = 2 * time.Second
+		elapsed     = 20 * time.Second
+	)
+	rl, clock := newTestRateLimiter(t, maxLimit, timeLimit, LimiterOptions{CacheWindow: cacheWindow})
+
+	if result, _ := rl.Allow("key"); !result.Allowed || result.Remaining != maxLimit-1 {
+		t.Fatalf("first request = %+v", result)
+	}
+	if peek := rl.Peek("key"); peek.Remaining != maxLimit-1 {
+		t.Errorf("Peek = %+v, want the cached budget counted as the bucket's", peek)
+	}
+
+	allowed := 1
+	for step := time.Duration(0); step < elapsed; step += 100 * time.Millisecond {
+		for range 5 {
+			if result, _ := rl.Allow("key"); result.Allowed {
+				allowed++
+			}
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	refillRate := float64(maxLimit) / timeLimit
+	if bound := maxLimit + int((elapsed+cacheWindow).Seconds()*refillRate); allowed > bound {
+		t.Errorf("%d requests allowed in %v, want at most %d", allowed, elapsed, bound)
+	}
+	if want := maxLimit + int(elapsed.Seconds()*refillRate) - 1; allowed < want {
+		t.Errorf("%d requests allowed in %v, want the refill rate of about %d", allowed, elapsed, want)
+	}
+
+	if err := rl.Reset("key"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if result, _ := rl.Allow("key"); result.Remaining != maxLimit-1 {
+		t.Errorf("request after Reset = %+v, want a fresh bucket", result)
+	}
+}
+
+func TestRateLimiterCacheWindowRecordsUsage(t *testing.T) {
+	allowed := make(chan string, 1)
+	rl, clock := newTestRateLimiter(t, 30, 60, LimiterOptions{
+		CacheWindow:        time.Second,
+		AutoAllowThreshold: 0.1,
+		AutoAllowWindows:   2,
+		OnAutoAllow:        func(key string) { allowed <- key },
+	})
+	rl.SetTTL(time.Hour)
+
+	// Ten requests a window, one through the lock and nine from the cache,
+	// are well over the 10% threshold of 3.
+	for window := range 4 {
+		for range 10 {
+			clock.Advance(time.Millisecond)
+			rl.Allow("key")
+		}
+		if window == 0 {
+			usage := rl.SortedKeysByUsage(0)
+			if len(usage) != 1 || usage[0].RequestsAllowed != 10 || !usage[0].LastSeen.Equal(clock.Now()) {
+				t.Errorf("SortedKeysByUsage = %+v, want 10 allowed, last seen at the final cache hit", usage)
+			}
+		}
+		clock.Advance(time.Minute)
+	}
+
+	select {
+	case key := <-allowed:
+		t.Errorf("%s auto-allowed while busy through the cache", key)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestRateLimiterReturn(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 3, 60, LimiterOptions{})
+
+	rl.AllowN("key", 2)
+	rl.Return("key")
+	if remaining := rl.Peek("key").Remaining; remaining != 2 {
+		t.Errorf("after Return: %d tokens, want 2", remaining)
+	}
+
+	rl.Return("key")
+	rl.Return("key")
+	if remaining := rl.Peek("key").Remaining; remaining != 3 {
+		t.Errorf("after returning past capacity: %d tokens, want 3", remaining)
+	}
+
+	rl.Return("unseen")
+	if active := rl.Stats().ActiveKeys; active != 1 {
+		t.Errorf("Return created a bucket: ActiveKeys = %d", active)
+	}
+}
+
+func TestRateLimiterWaitForToken(t *testing.T) {
+	rl, err := NewRateLimiterWithOptions(context.Background(), 1, 1, LimiterOptions{Logger: slog.New(slog.DiscardHandler)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	for i := range 2 {
+		if err := rl.WaitForToken(ctx, "key"); err != nil {
+			t.Fatalf("token %d: %v", i+1, err)
+		}
+	}
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Errorf("two tokens at 1 req/s took %v, want about a second", waited)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	start = time.Now()
+	if err := rl.WaitForToken(short, "key"); !errors.Is(err, ErrWaitExceedsDeadline) {
+		t.Errorf("deadline before the next token: err = %v, want ErrWaitExceedsDeadline", err)
+	}
+	if waited := time.Since(start); waited > 50*time.Millisecond {
+		t.Errorf("gave up after %v, want no sleep", waited)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancelNow)
+	if err := rl.WaitForToken(cancelled, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled while sleeping: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiterOnKeyUsed(t *testing.T) {
+	var used []string
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{
+		KeyHasher: SHA256KeyHasher,
+		OnKeyUsed: func(key string) { used = append(used, key) },
+	})
+
+	rl.Allow("a")
+	rl.AllowN("b", 2)
+	rl.AllowCtx(context.Background(), "c")
+	rl.Peek("d")
+
+	if want := []string{"a", "b", "c"}; !slices.Equal(used, want) {
+		t.Errorf("OnKeyUsed got %v, want the plaintext keys %v and nothing for Peek", used, want)
+	}
+}
+
+func TestRateLimiterAllowBatch(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	rl.AllowN("spent", 2)
+
+	results := rl.AllowBatch([]string{"a", "b", "a", "spent", "a"})
+	if len(results) != 3 {
+		t.Fatalf("%d results, want one per distinct key: %v", len(results), results)
+	}
+	for key, wantAllowed := range map[string]bool{"a": true, "b": true, "spent": false} {
+		if results[key].Allowed != wantAllowed {
+			t.Errorf("%s: %+v, want Allowed %v", key, results[key], wantAllowed)
+		}
+	}
+	if peek := rl.Peek("a"); peek.Remaining != 1 {
+		t.Errorf("repeated key charged %d tokens, want 1", 2-peek.Remaining)
+	}
+	if stats := rl.Stats(); stats.TotalAllowed != 3 || stats.TotalDenied != 1 {
+		t.Errorf("Stats = %+v, want each distinct key counted once", stats)
+	}
+}
+
+func TestRateLimiterFollowsStore(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	store := apistore.Default()
+	t.Cleanup(func() { store.RevokeKey("store-key") })
+
+	if err := store.RegisterKey("store-key", apistore.APIKeyConfig{MaxLimit: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if limit := rl.Peek("store-key").Limit; limit != 2 {
+		t.Errorf("Limit after RegisterKey = %d, want the store's 2", limit)
+	}
+
+	if err := store.RevokeKey("store-key"); err != nil {
+		t.Fatal(err)
+	}
+	if limit := rl.Peek("store-key").Limit; limit != 5 {
+		t.Errorf("Limit after RevokeKey = %d, want the default 5", limit)
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+type FailMode int
+
+const (
+	FailClosed FailMode = iota
+	FailOpen
+)
+
+type RedisOptions struct {
+	FailMode   FailMode
+	Logger     *slog.Logger
+	Registerer prometheus.Registerer
+}
+
+type RedisLimiter struct {
+	client      redis.UniversalClient
+	script      *redis.Script
+	peek        *redis.Script
+	maxLimit    int
+	window      time.Duration
+	keyPrefix   string
+	failMode    FailMode
+	logger      *slog.Logger
+	storeErrors prometheus.Counter
+}
+
+// tokenBucketScript refills and drains a bucket stored as a hash of
+// {tokens, ts}. It reads the clock from Redis so every instance agrees on
+// elapsed time, and expires idle buckets after one window since they would
+// be full by then anyway. Returns {allowed, remaining, ms until full, ms
+// until the requested tokens are available}.
+const tokenBucketScript = `
+local capacity = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+local rate = capacity / window_ms
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], window_ms)
+
+return {allowed, math.floor(tokens), math.ceil((capacity - tokens) / rate), math.ceil(math.max(0, requested - tokens) / rate)}
+`
+
+// peekScript applies the same refill as tokenBucketScript without writing
+// the bucket back. Returns {remaining, ms until full, ms until one token}.
+const peekScript = `
+local capacity = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	return {capacity, 0, 0}
+end
+
+local rate = capacity / window_ms
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+return {math.floor(tokens), math.ceil((capacity - tokens) / rate), math.ceil(math.max(0, 1 - tokens) / rate)}
+`
+
+func NewRedisLimiter(client redis.UniversalClient, maxLimit int, windowDuration time.Duration, keyPrefix string) *RedisLimiter {
+	return NewRedisLimiterWithOptions(client, maxLimit, windowDuration, keyPrefix, RedisOptions{})
+}
+
+func NewRedisLimiterWithOptions(client redis.UniversalClient, maxLimit int, windowDuration time.Duration, keyPrefix string, opts RedisOptions) *RedisLimiter {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	rl := &RedisLimiter{
+		client:    client,
+		script:    redis.NewScript(tokenBucketScript),
+		peek:      redis.NewScript(peekScript),
+		maxLimit:  maxLimit,
+		window:    windowDuration,
+		keyPrefix: keyPrefix,
+		failMode:  opts.FailMode,
+		logger:    opts.Logger,
+		storeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limiter_store_errors_total",
+			Help: "Rate limit checks that failed to reach the backing store.",
+		}),
+	}
+
+	if opts.Registerer != nil {
+		rl.storeErrors = registerCounter(opts.Registerer, rl.storeErrors)
+	}
+
+	// Run falls back to EVAL if the script was flushed or this node never
+	// saw the SCRIPT LOAD, so a failure here is not fatal.
+	rl.script.Load(context.Background(), client)
+	rl.peek.Load(context.Background(), client)
+	return rl
+}
+
+func (rl *RedisLimiter) Allow(apiKey string) (Result, error) {
+	return rl.AllowN(apiKey, 1)
+}
+
+func (rl *RedisLimiter) AllowN(apiKey string, n int) (Result, error) {
+	if n < 1 {
+		return Result{Limit: rl.maxLimit, Window: rl.window, ResetAt: time.Now()}, errInvalidCost(n)
+	}
+	if n > rl.maxLimit {
+		return Result{Limit: rl.maxLimit, Window: rl.window, ResetAt: time.Now()}, errCostExceedsLimit(n, rl.maxLimit)
+	}
+
+	keys := []string{rl.keyPrefix + apiKey}
+	values, err := rl.script.Run(context.Background(), rl.client, keys, rl.maxLimit, rl.window.Milliseconds(), n).Int64Slice()
+	if err == nil && len(values) != 4 {
+		err = fmt.Errorf("unexpected script reply %v", values)
+	}
+	if err != nil {
+		return rl.fail(apiKey, err)
+	}
+
+	result := Result{
+		Allowed:   values[0] == 1,
+		Limit:     rl.maxLimit,
+		Window:    rl.window,
+		Remaining: int(values[1]),
+		ResetAt:   time.Now().Add(time.Duration(values[2]) * time.Millisecond),
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Duration(values[3]) * time.Millisecond
+	}
+
+	return result, nil
+}
+
+func (rl *RedisLimiter) Peek(apiKey string) Result {
+	keys := []string{rl.keyPrefix + apiKey}
+	values, err := rl.peek.Run(context.Background(), rl.client, keys, rl.maxLimit, rl.window.Milliseconds()).Int64Slice()
+	if err == nil && len(values) != 3 {
+		err = fmt.Errorf("unexpected script reply %v", values)
+	}
+	if err != nil {
+		result, _ := rl.fail(apiKey, err)
+		return result
+	}
+
+	return Result{
+		Allowed:    values[0] >= 1,
+		Limit:      rl.maxLimit,
+		Window:     rl.window,
+		Remaining:  int(values[0]),
+		ResetAt:    time.Now().Add(time.Duration(values[1]) * time.Millisecond),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}
+}
+
+// HealthCheck pings Redis. It ignores FailMode: a fail-open limiter still
+// admits requests while Redis is down, but is not enforcing anything.
+func (rl *RedisLimiter) HealthCheck(ctx context.Context) error {
+	return rl.client.Ping(ctx).Err()
+}
+
+func (rl *RedisLimiter) fail(apiKey string, err error) (Result, error) {
+	rl.storeErrors.Inc()
+	rl.logger.Error("redis rate limiter unavailable",
+		"key", apiKey,
+		"prefix", rl.keyPrefix,
+		"fail_open", rl.failMode == FailOpen,
+		"error", err,
+	)
+
+	if rl.failMode == FailOpen {
+		return Result{Allowed: true, Limit: rl.maxLimit, Window: rl.window, Remaining: rl.maxLimit, ResetAt: time.Now()}, nil
+	}
+
+	return Result{Limit: rl.maxLimit, Window: rl.window, ResetAt: time.Now().Add(rl.window), RetryAfter: rl.window}, fmt.Errorf("redis rate limiter: %w", err)
+}
+
+// registerCounter registers counter, reusing an identical counter that a
+// previous limiter already registered on the same registry.
+func registerCounter(reg prometheus.Registerer, counter prometheus.Counter) prometheus.Counter {
+	if err := reg.Register(counter); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			if existing, ok := already.ExistingCollector.(prometheus.Counter); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+
+	return counter
+}
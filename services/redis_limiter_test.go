@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+var errRedisDown = errors.New("connection refused")
+
+// failingHook answers every command with errRedisDown, as a client whose
+// server is unreachable would.
+type failingHook struct{}
+
+func (failingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errRedisDown
+	}
+}
+
+func (failingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		cmd.SetErr(errRedisDown)
+		return errRedisDown
+	}
+}
+
+func (failingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			cmd.SetErr(errRedisDown)
+		}
+		return errRedisDown
+	}
+}
+
+func newFailingRedisClient(t *testing.T) redis.UniversalClient {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: "redis.invalid:6379"})
+	client.AddHook(failingHook{})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newMiniredisClient connects to an in-process server whose TIME stays at
+// now until the test moves it. A frozen clock also keeps token counts
+// whole: miniredis's Lua cannot read back the exponent form tostring
+// gives tiny fractions, which real Redis can.
+func newMiniredisClient(t *testing.T, now time.Time) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	server.SetTime(now)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestRedisLimiterFailMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		failMode    FailMode
+		wantAllowed bool
+		wantErr     bool
+	}{
+		{name: "fail closed", failMode: FailClosed, wantAllowed: false, wantErr: true},
+		{name: "fail open", failMode: FailOpen, wantAllowed: true, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			rl := NewRedisLimiterWithOptions(newFailingRedisClient(t), 5, time.Minute, "test:", RedisOptions{
+				FailMode:   tt.failMode,
+				Logger:     slog.New(slog.DiscardHandler),
+				Registerer: reg,
+			})
+
+			result, err := rl.Allow("key")
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errRedisDown) {
+				t.Errorf("err = %v, want it to wrap the store error", err)
+			}
+			if result.Limit != 5 {
+				t.Errorf("Limit = %d, want 5", result.Limit)
+			}
+
+			rl.Peek("key")
+			if got := testutil.ToFloat64(rl.storeErrors); got != 2 {
+				t.Errorf("rate_limiter_store_errors_total = %v, want 2", got)
+			}
+			if rl.HealthCheck(context.Background()) == nil {
+				t.Error("HealthCheck passed with Redis down")
+			}
+		})
+	}
+}
+
+func TestRedisLimiterSharesStoreErrorCounter(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	opts := RedisOptions{FailMode: FailOpen, Logger: slog.New(slog.DiscardHandler), Registerer: reg}
+	first := NewRedisLimiterWithOptions(newFailingRedisClient(t), 5, time.Minute, "a:", opts)
+	second := NewRedisLimiterWithOptions(newFailingRedisClient(t), 5, time.Minute, "b:", opts)
+
+	first.Allow("key")
+	second.Allow("key")
+
+	if got := testutil.ToFloat64(first.storeErrors); got != 2 {
+		t.Errorf("shared counter = %v, want 2", got)
+	}
+}
+
+func TestRedisLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	server, client := newMiniredisClient(t, now)
+	rl := NewRedisLimiter(client, 3, time.Minute, "test:")
+
+	for i := range 3 {
+		result, err := rl.Allow("key")
+		if err != nil || !result.Allowed {
+			t.Fatalf("request %d: %+v, %v", i+1, result, err)
+		}
+		if result.Remaining != 2-i {
+			t.Errorf("request %d: Remaining = %d, want %d", i+1, result.Remaining, 2-i)
+		}
+	}
+
+	result, err := rl.Allow("key")
+	if err != nil || result.Allowed {
+		t.Fatalf("fourth request: %+v, %v", result, err)
+	}
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf("RetryAfter = %v, want one token's refill of 20s", result.RetryAfter)
+	}
+	if peek := rl.Peek("key"); peek.Allowed || peek.Remaining != 0 {
+		t.Errorf("Peek = %+v, want an empty bucket", peek)
+	}
+	if peek := rl.Peek("other"); !peek.Allowed || peek.Remaining != 3 {
+		t.Errorf("Peek of an unseen key = %+v, want a full bucket", peek)
+	}
+
+	if _, err := rl.AllowN("key", 0); !errors.Is(err, ErrInvalidCost) {
+		t.Errorf("AllowN(0): err = %v, want ErrInvalidCost", err)
+	}
+	if _, err := rl.AllowN("key", 4); !errors.Is(err, ErrCostExceedsCapacity) {
+		t.Errorf("AllowN(4): err = %v, want ErrCostExceedsCapacity", err)
+	}
+
+	server.SetTime(now.Add(20 * time.Second))
+	if result, _ := rl.Allow("key"); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after 20s = %+v, want one refilled token spent", result)
+	}
+
+	if err := rl.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck: %v", err)
+	}
+}
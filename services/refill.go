@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RefillStrategy decides how many tokens a RateLimiter bucket regains.
+// elapsed is the time since tokens were last added and window the key's
+// window, so strategies follow per-key limits. The result is capped at the
+// bucket's capacity, and must not shrink as elapsed grows.
+//
+// Buckets idle for the limiter's TTL, one window by default, are evicted and
+// start full again. Pair a strategy that takes longer than a window to
+// refill an empty bucket with SetTTL.
+type RefillStrategy interface {
+	TokensToAdd(elapsed, window time.Duration, currentTokens, maxLimit int) int
+}
+
+// LinearRefill adds maxLimit tokens evenly over each window. It is the
+// default.
+type LinearRefill struct{}
+
+func (LinearRefill) TokensToAdd(elapsed, window time.Duration, currentTokens, maxLimit int) int {
+	refillRate := float64(maxLimit) / window.Seconds()
+	return int(elapsed.Seconds() * refillRate)
+}
+
+type exponentialRefill struct {
+	base float64
+}
+
+// ExponentialRefill grows a bucket's tokens, plus one so an empty bucket
+// recovers, by base every window: the fuller the bucket, the faster it
+// refills. With base 2 an empty bucket regains its first token after one
+// window and its next within another 0.6. It panics if base is not more
+// than 1.
+func ExponentialRefill(base float64) RefillStrategy {
+	if base <= 1 {
+		panic(fmt.Sprintf("exponential refill base must be more than 1, got %g", base))
+	}
+
+	return exponentialRefill{base: base}
+}
+
+func (e exponentialRefill) TokensToAdd(elapsed, window time.Duration, currentTokens, maxLimit int) int {
+	seed := float64(currentTokens + 1)
+	grown := seed * math.Pow(e.base, elapsed.Seconds()/window.Seconds())
+	return int(min(grown-seed, float64(maxLimit)))
+}
+
+type stepRefill struct {
+	size     int
+	duration time.Duration
+}
+
+// StepRefill adds stepSize tokens for each full stepDuration and nothing in
+// between, whatever the key's window. It panics unless both are positive.
+func StepRefill(stepSize int, stepDuration time.Duration) RefillStrategy {
+	if stepSize <= 0 || stepDuration <= 0 {
+		panic(fmt.Sprintf("step refill needs a positive size and duration, got %d per %s", stepSize, stepDuration))
+	}
+
+	return stepRefill{size: stepSize, duration: stepDuration}
+}
+
+func (s stepRefill) TokensToAdd(elapsed, window time.Duration, currentTokens, maxLimit int) int {
+	steps := min(int64(elapsed/s.duration), int64(maxLimit))
+	return min(int(steps)*s.size, maxLimit)
+}
+
+func (s stepRefill) step() time.Duration {
+	return s.duration
+}
+
+// stepper is implemented by strategies that add tokens in whole steps. The
+// limiter keeps the time already spent towards the next step instead of
+// starting it over whenever tokens are added.
+type stepper interface {
+	step() time.Duration
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefillStrategies(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      RefillStrategy
+		elapsed       time.Duration
+		currentTokens int
+		want          int
+	}{
+		{name: "linear/none elapsed", strategy: LinearRefill{}, elapsed: 0, want: 0},
+		{name: "linear/under one token", strategy: LinearRefill{}, elapsed: 500 * time.Millisecond, want: 0},
+		{name: "linear/one token", strategy: LinearRefill{}, elapsed: time.Second, want: 1},
+		{name: "linear/half window", strategy: LinearRefill{}, elapsed: 30 * time.Second, want: 30},
+		{name: "linear/ignores current tokens", strategy: LinearRefill{}, elapsed: 30 * time.Second, currentTokens: 50, want: 30},
+
+		{name: "exponential/none elapsed", strategy: ExponentialRefill(2), elapsed: 0, want: 0},
+		{name: "exponential/half window from empty", strategy: ExponentialRefill(2), elapsed: 30 * time.Second, want: 0},
+		{name: "exponential/one window from empty", strategy: ExponentialRefill(2), elapsed: time.Minute, want: 1},
+		{name: "exponential/1.6 windows from empty", strategy: ExponentialRefill(2), elapsed: 96 * time.Second, want: 2},
+		{name: "exponential/one window from three", strategy: ExponentialRefill(2), elapsed: time.Minute, currentTokens: 3, want: 4},
+		{name: "exponential/capped", strategy: ExponentialRefill(2), elapsed: time.Hour, want: 60},
+
+		{name: "step/none elapsed", strategy: StepRefill(10, time.Minute), elapsed: 0, want: 0},
+		{name: "step/just short of a step", strategy: StepRefill(10, time.Minute), elapsed: 59 * time.Second, want: 0},
+		{name: "step/one step", strategy: StepRefill(10, time.Minute), elapsed: time.Minute, want: 10},
+		{name: "step/between steps", strategy: StepRefill(10, time.Minute), elapsed: 150 * time.Second, want: 20},
+		{name: "step/capped", strategy: StepRefill(10, time.Minute), elapsed: time.Hour, want: 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.strategy.TokensToAdd(tt.elapsed, time.Minute, tt.currentTokens, 60); got != tt.want {
+				t.Errorf("TokensToAdd(%s) = %d, want %d", tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefillStrategyPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() RefillStrategy
+	}{
+		{name: "exponential base 1", new: func() RefillStrategy { return ExponentialRefill(1) }},
+		{name: "step size 0", new: func() RefillStrategy { return StepRefill(0, time.Minute) }},
+		{name: "step duration 0", new: func() RefillStrategy { return StepRefill(10, 0) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			tt.new()
+		})
+	}
+}
+
+// TestRateLimiterStepRefill checks that a denial between steps does not
+// start the step over.
+func TestRateLimiterStepRefill(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{RefillStrategy: StepRefill(2, 10*time.Second)})
+	rl.AllowN("apikey123", 5)
+
+	clock.Advance(9 * time.Second)
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Fatalf("request before the first step allowed: %+v", result)
+	}
+
+	clock.Advance(time.Second)
+	for i := range 2 {
+		if result, _ := rl.Allow("apikey123"); !result.Allowed {
+			t.Fatalf("request %d after the first step denied: %+v", i+1, result)
+		}
+	}
+	if result, _ := rl.Allow("apikey123"); result.Allowed {
+		t.Errorf("third request after a step of 2 allowed: %+v", result)
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// Reservation holds tokens taken up front by RateLimiter.Reserve, so a long
+// operation learns at the start whether its whole quota is available. Like
+// golang.org/x/time/rate.Reservation, tokens that end up unused can be
+// handed back.
+type Reservation struct {
+	result Result
+	tokens int
+	once   sync.Once
+	cancel func(unused int)
+}
+
+// Reserve takes n tokens from the key's bucket, waiting for them to refill
+// if fewer are available. If the context ends first it returns a
+// *RateLimitError wrapping ctx.Err().
+// Asking for more than the bucket can ever hold fails immediately.
+func (rl *RateLimiter) Reserve(ctx context.Context, key string, n int) (*Reservation, error) {
+	result, err := rl.waitN(ctx, key, n)
+	if err != nil {
+		return nil, err
+	}
+
+	key = rl.hashKey(key)
+	return &Reservation{
+		result: result,
+		tokens: n,
+		cancel: func(unused int) { rl.returnN(key, unused) },
+	}, nil
+}
+
+// Tokens reports how many tokens the reservation took.
+func (r *Reservation) Tokens() int {
+	return r.tokens
+}
+
+// Result is the limiter's answer at the time the tokens were taken.
+func (r *Reservation) Result() Result {
+	return r.result
+}
+
+// Use settles the reservation, handing back every token beyond consumed.
+// Only the first call to Use or Cancel has any effect.
+func (r *Reservation) Use(consumed int) {
+	r.once.Do(func() {
+		if unused := r.tokens - max(consumed, 0); unused > 0 {
+			r.cancel(unused)
+		}
+	})
+}
+
+// Cancel hands back all of the reservation's tokens.
+func (r *Reservation) Cancel() {
+	r.Use(0)
+}
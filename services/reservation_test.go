@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReservation(t *testing.T) {
+	tests := []struct {
+		name          string
+		settle        func(*Reservation)
+		wantRemaining int
+	}{
+		{name: "unsettled", settle: func(*Reservation) {}, wantRemaining: 4},
+		{name: "partial use", settle: func(r *Reservation) { r.Use(2) }, wantRemaining: 8},
+		{name: "full use", settle: func(r *Reservation) { r.Use(6) }, wantRemaining: 4},
+		{name: "use beyond reservation", settle: func(r *Reservation) { r.Use(10) }, wantRemaining: 4},
+		{name: "cancel", settle: func(r *Reservation) { r.Cancel() }, wantRemaining: 10},
+		{name: "settled once", settle: func(r *Reservation) { r.Use(6); r.Cancel() }, wantRemaining: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 10, 60, LimiterOptions{})
+
+			reservation, err := rl.Reserve(context.Background(), "key", 6)
+			if err != nil {
+				t.Fatalf("Reserve: %v", err)
+			}
+			if reservation.Tokens() != 6 || reservation.Result().Remaining != 4 {
+				t.Fatalf("reservation holds %d tokens with %+v", reservation.Tokens(), reservation.Result())
+			}
+
+			tt.settle(reservation)
+			if remaining := rl.Peek("key").Remaining; remaining != tt.wantRemaining {
+				t.Errorf("bucket holds %d tokens, want %d", remaining, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestReserveWaits(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 10, 60, LimiterOptions{PollInterval: time.Millisecond})
+	rl.AllowN("key", 8)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var limitErr *RateLimitError
+	if _, err := rl.Reserve(ctx, "key", 5); !errors.As(err, &limitErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Reserve with 2 tokens left: err = %v, want a RateLimitError for the deadline", err)
+	}
+	if remaining := rl.Peek("key").Remaining; remaining != 2 {
+		t.Errorf("a failed Reserve took tokens: %d left, want 2", remaining)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rl.Reserve(context.Background(), "key", 5)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(30 * time.Second)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Reserve after refill: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Reserve still waiting after the bucket refilled")
+	}
+
+	if _, err := rl.Reserve(context.Background(), "key", 11); !errors.Is(err, ErrCostExceedsCapacity) {
+		t.Errorf("Reserve past capacity: err = %v, want ErrCostExceedsCapacity", err)
+	}
+}
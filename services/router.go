@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type AlgorithmKind string
+
+const (
+	TokenBucket   AlgorithmKind = "token_bucket"
+	FixedWindow   AlgorithmKind = "fixed_window"
+	SlidingWindow AlgorithmKind = "sliding_window"
+	LeakyBucket   AlgorithmKind = "leaky_bucket"
+	GCRA          AlgorithmKind = "gcra"
+	HybridWindow  AlgorithmKind = "hybrid_window"
+)
+
+type LimiterConfig struct {
+	MaxLimit  int
+	Window    time.Duration
+	Algorithm AlgorithmKind
+}
+
+type Router struct {
+	mux           *http.ServeMux
+	DefaultConfig LimiterConfig
+	Extractor     KeyExtractor
+}
+
+func NewRouter(defaultConfig LimiterConfig) *Router {
+	return &Router{
+		mux:           http.NewServeMux(),
+		DefaultConfig: defaultConfig,
+		Extractor:     APIKeyExtractor,
+	}
+}
+
+// Handle registers handler behind a limiter dedicated to pattern. Zero fields
+// in cfg are taken from DefaultConfig, so LimiterConfig{} means "use the
+// defaults". Responses name the route in X-RateLimit-Policy after pattern.
+// Like http.ServeMux.Handle it panics on an invalid registration.
+func (rt *Router) Handle(pattern string, handler http.Handler, cfg LimiterConfig) {
+	limiter, err := NewLimiter(rt.withDefaults(cfg))
+	if err != nil {
+		panic(fmt.Sprintf("services: invalid limiter config for %q: %v", pattern, err))
+	}
+
+	rt.HandleWithLimiter(pattern, handler, limiter)
+}
+
+// HandleWithLimiter registers handler behind limiter, which may be shared
+// with other routes, e.g. one built by NewRateLimiterFromConfig.
+func (rt *Router) HandleWithLimiter(pattern string, handler http.Handler, limiter Limiter) {
+	opts := Options{RouteID: strings.Trim(pattern, "/")}
+	rt.mux.Handle(pattern, NewRateLimiterMiddlewareWithOptions(handler, limiter, rt.Extractor, opts))
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+func (rt *Router) withDefaults(cfg LimiterConfig) LimiterConfig {
+	if cfg.MaxLimit == 0 {
+		cfg.MaxLimit = rt.DefaultConfig.MaxLimit
+	}
+	if cfg.Window == 0 {
+		cfg.Window = rt.DefaultConfig.Window
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = rt.DefaultConfig.Algorithm
+	}
+	return cfg
+}
+
+func NewLimiter(cfg LimiterConfig) (Limiter, error) {
+	if cfg.MaxLimit <= 0 {
+		return nil, fmt.Errorf("max limit must be positive, got %d", cfg.MaxLimit)
+	}
+	if cfg.Window <= 0 {
+		return nil, fmt.Errorf("window must be positive, got %s", cfg.Window)
+	}
+
+	switch cfg.Algorithm {
+	case TokenBucket, "":
+		if cfg.Window%time.Second != 0 {
+			return nil, fmt.Errorf("token bucket window must be whole seconds, got %s", cfg.Window)
+		}
+		return NewRateLimiter(cfg.MaxLimit, int(cfg.Window/time.Second)), nil
+	case FixedWindow:
+		return NewFixedWindowLimiter(cfg.MaxLimit, cfg.Window), nil
+	case SlidingWindow:
+		return NewSlidingWindowLimiter(cfg.MaxLimit, cfg.Window), nil
+	case LeakyBucket:
+		return NewLeakyBucketLimiter(cfg.MaxLimit, float64(cfg.MaxLimit)/cfg.Window.Seconds()), nil
+	case GCRA:
+		if cfg.Window < time.Duration(cfg.MaxLimit) {
+			return nil, fmt.Errorf("gcra window must be at least one nanosecond per request, got %d per %s", cfg.MaxLimit, cfg.Window)
+		}
+		return NewGCRALimiter(cfg.MaxLimit, cfg.Window, cfg.MaxLimit), nil
+	case HybridWindow:
+		return NewHybridWindowLimiter(cfg.MaxLimit, cfg.Window), nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", cfg.Algorithm)
+	}
+}
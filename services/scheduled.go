@@ -0,0 +1,73 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Schedule routes requests to Limiter while the UTC time of day is within
+// [Start, End). Ranges with End before Start wrap around midnight.
+type Schedule struct {
+	Start   time.Duration
+	End     time.Duration
+	Limiter Limiter
+}
+
+type ScheduledLimiter struct {
+	defaultLimiter Limiter
+	schedules      []Schedule
+	clock          Clock
+	mutex          sync.RWMutex
+}
+
+func NewScheduledLimiter(defaultLimiter Limiter, schedules ...Schedule) *ScheduledLimiter {
+	return &ScheduledLimiter{
+		defaultLimiter: defaultLimiter,
+		schedules:      schedules,
+		clock:          realClock{},
+	}
+}
+
+func (sl *ScheduledLimiter) SetClock(clock Clock) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.clock = clock
+}
+
+func (sl *ScheduledLimiter) Allow(key string) (Result, error) {
+	return sl.current().Allow(key)
+}
+
+func (sl *ScheduledLimiter) AllowN(key string, n int) (Result, error) {
+	return sl.current().AllowN(key, n)
+}
+
+func (sl *ScheduledLimiter) Peek(key string) Result {
+	return sl.current().Peek(key)
+}
+
+func (sl *ScheduledLimiter) current() Limiter {
+	sl.mutex.RLock()
+	now := sl.clock.Now().UTC()
+	sl.mutex.RUnlock()
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	offset := now.Sub(midnight)
+
+	for _, schedule := range sl.schedules {
+		if schedule.contains(offset) {
+			return schedule.Limiter
+		}
+	}
+
+	return sl.defaultLimiter
+}
+
+func (s Schedule) contains(offset time.Duration) bool {
+	if s.Start <= s.End {
+		return offset >= s.Start && offset < s.End
+	}
+
+	return offset >= s.Start || offset < s.End
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Serializer encodes the buckets written to a limiter's snapshot file.
+type Serializer interface {
+	Marshal(buckets map[string]*RequestMetadata) ([]byte, error)
+	Unmarshal(data []byte) (map[string]*RequestMetadata, error)
+}
+
+type JSONSerializer struct{}
+
+// snapshotTime stores a time as Unix milliseconds, which is all the
+// precision refill needs and keeps snapshots compact.
+type snapshotTime time.Time
+
+func (t snapshotTime) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, time.Time(t).UnixMilli(), 10), nil
+}
+
+func (t *snapshotTime) UnmarshalJSON(data []byte) error {
+	ms, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("snapshot time: %w", err)
+	}
+
+	*t = snapshotTime(time.UnixMilli(ms))
+	return nil
+}
+
+type bucketSnapshot struct {
+	LastSeen   snapshotTime `json:"lastSeen"`
+	TokenCount int          `json:"tokens"`
+	Burst      int          `json:"burst"`
+}
+
+func (JSONSerializer) Marshal(buckets map[string]*RequestMetadata) ([]byte, error) {
+	snapshot := make(map[string]bucketSnapshot, len(buckets))
+	for key, metadata := range buckets {
+		snapshot[key] = bucketSnapshot{
+			LastSeen:   snapshotTime(metadata.lastSeen),
+			TokenCount: metadata.tokenCount,
+			Burst:      metadata.burst,
+		}
+	}
+
+	return json.Marshal(snapshot)
+}
+
+func (JSONSerializer) Unmarshal(data []byte) (map[string]*RequestMetadata, error) {
+	var snapshot map[string]bucketSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[string]*RequestMetadata, len(snapshot))
+	for key, bucket := range snapshot {
+		buckets[key] = &RequestMetadata{
+			lastSeen:   time.Time(bucket.LastSeen),
+			tokenCount: bucket.TokenCount,
+			burst:      bucket.Burst,
+		}
+	}
+
+	return buckets, nil
+}
+
+// MsgpackSerializer stores each bucket as a three-element array, which is
+// both smaller and quicker to decode than JSON for large snapshots.
+type MsgpackSerializer struct{}
+
+type msgpackBucket struct {
+	_msgpack   struct{} `msgpack:",as_array"`
+	LastSeen   int64
+	TokenCount int
+	Burst      int
+}
+
+func (MsgpackSerializer) Marshal(buckets map[string]*RequestMetadata) ([]byte, error) {
+	snapshot := make(map[string]msgpackBucket, len(buckets))
+	for key, metadata := range buckets {
+		snapshot[key] = msgpackBucket{
+			LastSeen:   metadata.lastSeen.UnixMilli(),
+			TokenCount: metadata.tokenCount,
+			Burst:      metadata.burst,
+		}
+	}
+
+	return msgpack.Marshal(snapshot)
+}
+
+func (MsgpackSerializer) Unmarshal(data []byte) (map[string]*RequestMetadata, error) {
+	var snapshot map[string]msgpackBucket
+	if err := msgpack.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[string]*RequestMetadata, len(snapshot))
+	for key, bucket := range snapshot {
+		buckets[key] = &RequestMetadata{
+			lastSeen:   time.UnixMilli(bucket.LastSeen),
+			tokenCount: bucket.TokenCount,
+			burst:      bucket.Burst,
+		}
+	}
+
+	return buckets, nil
+}
+
+// ProtobufSerializer writes the Snapshot message defined in snapshot.proto.
+// The schema is three integers per bucket, so it is encoded by hand with
+// protowire rather than through generated code.
+type ProtobufSerializer struct{}
+
+const (
+	snapshotBucketsField = 1
+	entryKeyField        = 1
+	entryValueField      = 2
+	bucketLastSeenField  = 1
+	bucketTokensField    = 2
+	bucketBurstField     = 3
+)
+
+func (ProtobufSerializer) Marshal(buckets map[string]*RequestMetadata) ([]byte, error) {
+	var data, entry, bucket []byte
+	for key, metadata := range buckets {
+		bucket = protowire.AppendTag(bucket[:0], bucketLastSeenField, protowire.VarintType)
+		bucket = protowire.AppendVarint(bucket, uint64(metadata.lastSeen.UnixMilli()))
+		bucket = protowire.AppendTag(bucket, bucketTokensField, protowire.VarintType)
+		bucket = protowire.AppendVarint(bucket, uint64(metadata.tokenCount))
+		bucket = protowire.AppendTag(bucket, bucketBurstField, protowire.VarintType)
+		bucket = protowire.AppendVarint(bucket, uint64(metadata.burst))
+
+		entry = protowire.AppendTag(entry[:0], entryKeyField, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, entryValueField, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, bucket)
+
+		data = protowire.AppendTag(data, snapshotBucketsField, protowire.BytesType)
+		data = protowire.AppendBytes(data, entry)
+	}
+
+	return data, nil
+}
+
+func (ProtobufSerializer) Unmarshal(data []byte) (map[string]*RequestMetadata, error) {
+	buckets := make(map[string]*RequestMetadata)
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if num != snapshotBucketsField || typ != protowire.BytesType {
+			return nil
+		}
+
+		var key string
+		metadata := &RequestMetadata{lastSeen: time.UnixMilli(0)}
+		err := consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+			switch {
+			case num == entryKeyField && typ == protowire.BytesType:
+				key = string(value)
+			case num == entryValueField && typ == protowire.BytesType:
+				return consumeFields(value, func(num protowire.Number, typ protowire.Type, _ []byte, v uint64) error {
+					if typ != protowire.VarintType {
+						return nil
+					}
+					switch num {
+					case bucketLastSeenField:
+						metadata.lastSeen = time.UnixMilli(int64(v))
+					case bucketTokensField:
+						metadata.tokenCount = int(int64(v))
+					case bucketBurstField:
+						metadata.burst = int(int64(v))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		buckets[key] = metadata
+		return nil
+	})
+
+	return buckets, err
+}
+
+// consumeFields walks the fields of one protobuf message, handing each to
+// fn with its payload for length-delimited fields or its value for varints.
+// Fields of other wire types are skipped.
+func consumeFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, v uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var (
+			value []byte
+			v     uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, value, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var serializers = []struct {
+	name       string
+	serializer Serializer
+}{
+	{"json", JSONSerializer{}},
+	{"msgpack", MsgpackSerializer{}},
+	{"protobuf", ProtobufSerializer{}},
+}
+
+func testBuckets(n int) map[string]*RequestMetadata {
+	lastSeen := time.UnixMilli(1_700_000_000_123)
+	buckets := make(map[string]*RequestMetadata, n)
+	for i := range n {
+		buckets[fmt.Sprintf("key-%d", i)] = &RequestMetadata{
+			lastSeen:   lastSeen.Add(time.Duration(i) * time.Millisecond),
+			tokenCount: i % 100,
+			burst:      100,
+		}
+	}
+
+	return buckets
+}
+
+func TestSerializerRoundTrip(t *testing.T) {
+	buckets := testBuckets(100)
+	buckets[""] = &RequestMetadata{lastSeen: time.UnixMilli(0)}
+	buckets["ключ:\x00"] = &RequestMetadata{lastSeen: time.UnixMilli(1), tokenCount: 7}
+
+	for _, s := range serializers {
+		t.Run(s.name, func(t *testing.T) {
+			data, err := s.serializer.Marshal(buckets)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			restored, err := s.serializer.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+
+			if len(restored) != len(buckets) {
+				t.Fatalf("restored %d buckets, want %d", len(restored), len(buckets))
+			}
+			for key, want := range buckets {
+				got, exists := restored[key]
+				if !exists {
+					t.Fatalf("bucket %q lost", key)
+				}
+				if !got.lastSeen.Equal(want.lastSeen) || got.tokenCount != want.tokenCount || got.burst != want.burst {
+					t.Errorf("bucket %q = %v/%d/%d, want %v/%d/%d", key,
+						got.lastSeen, got.tokenCount, got.burst, want.lastSeen, want.tokenCount, want.burst)
+				}
+			}
+		})
+	}
+}
+
+func TestSerializerRejectsCorruptData(t *testing.T) {
+	for _, s := range serializers {
+		t.Run(s.name, func(t *testing.T) {
+			data, _ := s.serializer.Marshal(testBuckets(10))
+			if _, err := s.serializer.Unmarshal(data[:len(data)-1]); err == nil {
+				t.Error("truncated snapshot accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package servicestest
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"rate-limiter/services"
+)
+
+// TestLimiter is a services.Limiter for tests of code that takes one. The
+// zero value allows everything. Set AllowFunc or AllowNFunc to decide per
+// call; AllowN falls back to AllowFunc, so one AllowFunc also covers
+// middleware, which always calls AllowN. Both record the key they were
+// called with.
+type TestLimiter struct {
+	AllowFunc  func(key string) (services.Result, error)
+	AllowNFunc func(key string, n int) (services.Result, error)
+	// PeekFunc answers Peek, which is not recorded. If nil, Peek allows.
+	PeekFunc func(key string) services.Result
+
+	mutex sync.Mutex
+	calls []string
+}
+
+// AlwaysAllowLimiter returns a TestLimiter that allows every request.
+func AlwaysAllowLimiter() *TestLimiter {
+	return &TestLimiter{}
+}
+
+// AlwaysDenyLimiter returns a TestLimiter that denies every request, and
+// whose Peek reports no tokens left.
+func AlwaysDenyLimiter() *TestLimiter {
+	return &TestLimiter{
+		AllowNFunc: func(key string, n int) (services.Result, error) { return services.Result{}, nil },
+		PeekFunc:   func(key string) services.Result { return services.Result{} },
+	}
+}
+
+func (tl *TestLimiter) Allow(key string) (services.Result, error) {
+	tl.record(key)
+	if tl.AllowFunc != nil {
+		return tl.AllowFunc(key)
+	}
+	if tl.AllowNFunc != nil {
+		return tl.AllowNFunc(key, 1)
+	}
+
+	return services.Result{Allowed: true}, nil
+}
+
+func (tl *TestLimiter) AllowN(key string, n int) (services.Result, error) {
+	tl.record(key)
+	if tl.AllowNFunc != nil {
+		return tl.AllowNFunc(key, n)
+	}
+	if tl.AllowFunc != nil {
+		return tl.AllowFunc(key)
+	}
+
+	return services.Result{Allowed: true}, nil
+}
+
+func (tl *TestLimiter) Peek(key string) services.Result {
+	if tl.PeekFunc != nil {
+		return tl.PeekFunc(key)
+	}
+
+	return services.Result{Allowed: true}
+}
+
+// AssertCalledWith fails t unless Allow or AllowN has been called with key.
+func (tl *TestLimiter) AssertCalledWith(t testing.TB, key string) {
+	t.Helper()
+
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	if !slices.Contains(tl.calls, key) {
+		t.Errorf("limiter was not called with key %q; called with %q", key, tl.calls)
+	}
+}
+
+// AssertCallCount fails t unless Allow and AllowN have been called n times
+// between them.
+func (tl *TestLimiter) AssertCallCount(t testing.TB, n int) {
+	t.Helper()
+
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	if len(tl.calls) != n {
+		t.Errorf("limiter was called %d times, want %d", len(tl.calls), n)
+	}
+}
+
+func (tl *TestLimiter) record(key string) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+
+	tl.calls = append(tl.calls, key)
+}
+
+var _ services.Limiter = (*TestLimiter)(nil)
@@ -0,0 +1,99 @@
+package servicestest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rate-limiter/services"
+)
+
+// recordingTB captures failures so the assertions' own failures can be
+// checked without failing the test.
+type recordingTB struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestTestLimiterMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name    string
+		limiter *TestLimiter
+		status  int
+	}{
+		{name: "zero value", limiter: &TestLimiter{}, status: http.StatusOK},
+		{name: "always allow", limiter: AlwaysAllowLimiter(), status: http.StatusOK},
+		{name: "always deny", limiter: AlwaysDenyLimiter(), status: http.StatusTooManyRequests},
+		{
+			name: "AllowFunc covers AllowN",
+			limiter: &TestLimiter{AllowFunc: func(key string) (services.Result, error) {
+				return services.Result{Allowed: key == "apikey124"}, nil
+			}},
+			status: http.StatusTooManyRequests,
+		},
+		{
+			name: "error is a denial",
+			limiter: &TestLimiter{AllowNFunc: func(key string, n int) (services.Result, error) {
+				return services.Result{}, errors.New("store down")
+			}},
+			status: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := services.RateLimiterMiddleware(ok, tt.limiter)
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req.Header.Set("X-API-KEY", "apikey123")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			tt.limiter.AssertCalledWith(t, "apikey123")
+			tt.limiter.AssertCallCount(t, 1)
+		})
+	}
+}
+
+func TestTestLimiterAssertions(t *testing.T) {
+	limiter := AlwaysAllowLimiter()
+	limiter.Allow("a")
+	limiter.AllowN("b", 3)
+	limiter.Peek("c")
+
+	tests := []struct {
+		name   string
+		assert func(tb testing.TB)
+		fails  bool
+	}{
+		{name: "called with a", assert: func(tb testing.TB) { limiter.AssertCalledWith(tb, "a") }},
+		{name: "called with b", assert: func(tb testing.TB) { limiter.AssertCalledWith(tb, "b") }},
+		{name: "Peek is not recorded", assert: func(tb testing.TB) { limiter.AssertCalledWith(tb, "c") }, fails: true},
+		{name: "two calls", assert: func(tb testing.TB) { limiter.AssertCallCount(tb, 2) }},
+		{name: "wrong count", assert: func(tb testing.TB) { limiter.AssertCallCount(tb, 3) }, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &recordingTB{TB: t}
+			tt.assert(tb)
+			if failed := len(tb.failures) > 0; failed != tt.fails {
+				t.Errorf("assertion failures %q, want failing %v", tb.failures, tt.fails)
+			}
+		})
+	}
+}
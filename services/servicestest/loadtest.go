@@ -0,0 +1,142 @@
+// Package servicestest provides utilities for testing code that uses the
+// services package's limiters, kept apart so the testing package is not
+// linked into production binaries.
+package servicestest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"rate-limiter/services"
+)
+
+type LoadTestReport struct {
+	Allowed   int
+	Denied    int
+	Errors    int
+	PerSecond []SecondStats
+	// ViolationCount is the number of windows, sized by the limiter's
+	// Result.Window and aligned like time.Truncate, that allowed more than
+	// Result.Limit plus LoadTestOptions.Allowance requests. Requests in
+	// flight across a window boundary are not counted against either
+	// window.
+	ViolationCount int
+}
+
+type SecondStats struct {
+	Allowed int
+	Denied  int
+}
+
+type LoadTestOptions struct {
+	// Allowance is how many requests above Result.Limit one window may
+	// admit before it counts as a violation. Zero picks the bound of the
+	// services package's own limiters: window counters admit no more than
+	// their limit, while token buckets, GCRA and leaky buckets can admit a
+	// full burst, at most Result.Limit, on top of what refills during the
+	// window. Set it for any other limiter, including wrappers such as
+	// MetricsLimiter.
+	Allowance int
+}
+
+type loadTestSample struct {
+	began   time.Time
+	ended   time.Time
+	allowed bool
+	err     error
+}
+
+// RunLoadTest fires rps requests per second for key at limiter, each from
+// its own goroutine, and reports what the limiter decided.
+func RunLoadTest(limiter services.Limiter, key string, rps int, duration time.Duration) LoadTestReport {
+	return RunLoadTestWithOptions(limiter, key, rps, duration, LoadTestOptions{})
+}
+
+func RunLoadTestWithOptions(limiter services.Limiter, key string, rps int, duration time.Duration, opts LoadTestOptions) LoadTestReport {
+	var (
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+		samples []loadTestSample
+		limit   int
+		window  time.Duration
+	)
+
+	total := int(duration.Seconds() * float64(rps))
+	interval := time.Second / time.Duration(max(rps, 1))
+	start := time.Now()
+
+	for i := 0; i < total; i++ {
+		time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			began := time.Now()
+			result, err := limiter.Allow(key)
+			sample := loadTestSample{began: began, ended: time.Now(), allowed: err == nil && result.Allowed, err: err}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			samples = append(samples, sample)
+			if result.Limit > 0 {
+				limit, window = result.Limit, result.Window
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := LoadTestReport{PerSecond: make([]SecondStats, int(duration/time.Second)+1)}
+	allowedPerWindow := make(map[time.Time]int)
+	for _, sample := range samples {
+		second := min(int(sample.began.Sub(start)/time.Second), len(report.PerSecond)-1)
+		switch {
+		case sample.err != nil:
+			report.Errors++
+			report.PerSecond[second].Denied++
+		case sample.allowed:
+			report.Allowed++
+			report.PerSecond[second].Allowed++
+			if window > 0 && sample.began.Truncate(window).Equal(sample.ended.Truncate(window)) {
+				allowedPerWindow[sample.began.Truncate(window)]++
+			}
+		default:
+			report.Denied++
+			report.PerSecond[second].Denied++
+		}
+	}
+
+	allowance := opts.Allowance
+	if allowance == 0 {
+		allowance = defaultAllowance(limiter, limit)
+	}
+	for _, allowed := range allowedPerWindow {
+		if allowed > limit+allowance {
+			report.ViolationCount++
+		}
+	}
+
+	return report
+}
+
+// defaultAllowance is the burst a limiter may admit on top of a window's
+// worth of refill, for the limiters whose algorithm is known.
+func defaultAllowance(limiter services.Limiter, limit int) int {
+	switch limiter.(type) {
+	case *services.RateLimiter, *services.ShardedRateLimiter, *services.AtomicRateLimiter,
+		*services.RedisLimiter, *services.GCRALimiter, *services.LeakyBucketLimiter:
+		return limit
+	default:
+		return 0
+	}
+}
+
+func AssertNoViolations(t testing.TB, report LoadTestReport) {
+	t.Helper()
+
+	if report.ViolationCount > 0 {
+		t.Errorf("rate limit violated in %d window(s): %d allowed, %d denied, %d errors",
+			report.ViolationCount, report.Allowed, report.Denied, report.Errors)
+	}
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+const defaultShards = 256
+
+// ShardedRateLimiter spreads keys over independent RateLimiters so requests
+// for different keys rarely wait on the same mutex. A key always maps to the
+// same shard, so per-key behaviour matches a single RateLimiter.
+type ShardedRateLimiter struct {
+	shards []*RateLimiter
+}
+
+func NewShardedRateLimiter(shards, maxLimit, timeLimit int) *ShardedRateLimiter {
+	if shards <= 0 {
+		shards = defaultShards
+	}
+
+	sl := &ShardedRateLimiter{shards: make([]*RateLimiter, shards)}
+	for i := range sl.shards {
+		sl.shards[i] = NewRateLimiter(maxLimit, timeLimit)
+	}
+
+	return sl
+}
+
+func (sl *ShardedRateLimiter) shard(key string) *RateLimiter {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return sl.shards[hash.Sum32()%uint32(len(sl.shards))]
+}
+
+func (sl *ShardedRateLimiter) Allow(key string) (Result, error) {
+	return sl.shard(key).Allow(key)
+}
+
+func (sl *ShardedRateLimiter) AllowN(key string, n int) (Result, error) {
+	return sl.shard(key).AllowN(key, n)
+}
+
+func (sl *ShardedRateLimiter) AllowCtx(ctx context.Context, key string) (Result, error) {
+	return sl.shard(key).AllowCtx(ctx, key)
+}
+
+func (sl *ShardedRateLimiter) Return(key string) {
+	sl.shard(key).Return(key)
+}
+
+func (sl *ShardedRateLimiter) Capacity(key string) int {
+	return sl.shard(key).Capacity(key)
+}
+
+func (sl *ShardedRateLimiter) Peek(key string) Result {
+	return sl.shard(key).Peek(key)
+}
+
+func (sl *ShardedRateLimiter) SetLimitConfig(key string, cfg LimitConfig) error {
+	return sl.shard(key).SetLimitConfig(key, cfg)
+}
+
+func (sl *ShardedRateLimiter) Reset(key string) error {
+	return sl.shard(key).Reset(key)
+}
+
+func (sl *ShardedRateLimiter) Stop() {
+	for _, shard := range sl.shards {
+		shard.Stop()
+	}
+}
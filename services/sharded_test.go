@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardedRateLimiter(t *testing.T) {
+	sl := NewShardedRateLimiter(8, 2, 60)
+	defer sl.Stop()
+
+	for i := range 2 {
+		if result, err := sl.Allow("key"); err != nil || !result.Allowed {
+			t.Fatalf("request %d: %+v, %v", i+1, result, err)
+		}
+	}
+	if result, _ := sl.Allow("key"); result.Allowed {
+		t.Errorf("third request = %+v, want a denial", result)
+	}
+	if peek := sl.Peek("other"); !peek.Allowed || peek.Remaining != 2 {
+		t.Errorf("Peek of another key = %+v, want it unaffected", peek)
+	}
+
+	if err := sl.Reset("key"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if result, _ := sl.Allow("key"); !result.Allowed {
+		t.Errorf("request after Reset = %+v, want it allowed", result)
+	}
+}
+
+func TestShardedRateLimiterRouting(t *testing.T) {
+	tests := []struct {
+		name       string
+		shards     int
+		wantShards int
+	}{
+		{name: "explicit", shards: 16, wantShards: 16},
+		{name: "single", shards: 1, wantShards: 1},
+		{name: "default", shards: 0, wantShards: defaultShards},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl := NewShardedRateLimiter(tt.shards, 1, 60)
+			defer sl.Stop()
+			if len(sl.shards) != tt.wantShards {
+				t.Fatalf("%d shards, want %d", len(sl.shards), tt.wantShards)
+			}
+
+			used := map[*RateLimiter]bool{}
+			for i := range 1_000 {
+				key := fmt.Sprintf("key-%d", i)
+				if sl.shard(key) != sl.shard(key) {
+					t.Fatalf("%s moved between shards", key)
+				}
+				used[sl.shard(key)] = true
+			}
+			if len(used) != tt.wantShards {
+				t.Errorf("1000 keys used %d of %d shards", len(used), tt.wantShards)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+type SlidingWindowLimiter struct {
+	requests  map[string]*windowLog
+	mutex     sync.Mutex
+	maxLimit  int
+	window    time.Duration
+	nextSweep time.Time
+}
+
+// windowLog is a ring buffer holding the timestamps of the requests allowed
+// within the current window, oldest first starting at head.
+type windowLog struct {
+	timestamps []time.Time
+	head       int
+	count      int
+}
+
+func NewSlidingWindowLimiter(maxLimit int, window time.Duration) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		requests: make(map[string]*windowLog),
+		maxLimit: maxLimit,
+		window:   window,
+	}
+}
+
+func (sw *SlidingWindowLimiter) Allow(apiKey string) (Result, error) {
+	return sw.AllowN(apiKey, 1)
+}
+
+func (sw *SlidingWindowLimiter) AllowN(apiKey string, n int) (Result, error) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := time.Now()
+	if n < 1 {
+		return Result{Limit: sw.maxLimit, Window: sw.window, ResetAt: now}, errInvalidCost(n)
+	}
+	if n > sw.maxLimit {
+		return Result{Limit: sw.maxLimit, Window: sw.window, ResetAt: now}, errCostExceedsLimit(n, sw.maxLimit)
+	}
+
+	if !now.Before(sw.nextSweep) {
+		sw.sweep(now)
+	}
+
+	entry, exists := sw.requests[apiKey]
+	if !exists {
+		entry = &windowLog{timestamps: make([]time.Time, sw.maxLimit)}
+		sw.requests[apiKey] = entry
+	}
+
+	entry.dropBefore(now.Add(-sw.window))
+
+	allowed := entry.count+n <= sw.maxLimit
+	if allowed {
+		for i := 0; i < n; i++ {
+			entry.timestamps[(entry.head+entry.count)%len(entry.timestamps)] = now
+			entry.count++
+		}
+	}
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     sw.maxLimit,
+		Window:    sw.window,
+		Remaining: sw.maxLimit - entry.count,
+		ResetAt:   entry.resetAt(now, sw.window),
+	}
+	if !allowed {
+		result.RetryAfter = entry.retryAfter(now, sw.window, entry.count+n-sw.maxLimit)
+	}
+
+	return result, nil
+}
+
+func (sw *SlidingWindowLimiter) Peek(apiKey string) Result {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	now := time.Now()
+	current := windowLog{}
+	if entry, exists := sw.requests[apiKey]; exists {
+		current = *entry
+		current.dropBefore(now.Add(-sw.window))
+	}
+
+	result := Result{
+		Allowed:   current.count < sw.maxLimit,
+		Limit:     sw.maxLimit,
+		Window:    sw.window,
+		Remaining: sw.maxLimit - current.count,
+		ResetAt:   current.resetAt(now, sw.window),
+	}
+	if !result.Allowed {
+		result.RetryAfter = current.retryAfter(now, sw.window, current.count+1-sw.maxLimit)
+	}
+
+	return result
+}
+
+// sweep forgets keys whose newest request has left the window: their log
+// is empty, as if never seen. Sweeping once a window keeps only keys active
+// within the last two.
+func (sw *SlidingWindowLimiter) sweep(now time.Time) {
+	cutoff := now.Add(-sw.window)
+	for apiKey, entry := range sw.requests {
+		if entry.count == 0 || !entry.newest().After(cutoff) {
+			delete(sw.requests, apiKey)
+		}
+	}
+	sw.nextSweep = now.Add(sw.window)
+}
+
+func (l *windowLog) newest() time.Time {
+	return l.timestamps[(l.head+l.count-1)%len(l.timestamps)]
+}
+
+func (l *windowLog) resetAt(now time.Time, window time.Duration) time.Time {
+	if l.count == 0 {
+		return now
+	}
+
+	return l.newest().Add(window)
+}
+
+// retryAfter is how long until the oldest excess entries leave the window.
+func (l *windowLog) retryAfter(now time.Time, window time.Duration, excess int) time.Duration {
+	if excess <= 0 || excess > l.count {
+		return 0
+	}
+
+	expiring := l.timestamps[(l.head+excess-1)%len(l.timestamps)]
+	return max(expiring.Add(window).Sub(now), 0)
+}
+
+func (l *windowLog) dropBefore(cutoff time.Time) {
+	for l.count > 0 && !l.timestamps[l.head].After(cutoff) {
+		l.head = (l.head + 1) % len(l.timestamps)
+		l.count--
+	}
+}
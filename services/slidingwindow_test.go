@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	sw := NewSlidingWindowLimiter(3, 100*time.Millisecond)
+
+	for i := range 3 {
+		result, err := sw.Allow("key")
+		if err != nil || !result.Allowed {
+			t.Fatalf("request %d: %+v, %v", i+1, result, err)
+		}
+		if result.Remaining != 2-i {
+			t.Errorf("request %d: Remaining = %d, want %d", i+1, result.Remaining, 2-i)
+		}
+	}
+
+	result, _ := sw.Allow("key")
+	if result.Allowed {
+		t.Fatal("fourth request allowed")
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 100*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want within the window", result.RetryAfter)
+	}
+	if other, _ := sw.Allow("other"); !other.Allowed {
+		t.Error("other key denied")
+	}
+
+	time.Sleep(result.RetryAfter + 10*time.Millisecond)
+	if result, _ := sw.Allow("key"); !result.Allowed {
+		t.Error("request after the window denied")
+	}
+}
+
+func TestSlidingWindowLimiterAllowN(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		wantAllowed bool
+		wantErr     error
+	}{
+		{name: "fits", n: 2, wantAllowed: true},
+		{name: "too many left", n: 3},
+		{name: "over the limit", n: 5, wantErr: ErrCostExceedsCapacity},
+		{name: "zero", n: 0, wantErr: ErrInvalidCost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := NewSlidingWindowLimiter(4, time.Minute)
+			sw.AllowN("key", 2)
+
+			result, err := sw.AllowN("key", tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if peek := sw.Peek("key"); !tt.wantAllowed && peek.Remaining != 2 {
+				t.Errorf("denied request changed the log: Remaining = %d, want 2", peek.Remaining)
+			}
+		})
+	}
+}
+
+func TestWindowLogWrapsAround(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	log := windowLog{timestamps: make([]time.Time, 3)}
+	add := func(at time.Time) {
+		log.timestamps[(log.head+log.count)%len(log.timestamps)] = at
+		log.count++
+	}
+
+	for i := range 3 {
+		add(start.Add(time.Duration(i) * time.Second))
+	}
+	log.dropBefore(start.Add(time.Second))
+	if log.count != 1 || log.head != 2 {
+		t.Fatalf("after dropping two: count %d, head %d", log.count, log.head)
+	}
+
+	add(start.Add(3 * time.Second))
+	add(start.Add(4 * time.Second))
+	if got := log.resetAt(start, 10*time.Second); !got.Equal(start.Add(14 * time.Second)) {
+		t.Errorf("resetAt = %v, want the newest entry plus the window", got)
+	}
+	if got := log.retryAfter(start.Add(5*time.Second), 10*time.Second, 1); got != 7*time.Second {
+		t.Errorf("retryAfter = %v, want 7s until the oldest entry leaves", got)
+	}
+}
+
+func TestSlidingWindowLimiterForgetsIdleKeys(t *testing.T) {
+	const window = 200 * time.Millisecond
+	sw := NewSlidingWindowLimiter(3, window)
+
+	for i := range 100 {
+		sw.Allow(fmt.Sprintf("idle-%d", i))
+	}
+	time.Sleep(window * 3 / 5)
+	sw.AllowN("busy", 3)
+
+	// The idle keys' requests have left the window, busy's have not.
+	time.Sleep(window * 3 / 5)
+	sw.Allow("new")
+
+	sw.mutex.Lock()
+	tracked := len(sw.requests)
+	sw.mutex.Unlock()
+	if tracked != 2 {
+		t.Errorf("%d keys tracked after a window idle, want busy and new", tracked)
+	}
+	if result, _ := sw.Allow("busy"); result.Allowed {
+		t.Errorf("sweep forgot busy's requests within the window: %+v", result)
+	}
+}
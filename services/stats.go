@@ -0,0 +1,112 @@
+package services
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type LimiterStats struct {
+	TotalAllowed uint64 `json:"totalAllowed"`
+	TotalDenied  uint64 `json:"totalDenied"`
+	ActiveKeys   uint64 `json:"activeKeys"`
+	EvictedKeys  uint64 `json:"evictedKeys"`
+}
+
+// limiterCounters are atomics so Stats can be read without the limiter's
+// mutex, including from code that already holds it.
+type limiterCounters struct {
+	allowed     atomic.Uint64
+	denied      atomic.Uint64
+	activeKeys  atomic.Uint64
+	evictedKeys atomic.Uint64
+}
+
+func (c *limiterCounters) record(result Result) {
+	if result.Allowed {
+		c.allowed.Add(1)
+	} else {
+		c.denied.Add(1)
+	}
+}
+
+func (rl *RateLimiter) Stats() LimiterStats {
+	return LimiterStats{
+		TotalAllowed: rl.stats.allowed.Load(),
+		TotalDenied:  rl.stats.denied.Load(),
+		ActiveKeys:   rl.stats.activeKeys.Load(),
+		EvictedKeys:  rl.stats.evictedKeys.Load(),
+	}
+}
+
+type KeyUsage struct {
+	Key             string    `json:"key"`
+	RequestsAllowed uint64    `json:"requestsAllowed"`
+	RequestsDenied  uint64    `json:"requestsDenied"`
+	LastSeen        time.Time `json:"lastSeen"`
+}
+
+type keyCounters struct {
+	allowed  atomic.Uint64
+	denied   atomic.Uint64
+	lastSeen atomic.Int64
+}
+
+// recordUsage expects the limiter's lock to be held, which guards creating
+// the counters; updating them needs no lock.
+func (m *RequestMetadata) recordUsage(allowed bool, now time.Time) {
+	if m.usage == nil {
+		m.usage = &keyCounters{}
+	}
+	m.usage.record(allowed, now)
+}
+
+func (c *keyCounters) record(allowed bool, now time.Time) {
+	if allowed {
+		c.allowed.Add(1)
+	} else {
+		c.denied.Add(1)
+	}
+	c.lastSeen.Store(now.UnixNano())
+}
+
+// SortedKeysByUsage returns the n tracked buckets with the most denied
+// requests, then the most allowed, to find abusive clients. n <= 0 returns
+// every bucket. Keys are as the limiter stores them, hashed or grouped, and
+// counts cover the bucket's life since it was created: an evicted or Reset
+// bucket starts again from zero.
+func (rl *RateLimiter) SortedKeysByUsage(n int) []KeyUsage {
+	rl.mutex.Lock()
+	counters := make(map[string]*keyCounters, len(rl.requests))
+	for key, metadata := range rl.requests {
+		if metadata.usage != nil {
+			counters[key] = metadata.usage
+		}
+	}
+	rl.mutex.Unlock()
+
+	usage := make([]KeyUsage, 0, len(counters))
+	for key, c := range counters {
+		usage = append(usage, KeyUsage{
+			Key:             key,
+			RequestsAllowed: c.allowed.Load(),
+			RequestsDenied:  c.denied.Load(),
+			LastSeen:        time.Unix(0, c.lastSeen.Load()),
+		})
+	}
+
+	slices.SortFunc(usage, func(a, b KeyUsage) int {
+		return cmp.Or(
+			cmp.Compare(b.RequestsDenied, a.RequestsDenied),
+			cmp.Compare(b.RequestsAllowed, a.RequestsAllowed),
+			strings.Compare(a.Key, b.Key),
+		)
+	})
+	if n > 0 && n < len(usage) {
+		usage = usage[:n]
+	}
+
+	return usage
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterStats(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+
+	steps := []struct {
+		name string
+		do   func()
+		want LimiterStats
+	}{
+		{
+			name: "first key",
+			do:   func() { rl.Allow("a") },
+			want: LimiterStats{TotalAllowed: 1, ActiveKeys: 1},
+		},
+		{
+			name: "first key over its limit",
+			do: func() {
+				for range 3 {
+					rl.Allow("a")
+				}
+			},
+			want: LimiterStats{TotalAllowed: 2, TotalDenied: 2, ActiveKeys: 1},
+		},
+		{
+			name: "second key",
+			do:   func() { rl.AllowN("b", 2) },
+			want: LimiterStats{TotalAllowed: 3, TotalDenied: 2, ActiveKeys: 2},
+		},
+		{
+			name: "peek counts nothing",
+			do:   func() { rl.Peek("a"); rl.Peek("c") },
+			want: LimiterStats{TotalAllowed: 3, TotalDenied: 2, ActiveKeys: 2},
+		},
+		{
+			name: "reset",
+			do:   func() { rl.Reset("b") },
+			want: LimiterStats{TotalAllowed: 3, TotalDenied: 2, ActiveKeys: 1},
+		},
+		{
+			name: "reset all",
+			do:   func() { rl.ResetAll() },
+			want: LimiterStats{TotalAllowed: 3, TotalDenied: 2},
+		},
+	}
+
+	for _, step := range steps {
+		step.do()
+		if got := rl.Stats(); got != step.want {
+			t.Errorf("after %s: Stats = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestSortedKeysByUsage(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 2, 60, LimiterOptions{})
+	requests := []struct {
+		key   string
+		count int
+	}{
+		{key: "light", count: 1},
+		{key: "heavy", count: 10},
+		{key: "medium", count: 5},
+	}
+	for _, r := range requests {
+		clock.Advance(time.Second)
+		for range r.count {
+			rl.Allow(r.key)
+		}
+	}
+
+	want := []KeyUsage{
+		{Key: "heavy", RequestsAllowed: 2, RequestsDenied: 8, LastSeen: time.Unix(1_700_000_002, 0)},
+		{Key: "medium", RequestsAllowed: 2, RequestsDenied: 3, LastSeen: time.Unix(1_700_000_003, 0)},
+		{Key: "light", RequestsAllowed: 1, RequestsDenied: 0, LastSeen: time.Unix(1_700_000_001, 0)},
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []KeyUsage
+	}{
+		{name: "top two", n: 2, want: want[:2]},
+		{name: "more than tracked", n: 10, want: want},
+		{name: "all", n: 0, want: want},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rl.SortedKeysByUsage(tt.n)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SortedKeysByUsage(%d) = %+v, want %+v", tt.n, got, tt.want)
+			}
+			for i := range got {
+				if got[i].Key != tt.want[i].Key || got[i].RequestsAllowed != tt.want[i].RequestsAllowed ||
+					got[i].RequestsDenied != tt.want[i].RequestsDenied || !got[i].LastSeen.Equal(tt.want[i].LastSeen) {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	rl.Reset("heavy")
+	if got := rl.SortedKeysByUsage(1); len(got) != 1 || got[0].Key != "medium" {
+		t.Errorf("top key after resetting heavy = %+v, want medium", got)
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	apistore "rate-limiter/api-store"
+)
+
+type keyStatus struct {
+	Key       string    `json:"key"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+}
+
+type statusResponse struct {
+	Keys  []keyStatus `json:"keys"`
+	Total int         `json:"total"`
+}
+
+// bucketState is a copy of one bucket taken under the limiter's lock, so
+// the refill maths and the encoding can run after it is released.
+type bucketState struct {
+	key      string
+	metadata RequestMetadata
+	limit    LimitConfig
+}
+
+func (rl *RateLimiter) bucketStates(filter string) ([]bucketState, time.Time) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if filter != "" {
+		filter = rl.hashKey(filter)
+	}
+
+	now := rl.clock.Now()
+	states := make([]bucketState, 0, len(rl.requests))
+	for key, metadata := range rl.requests {
+		if filter != "" && key != filter {
+			continue
+		}
+		states = append(states, bucketState{key: key, metadata: *metadata, limit: rl.limitFor(key, now)})
+	}
+
+	return states, now
+}
+
+// RequestMetadataView is a read-only copy of one bucket. TokenCount is the
+// count as of LastSeen, the time tokens were last added or taken; refill
+// since then is not applied.
+type RequestMetadataView struct {
+	LastSeen       time.Time `json:"lastSeen"`
+	TokenCount     int       `json:"tokenCount"`
+	EffectiveLimit int       `json:"effectiveLimit"`
+	WindowSeconds  int       `json:"windowSeconds"`
+}
+
+// Snapshot copies every tracked bucket, keyed as the limiter stores them:
+// hashed when a KeyHasher is set, and by group for grouped keys. Only the
+// copy into a flat slice runs under the limiter's lock; limits are looked
+// up and the map is built after releasing it.
+func (rl *RateLimiter) Snapshot() map[string]RequestMetadataView {
+	buckets, overrides, keys, now := rl.snapshotBuckets()
+
+	snapshot := make(map[string]RequestMetadataView, len(buckets))
+	for _, bucket := range buckets {
+		limit := rl.limitIn(overrides, keys, bucket.key, now)
+		snapshot[bucket.key] = RequestMetadataView{
+			LastSeen:       bucket.lastSeen,
+			TokenCount:     bucket.tokenCount,
+			EffectiveLimit: limit.MaxLimit,
+			WindowSeconds:  limit.WindowSeconds,
+		}
+	}
+
+	return snapshot
+}
+
+type bucketCounters struct {
+	key        string
+	lastSeen   time.Time
+	tokenCount int
+}
+
+// snapshotBuckets is the part of Snapshot that holds the lock. Reload
+// replaces the key table rather than changing it, so only the overrides
+// need copying.
+func (rl *RateLimiter) snapshotBuckets() ([]bucketCounters, map[string]LimitConfig, map[string]apistore.APIKeyConfig, time.Time) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	buckets := make([]bucketCounters, 0, len(rl.requests))
+	for key, metadata := range rl.requests {
+		buckets = append(buckets, bucketCounters{key: key, lastSeen: metadata.lastSeen, tokenCount: metadata.tokenCount})
+	}
+
+	return buckets, maps.Clone(rl.overrides), rl.keys, rl.clock.Now()
+}
+
+// SnapshotHandler serves Snapshot as JSON. AdminServer mounts it at
+// /admin/snapshot.
+func SnapshotHandler(limiter *RateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(limiter.Snapshot())
+	})
+}
+
+// StatusHandler lists the quota of every tracked bucket, sorted by key.
+// ?key= narrows it to one bucket and ?offset= / ?limit= page through the
+// rest. It exposes every client's usage, so mount it behind AdminServer.
+func StatusHandler(limiter *RateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		offset, ok := queryInt(query.Get("offset"), 0)
+		if !ok {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit, ok := queryInt(query.Get("limit"), -1)
+		if !ok {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+
+		states, now := limiter.bucketStates(query.Get("key"))
+		slices.SortFunc(states, func(a, b bucketState) int {
+			return strings.Compare(a.key, b.key)
+		})
+
+		response := statusResponse{Keys: []keyStatus{}, Total: len(states)}
+		states = states[min(offset, len(states)):]
+		if limit >= 0 {
+			states = states[:min(limit, len(states))]
+		}
+
+		for _, state := range states {
+			state.metadata.burst = state.limit.capacity()
+			limiter.refill(&state.metadata, state.limit, now)
+			response.Keys = append(response.Keys, keyStatus{
+				Key:       state.key,
+				Remaining: state.metadata.tokenCount,
+				ResetAt:   limiter.resetAt(&state.metadata, state.limit, now),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+func queryInt(value string, fallback int) (int, bool) {
+	if value == "" {
+		return fallback, true
+	}
+
+	n, err := strconv.Atoi(value)
+	return n, err == nil && n >= 0
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	rl.AllowN("apikey123", 2)
+	clock.Advance(time.Second)
+	rl.Allow("apikey124")
+
+	snapshot := rl.Snapshot()
+	want := map[string]RequestMetadataView{
+		"apikey123": {LastSeen: time.Unix(1_700_000_000, 0), TokenCount: 3, EffectiveLimit: 5, WindowSeconds: 60},
+		"apikey124": {LastSeen: time.Unix(1_700_000_001, 0), TokenCount: 4, EffectiveLimit: 5, WindowSeconds: 60},
+	}
+	if len(snapshot) != len(want) {
+		t.Fatalf("Snapshot() has %d keys, want %d", len(snapshot), len(want))
+	}
+	for key, view := range want {
+		got := snapshot[key]
+		if !got.LastSeen.Equal(view.LastSeen) || got.TokenCount != view.TokenCount ||
+			got.EffectiveLimit != view.EffectiveLimit || got.WindowSeconds != view.WindowSeconds {
+			t.Errorf("Snapshot()[%q] = %+v, want %+v", key, got, view)
+		}
+	}
+
+	// The snapshot is a copy: the limiter moves on without it.
+	rl.Allow("apikey123")
+	if got := snapshot["apikey123"].TokenCount; got != 3 {
+		t.Errorf("snapshot changed with the limiter: TokenCount = %d", got)
+	}
+	delete(snapshot, "apikey124")
+	if _, ok := rl.Snapshot()["apikey124"]; !ok {
+		t.Error("deleting from the snapshot removed the bucket")
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+	rl.Allow("apikey123")
+	handler := SnapshotHandler(rl)
+
+	tests := []struct {
+		name   string
+		method string
+		status int
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusOK},
+		{name: "post", method: http.MethodPost, status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/admin/snapshot", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			var snapshot map[string]RequestMetadataView
+			if err := json.NewDecoder(rec.Body).Decode(&snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if view := snapshot["apikey123"]; len(snapshot) != 1 || view.TokenCount != 4 || view.EffectiveLimit != 5 {
+				t.Errorf("snapshot = %+v", snapshot)
+			}
+		})
+	}
+}
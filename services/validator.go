@@ -0,0 +1,27 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Validator is middleware that rejects malformed requests before they reach
+// the rate limiter, so they are never charged a token. Pass it to
+// MiddlewareChain.Before.
+type Validator func(next http.Handler) http.Handler
+
+// RequiredHeaders replies 400 to requests missing any of headers.
+func RequiredHeaders(headers ...string) Validator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, header := range headers {
+				if r.Header.Get(header) == "" {
+					http.Error(w, fmt.Errorf("%w %q", ErrMissingHeader, header).Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
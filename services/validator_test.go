@@ -0,0 +1,53 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiredHeadersWithTenantKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenant   string
+		apiKey   string
+		wantCode int
+		wantBody string
+	}{
+		{name: "tenant and key", tenant: "acme", apiKey: "apikey123", wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "tenant without key", tenant: "acme", wantCode: http.StatusUnauthorized, wantBody: "Missing API key\n"},
+		{name: "tenant with invalid key", tenant: "acme", apiKey: "not-a-key", wantCode: http.StatusUnauthorized, wantBody: "Invalid API key\n"},
+		{name: "key without tenant", apiKey: "apikey123", wantCode: http.StatusBadRequest, wantBody: "missing header \"X-Tenant-ID\"\n"},
+		{name: "neither", wantCode: http.StatusBadRequest, wantBody: "missing header \"X-Tenant-ID\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := newTestRateLimiter(t, 5, 60, LimiterOptions{})
+			handler := (&MiddlewareChain{}).
+				Before(RequiredHeaders("X-Tenant-ID")).
+				Build(okHandler, rl, CompositeKeyExtractor("", HeaderExtractor("X-Tenant-ID"), APIKeyExtractor))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tenant != "" {
+				req.Header.Set("X-Tenant-ID", tt.tenant)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-KEY", tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if tt.wantCode == http.StatusOK {
+				if peek := rl.Peek("acme:apikey123"); peek.Remaining != 4 {
+					t.Errorf("tenant:key bucket = %+v, want one token taken", peek)
+				}
+			} else if stats := rl.Stats(); stats.TotalAllowed+stats.TotalDenied != 0 {
+				t.Errorf("rejected request reached the limiter: %+v", stats)
+			}
+		})
+	}
+}